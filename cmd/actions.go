@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// gitHubActions runs the jobs with GitHub Actions workflows
+type gitHubActions struct {
+	client   *github.Client
+	owner    string
+	repo     string
+	workflow string // workflow file started by trigger
+	interval time.Duration
+}
+
+// actionsJob is a GitHub Actions workflow run job
+type actionsJob struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`     // queued, in_progress or completed
+	Conclusion string `json:"conclusion"` // success, failure, skipped...
+	HTMLURL    string `json:"html_url"`
+}
+
+// trigger starts the workflow with a workflow_dispatch event
+func (a *gitHubActions) trigger(ctx context.Context, branch string) error {
+	path := fmt.Sprintf("repos/%s/%s/actions/workflows/%s/dispatches", a.owner, a.repo, url.PathEscape(a.workflow))
+	return a.do(ctx, http.MethodPost, path, map[string]string{"ref": branch}, nil)
+}
+
+// findJobs returns the jobs of the workflow runs for the commit
+func (a *gitHubActions) findJobs(ctx context.Context, branch, sha string, since time.Time) ([]ciJob, error) {
+	var runs struct {
+		WorkflowRuns []struct {
+			ID        int64     `json:"id"`
+			HeadSHA   string    `json:"head_sha"`
+			CreatedAt time.Time `json:"created_at"`
+		} `json:"workflow_runs"`
+	}
+	path := fmt.Sprintf("repos/%s/%s/actions/runs?branch=%s", a.owner, a.repo, url.QueryEscape(branch))
+	err := a.do(ctx, http.MethodGet, path, nil, &runs)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []ciJob
+	for _, run := range runs.WorkflowRuns {
+		if run.HeadSHA != sha || !run.CreatedAt.After(since) {
+			continue
+		}
+		var runJobs struct {
+			Jobs []actionsJob `json:"jobs"`
+		}
+		err = a.do(ctx, http.MethodGet, fmt.Sprintf("repos/%s/%s/actions/runs/%d/jobs", a.owner, a.repo, run.ID), nil, &runJobs)
+		if err != nil {
+			return nil, err
+		}
+		for _, j := range runJobs.Jobs {
+			jobs = append(jobs, j.ciJob())
+		}
+	}
+	return jobs, nil
+}
+
+func (a *gitHubActions) waitForJob(ctx context.Context, job ciJob, timeout time.Duration) (ciJob, error) {
+	return pollJob(ctx, job, timeout, a.interval, func(ctx context.Context, job ciJob) (ciJob, error) {
+		var j actionsJob
+		err := a.do(ctx, http.MethodGet, fmt.Sprintf("repos/%s/%s/actions/jobs/%s", a.owner, a.repo, job.id), nil, &j)
+		if err != nil {
+			return job, err
+		}
+		return j.ciJob(), nil
+	})
+}
+
+// setSecret creates or updates a repository secret, encrypted with the
+// repository's public key
+func (a *gitHubActions) setSecret(ctx context.Context, name, value string) error {
+	var key struct {
+		KeyID string `json:"key_id"`
+		Key   string `json:"key"`
+	}
+	err := a.do(ctx, http.MethodGet, fmt.Sprintf("repos/%s/%s/actions/secrets/public-key", a.owner, a.repo), nil, &key)
+	if err != nil {
+		return err
+	}
+	encrypted, err := sealSecret(value, key.Key)
+	if err != nil {
+		return err
+	}
+
+	return a.do(ctx, http.MethodPut, fmt.Sprintf("repos/%s/%s/actions/secrets/%s", a.owner, a.repo, name),
+		map[string]string{"encrypted_value": encrypted, "key_id": key.KeyID}, nil)
+}
+
+func (a *gitHubActions) deleteSecret(ctx context.Context, name string) error {
+	err := a.do(ctx, http.MethodDelete, fmt.Sprintf("repos/%s/%s/actions/secrets/%s", a.owner, a.repo, name), nil, nil)
+	if e, ok := err.(*github.ErrorResponse); ok && e.Response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// do sends a request to the GitHub Actions API with the GitHub client, so the
+// GitHub Enterprise URLs are used
+func (a *gitHubActions) do(ctx context.Context, method, path string, in, out interface{}) error {
+	req, err := a.client.NewRequest(method, path, in)
+	if err != nil {
+		return err
+	}
+	_, err = a.client.Do(ctx, req, out)
+	return err
+}
+
+// ciJob converts the job. Skipped and neutral jobs are not failures.
+func (j *actionsJob) ciJob() ciJob {
+	job := ciJob{id: strconv.FormatInt(j.ID, 10), name: j.Name, status: j.Status, url: j.HTMLURL}
+	if j.Status == "completed" {
+		job.finished = true
+		job.status = j.Conclusion
+		job.skipped = j.Conclusion == "skipped"
+		job.failed = j.Conclusion != "success" && !job.skipped && j.Conclusion != "neutral"
+	}
+	return job
+}
+
+// sealSecret encrypts the value for the base64 encoded public key with a
+// libsodium sealed box, as the GitHub secrets API requires
+func sealSecret(value, publicKey string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(b) != 32 {
+		return "", fmt.Errorf("invalid GitHub Actions public key %q", publicKey)
+	}
+	var recipient [32]byte
+	copy(recipient[:], b)
+
+	ephemeral, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	// The nonce is the BLAKE2b hash of the ephemeral and recipient public keys
+	h, err := blake2b.New(24, nil)
+	if err != nil {
+		return "", err
+	}
+	_, _ = h.Write(ephemeral[:])
+	_, _ = h.Write(recipient[:])
+	var nonce [24]byte
+	copy(nonce[:], h.Sum(nil))
+
+	sealed := box.Seal(ephemeral[:], []byte(value), &nonce, &recipient, private)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// fakeActions serves the workflow runs and secrets of the GSA/test-repo
+// repository, recording the secrets that are set. Run 7 is for the merge
+// commit fed789 of the pull request with head abc123.
+func fakeActions(t *testing.T, merged time.Time, publicKey *[32]byte) (*httptest.Server, map[string]map[string]string) {
+	secrets := map[string]map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const repo = "/repos/GSA/test-repo/actions"
+		var body interface{}
+		switch r.Method + " " + r.URL.Path {
+		case "POST " + repo + "/workflows/terraform.yml/dispatches":
+			var b map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&b)
+			if b["ref"] != "main" {
+				t.Errorf("unexpected workflow dispatch: %v", b)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case "GET " + repo + "/runs":
+			body = map[string]interface{}{"workflow_runs": []map[string]interface{}{
+				{"id": 8, "head_sha": "abc123", "created_at": merged.Add(time.Minute)},
+				{"id": 7, "head_sha": "fed789", "created_at": merged.Add(time.Minute)},
+				{"id": 6, "head_sha": "fed789", "created_at": merged.Add(-time.Hour)},
+			}}
+		case "GET " + repo + "/runs/7/jobs":
+			body = map[string]interface{}{"jobs": []map[string]interface{}{
+				{"id": 71, "name": "plan_terraform", "status": "completed", "conclusion": "success"},
+				{"id": 72, "name": "apply_terraform", "status": "in_progress"},
+			}}
+		case "GET " + repo + "/jobs/72":
+			body = map[string]interface{}{"id": 72, "name": "apply_terraform", "status": "completed", "conclusion": "success"}
+		case "GET " + repo + "/secrets/public-key":
+			body = map[string]string{"key_id": "k1", "key": base64.StdEncoding.EncodeToString(publicKey[:])}
+		case "PUT " + repo + "/secrets/TF_VAR_test_dev_db_password":
+			var b map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&b)
+			secrets["TF_VAR_test_dev_db_password"] = b
+			w.WriteHeader(http.StatusCreated)
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			body = map[string]string{"message": "Not Found"}
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	return ts, secrets
+}
+
+// nolint: funlen
+func TestGitHubActions(t *testing.T) {
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	merged := time.Now().Add(-time.Minute)
+	ts, secrets := fakeActions(t, merged, public)
+	defer ts.Close()
+
+	c := defaultConfig()
+	c.CIRunner, c.WorkflowJobs = actionsRunner, 2
+	host, err := newGitHub(&config{Owner: "GSA", GitHubURL: ts.URL, GitHubAPIURL: ts.URL + "/"}, "test")
+	if err != nil {
+		t.Fatalf("newGitHub() failed: unexpected error: %v", err)
+	}
+	ci, err := newCIRunner(c, host, "test-repo")
+	if err != nil {
+		t.Fatalf("newCIRunner() failed: unexpected error: %v", err)
+	}
+	ci.(*gitHubActions).interval = time.Millisecond
+	r := &req{ci: ci, config: c, repoName: "test-repo"}
+
+	_, err = r.triggerApply("main", "abc123")
+	if err != nil {
+		t.Fatalf("triggerApply() failed: unexpected error: %v", err)
+	}
+	err = r.waitForMergedApply(&pullRequest{Base: "main", HeadSHA: "abc123", MergeCommitSHA: "fed789", MergedAt: merged})
+	if err != nil {
+		t.Errorf("waitForMergedApply() failed: unexpected error: %v", err)
+	}
+
+	s := &ciStore{ci: ci, runner: actionsRunner, repo: "test-repo"}
+	err = s.put(context.Background(), "test-dev", "secret")
+	if err != nil {
+		t.Fatalf("put() failed: unexpected error: %v", err)
+	}
+	got := secrets["TF_VAR_test_dev_db_password"]
+	sealed, _ := base64.StdEncoding.DecodeString(got["encrypted_value"])
+	if got["key_id"] != "k1" || len(sealed) < 32 {
+		t.Fatalf("put() failed: unexpected secret: %v", got)
+	}
+	var ephemeral [32]byte
+	copy(ephemeral[:], sealed)
+	h, _ := blake2b.New(24, nil)
+	_, _ = h.Write(ephemeral[:])
+	_, _ = h.Write(public[:])
+	var nonce [24]byte
+	copy(nonce[:], h.Sum(nil))
+	plain, ok := box.Open(nil, sealed[32:], &nonce, &ephemeral, private)
+	if !ok || string(plain) != "secret" {
+		t.Errorf("put() failed: unable to decrypt secret, got: %q", plain)
+	}
+
+	err = s.remove(context.Background(), "test-missing")
+	if err != nil {
+		t.Errorf("remove() failed: unexpected error for missing secret: %v", err)
+	}
+
+	_, err = newCIRunner(c, newGitLab("https://gitlab.com", "GSA", "test", retryPolicy{}), "test-repo")
+	if err == nil || err.Error() != "ci_runner github-actions needs code_host github" {
+		t.Errorf("newCIRunner() failed: expected code host error, got: %v", err)
+	}
+}
+
+func TestActionsJob(t *testing.T) {
+	tt := map[string]struct {
+		job      actionsJob
+		expected ciJob
+	}{
+		"queued": {job: actionsJob{ID: 1, Status: "queued"}, expected: ciJob{id: "1", status: "queued"}},
+		"success": {
+			job:      actionsJob{ID: 1, Status: "completed", Conclusion: "success"},
+			expected: ciJob{id: "1", status: "success", finished: true},
+		},
+		"skipped": {
+			job:      actionsJob{ID: 1, Status: "completed", Conclusion: "skipped"},
+			expected: ciJob{id: "1", status: "skipped", finished: true, skipped: true},
+		},
+		"failure": {
+			job:      actionsJob{ID: 1, Status: "completed", Conclusion: "failure"},
+			expected: ciJob{id: "1", status: "failure", finished: true, failed: true},
+		},
+	}
+	for name, tc := range tt {
+		if got := tc.job.ciJob(); got != tc.expected {
+			t.Errorf("ciJob() failed: %s: expected %+v, got: %+v", name, tc.expected, got)
+		}
+	}
+
+	_, err := sealSecret("secret", "c2hvcnQ=")
+	if err == nil {
+		t.Errorf("sealSecret() failed: expected error for short key")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	_ "embed" // Embeds the default engine catalog
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const catalogVersion = 1 // Supported catalog file format version
+
+// defaultCatalog is used when no -catalog file is given
+//
+//go:embed catalog.yaml
+var defaultCatalog []byte // nolint: gochecknoglobals
+
+// sizeNames are the size tiers every engine must define
+func sizeNames() []string {
+	return []string{"small", "medium", "large"}
+}
+
+// catalog of supported RDS engine families
+type catalog struct {
+	Version int                    `json:"version" yaml:"version"`
+	Engines map[string]*engineSpec `json:"engines" yaml:"engines"`
+}
+
+// engineSpec defines the defaults for an RDS engine family
+type engineSpec struct {
+	Description                  string                       `json:"description" yaml:"description"`
+	Engine                       string                       `json:"engine" yaml:"engine"`
+	EngineVersion                string                       `json:"engine_version" yaml:"engine_version"`
+	Family                       string                       `json:"family" yaml:"family"`
+	MajorEngineVersion           string                       `json:"major_engine_version" yaml:"major_engine_version"`
+	Port                         int                          `json:"port" yaml:"port"`
+	EnabledCloudwatchLogsExports []string                     `json:"enabled_cloudwatch_logs_exports" yaml:"enabled_cloudwatch_logs_exports"`
+	Sizes                        map[string]sizeTier          `json:"sizes" yaml:"sizes"`
+	Password                     *passwordPolicy              `json:"password,omitempty" yaml:"password,omitempty"`
+	Upgrades                     map[string]upgradePolicy     `json:"upgrades,omitempty" yaml:"upgrades,omitempty"`
+	Parameters                   []dbParameter                `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Options                      []dbOption                   `json:"options,omitempty" yaml:"options,omitempty"`
+	Overrides                    map[string]parameterOverride `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+}
+
+// upgradePolicy defines how instances are upgraded to another engine family
+type upgradePolicy struct {
+	ApplyImmediately bool `json:"apply_immediately" yaml:"apply_immediately"` // false waits for the maintenance window
+}
+
+// sizeTier defines the instance class and storage for a RITM size
+type sizeTier struct {
+	InstanceClass    string `json:"instance_class" yaml:"instance_class"`
+	AllocatedStorage int    `json:"allocated_storage" yaml:"allocated_storage"`
+}
+
+// loadCatalog reads and validates the catalog file at path, or the embedded
+// default catalog if path is empty
+func loadCatalog(path string) (*catalog, error) {
+	if path == "" {
+		return parseCatalog(defaultCatalog, false)
+	}
+
+	fmt.Printf("Loading engine catalog from: %s\n", path)
+	b, err := ioutil.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parseCatalog(b, strings.EqualFold(filepath.Ext(path), ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// parseCatalog parses a YAML or JSON catalog, rejecting unknown fields
+func parseCatalog(b []byte, isJSON bool) (*catalog, error) {
+	var c catalog
+	var err error
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&c)
+	} else {
+		err = yaml.UnmarshalStrict(b, &c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// validate checks the catalog version and every engine family
+func (c *catalog) validate() error {
+	if c.Version != catalogVersion {
+		return fmt.Errorf("unsupported catalog version %d, expected %d", c.Version, catalogVersion)
+	}
+	if len(c.Engines) == 0 {
+		return fmt.Errorf("no engines defined")
+	}
+
+	var errs []string
+	for _, family := range c.families() {
+		e := c.Engines[family]
+		for _, msg := range append(e.validate(family), c.validateUpgrades(e)...) {
+			errs = append(errs, family+": "+msg)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (e *engineSpec) validate(family string) []string {
+	if e == nil {
+		return []string{"empty definition"}
+	}
+
+	var errs []string
+	rules, ok := engineNamingRules(e.Engine)
+	if !ok {
+		errs = append(errs, fmt.Sprintf("unsupported engine %q", e.Engine))
+	} else {
+		errs = append(errs, e.passwordPolicy().validate(rules)...)
+	}
+	errs = append(errs, e.validateGroups()...)
+	if e.Family != family {
+		errs = append(errs, fmt.Sprintf("family %q does not match catalog key", e.Family))
+	}
+	if e.MajorEngineVersion == "" || !strings.HasPrefix(e.EngineVersion, e.MajorEngineVersion+".") {
+		errs = append(errs, fmt.Sprintf("engine_version %q is not a %q version", e.EngineVersion, e.MajorEngineVersion))
+	}
+	if e.Port < 1 || e.Port > maxPort {
+		errs = append(errs, fmt.Sprintf("invalid port %d", e.Port))
+	}
+	for _, name := range sizeNames() {
+		size, ok := e.Sizes[name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Sprintf("missing %s size", name))
+		case !strings.HasPrefix(size.InstanceClass, "db."):
+			errs = append(errs, fmt.Sprintf("%s size has invalid instance_class %q", name, size.InstanceClass))
+		case size.AllocatedStorage < 20:
+			errs = append(errs, fmt.Sprintf("%s size allocated_storage must be at least 20 GiB", name))
+		}
+	}
+	return errs
+}
+
+// validateUpgrades checks that each upgrade target is a newer major version
+// of the same engine
+func (c *catalog) validateUpgrades(e *engineSpec) []string {
+	if e == nil {
+		return nil
+	}
+
+	var errs []string
+	for _, family := range e.upgradeTargets() {
+		target, ok := c.Engines[family]
+		switch {
+		case !ok || target == nil:
+			errs = append(errs, fmt.Sprintf("upgrade target %q not found in catalog", family))
+		case target.Engine != e.Engine:
+			errs = append(errs, fmt.Sprintf("upgrade target %q is a %s engine", family, target.Engine))
+		case compareVersions(target.MajorEngineVersion, e.MajorEngineVersion) <= 0:
+			errs = append(errs, fmt.Sprintf("upgrade target %q is not a newer major version", family))
+		}
+	}
+	return errs
+}
+
+// upgradeTargets returns the families the engine can be upgraded to, sorted
+func (e *engineSpec) upgradeTargets() []string {
+	targets := make([]string, 0, len(e.Upgrades))
+	for family := range e.Upgrades {
+		targets = append(targets, family)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// passwordPolicy returns the engine's password policy, or the default policy
+// if the catalog does not define one
+func (e *engineSpec) passwordPolicy() passwordPolicy {
+	if e.Password == nil {
+		return defaultPasswordPolicy()
+	}
+	return *e.Password
+}
+
+// families returns the catalog's engine families in sorted order
+func (c *catalog) families() []string {
+	families := make([]string, 0, len(c.Engines))
+	for family := range c.Engines {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	return families
+}
+
+// engine returns the specification for an engine family
+func (c *catalog) engine(family string) (*engineSpec, error) {
+	e, ok := c.Engines[family]
+	if !ok {
+		return nil, fmt.Errorf("engine %q not found in catalog", family)
+	}
+	return e, nil
+}
+
+// engineFor returns the engine family of an engine and major version
+func (c *catalog) engineFor(engine, major string) (*engineSpec, error) {
+	for _, family := range c.families() {
+		if e := c.Engines[family]; e.Engine == engine && e.MajorEngineVersion == major {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("no catalog engine family for %s %s", engine, major)
+}
+
+// compareVersions compares dotted numeric engine versions, returning -1, 0 or
+// 1 if a is older than, the same as or newer than b
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// size returns the size tier for the named size
+func (e *engineSpec) size(name string) (sizeTier, error) {
+	s, ok := e.Sizes[name]
+	if !ok {
+		return s, fmt.Errorf("size %q not defined for engine %s", name, e.Family)
+	}
+	return s, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testCatalog(t *testing.T) *catalog {
+	c, err := loadCatalog("")
+	if err != nil {
+		t.Fatalf("loadCatalog() failed: unable to load default catalog: %v", err)
+	}
+	return c
+}
+
+func TestLoadCatalog(t *testing.T) {
+	c := testCatalog(t)
+	if c.Engines["mysql5.7"].EngineVersion != "5.7.30" {
+		t.Errorf("loadCatalog() failed: incorrect MySQL5.7 version. Expected: %s Got: %s",
+			"5.7.30", c.Engines["mysql5.7"].EngineVersion)
+	}
+
+	c, err := loadCatalog(filepath.Join("testdata", "catalog.json"))
+	if err != nil {
+		t.Fatalf("loadCatalog() failed: unexpected error: %v", err)
+	}
+	spec, err := c.engine("postgres13")
+	if err != nil {
+		t.Fatalf("loadCatalog() failed: unexpected error: %v", err)
+	}
+	size, err := spec.size("medium")
+	if err != nil || size.AllocatedStorage != 40 {
+		t.Errorf("loadCatalog() failed: incorrect postgres13 medium size: %+v %v", size, err)
+	}
+
+	_, err = loadCatalog(filepath.Join("testdata", "missing.yaml"))
+	if err == nil {
+		t.Errorf("loadCatalog() failed: expected error for missing file")
+	}
+}
+
+func TestParseCatalog(t *testing.T) {
+	tt := map[string]struct {
+		yaml string
+		err  string
+	}{
+		"bad version": {
+			yaml: "version: 2\nengines: {}\n",
+			err:  "unsupported catalog version 2",
+		},
+		"no engines": {
+			yaml: "version: 1\n",
+			err:  "no engines defined",
+		},
+		"unknown field": {
+			yaml: "version: 1\nengine: {}\n",
+			err:  "field engine not found",
+		},
+		"invalid engine": {
+			yaml: `version: 1
+engines:
+  mariadb10.5:
+    engine: mariadb
+    engine_version: 10.4.13
+    family: mariadb10.4
+    major_engine_version: "10.5"
+    port: 3306
+    sizes:
+      small: {instance_class: db.m5.large, allocated_storage: 10}
+      medium: {instance_class: m5.xlarge, allocated_storage: 100}
+`,
+			err: `mariadb10.5: family "mariadb10.4" does not match catalog key; ` +
+				`mariadb10.5: engine_version "10.4.13" is not a "10.5" version; ` +
+				`mariadb10.5: small size allocated_storage must be at least 20 GiB; ` +
+				`mariadb10.5: medium size has invalid instance_class "m5.xlarge"; ` +
+				`mariadb10.5: missing large size`,
+		},
+		"invalid upgrades": {
+			yaml: `version: 1
+engines:
+  postgres12:
+    engine: postgres
+    engine_version: "12.3"
+    family: postgres12
+    major_engine_version: "12"
+    port: 5432
+    sizes:
+      small: {instance_class: db.m5.large, allocated_storage: 20}
+      medium: {instance_class: db.m5.xlarge, allocated_storage: 40}
+      large: {instance_class: db.m5.2xlarge, allocated_storage: 100}
+    upgrades:
+      mysql8.0: {apply_immediately: true}
+      postgres12: {apply_immediately: true}
+      postgres13: {apply_immediately: true}
+`,
+			err: `postgres12: upgrade target "mysql8.0" not found in catalog; ` +
+				`postgres12: upgrade target "postgres12" is not a newer major version; ` +
+				`postgres12: upgrade target "postgres13" not found in catalog`,
+		},
+		"invalid groups": {
+			yaml: `version: 1
+engines:
+  postgres12:
+    engine: postgres
+    engine_version: "12.3"
+    family: postgres12
+    major_engine_version: "12"
+    port: 5432
+    sizes:
+      small: {instance_class: db.m5.large, allocated_storage: 20}
+      medium: {instance_class: db.m5.xlarge, allocated_storage: 40}
+      large: {instance_class: db.m5.2xlarge, allocated_storage: 100}
+    parameters:
+      - {name: rds.force_ssl, value: "1"}
+      - {name: rds.force_ssl, value: "0", apply_method: later}
+    options:
+      - option_settings: [{name: a, value: b}]
+    overrides:
+      rds.force_ssl: {pattern: "0|1"}
+      work_mem: {pattern: "[0-9"}
+`,
+			err: `postgres12: parameter rds.force_ssl is defined more than once; ` +
+				`postgres12: parameter rds.force_ssl has invalid apply_method "later"; ` +
+				`postgres12: option_name must be set; ` +
+				`postgres12: override rds.force_ssl would replace a catalog parameter; ` +
+				`postgres12: override work_mem has invalid pattern "[0-9"`,
+		},
+		"invalid password policy": {
+			yaml: `version: 1
+engines:
+  mysql8.0:
+    engine: mysql
+    engine_version: 8.0.20
+    family: mysql8.0
+    major_engine_version: "8.0"
+    port: 3306
+    sizes:
+      small: {instance_class: db.m5.large, allocated_storage: 50}
+      medium: {instance_class: db.m5.xlarge, allocated_storage: 100}
+      large: {instance_class: db.m5.2xlarge, allocated_storage: 300}
+    password: {length: 48, min_lower: 20, min_upper: 20, min_number: 10, min_special: 2, special: "!@#"}
+`,
+			err: `mysql8.0: password length must be between 8 and 41, got 48; ` +
+				`mysql8.0: password minimums exceed the password length; ` +
+				`mysql8.0: password special characters must not include '@'`,
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			_, err := parseCatalog([]byte(tc.yaml), false)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("parseCatalog() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestEngineFor(t *testing.T) {
+	c := testCatalog(t)
+	e, err := c.engineFor("postgres", "12")
+	if err != nil || e.Family != "postgres12" {
+		t.Errorf("engineFor() failed: expected postgres12, got: %+v %v", e, err)
+	}
+	_, err = c.engineFor("postgres", "9.6")
+	if err == nil || err.Error() != "no catalog engine family for postgres 9.6" {
+		t.Errorf("engineFor() failed: unexpected error: %v", err)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tt := map[string]struct {
+		a, b     string
+		expected int
+	}{
+		"equal":          {a: "12.3", b: "12.3", expected: 0},
+		"older":          {a: "12.2", b: "12.3", expected: -1},
+		"newer numeric":  {a: "12.10", b: "12.9", expected: 1},
+		"longer version": {a: "5.7.30", b: "5.7", expected: 1},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			if got := compareVersions(tc.a, tc.b); got != tc.expected {
+				t.Errorf("compareVersions() failed: expected %d, got: %d", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// CI runners applying the merged Terraform
+const (
+	circleCIRunner = "circleci"
+	actionsRunner  = "github-actions"
+	localRunner    = "local"
+)
+
+const ciPollInterval = 5 * time.Second // how often the CI runners are polled
+
+// ciJob is a job the CI runner ran for a commit
+type ciJob struct {
+	id       string // runner specific job identifier
+	name     string
+	status   string // runner specific status, for progress messages
+	url      string // web page of the job, if the runner has one
+	workflow string // workflow the job is looked up in, if the runner has no other way to fetch it
+	approval bool   // waits for a person to approve the rest of the workflow
+	finished bool
+	failed   bool
+	skipped  bool // finished without running, which fails the apply job
+}
+
+// ciRunner runs the Terraform jobs of the repository
+type ciRunner interface {
+	// trigger starts the apply workflow on the branch
+	trigger(ctx context.Context, branch string) error
+	// findJobs returns the jobs for the commit on the branch that started
+	// after since
+	findJobs(ctx context.Context, branch, sha string, since time.Time) ([]ciJob, error)
+	// waitForJob waits for the job to finish, returning the finished job. A
+	// timeout of 0 waits until the context is done.
+	waitForJob(ctx context.Context, job ciJob, timeout time.Duration) (ciJob, error)
+	// setSecret stores a secret the jobs read as an environment variable
+	setSecret(ctx context.Context, name, value string) error
+	// deleteSecret deletes the secret, if it exists
+	deleteSecret(ctx context.Context, name string) error
+}
+
+// newCIRunner returns the CI runner of the configuration for the repository.
+// Nothing is contacted until it is used.
+func newCIRunner(c *config, host codeHost, repo string) (ciRunner, error) {
+	switch c.CIRunner {
+	case actionsRunner:
+		gh, ok := host.(*gitHub)
+		if !ok {
+			return nil, fmt.Errorf("ci_runner %s needs code_host %s", actionsRunner, gitHubHost)
+		}
+		return &gitHubActions{client: gh.client, owner: gh.owner, repo: repo, workflow: c.ActionsWorkflow,
+			interval: ciPollInterval}, nil
+	case localRunner:
+		return &localTerraform{host: host, repo: repo, job: c.ApplyJob, applied: map[string]ciJob{}}, nil
+	}
+	return &circleCI{url: c.CircleCIURL, slug: "gh/" + c.CircleOrg + "/" + repo, token: os.Getenv("CIRCLE_TOKEN"),
+		interval: ciPollInterval, client: newHTTPClient(circleTimeout, newRetryPolicy(c.MaxAttempts))}, nil
+}
+
+// waitForMergedApply waits for the apply job for the merged pull request
+func (r *req) waitForMergedApply(pr *pullRequest) error {
+	return r.waitForApply(pr.Base, pr.mergedSHA(), pr.MergedAt) // Only interested in jobs that started after merge
+}
+
+// triggerApply starts the apply workflow for the sha on the branch, returning
+// the time just before it was triggered. An attempt failing with a transient
+// error may have started it anyway, so the jobs for the sha are looked up
+// before trying again.
+func (r *req) triggerApply(branch, sha string) (time.Time, error) {
+	ctx := r.context()
+	start := time.Now()
+	fmt.Printf("Triggering %s job on %s branch of %s\n", r.config.ApplyJob, branch, r.repoName)
+	return start, r.retry.call(ctx, func() error {
+		return r.ci.trigger(ctx, branch)
+	}, func() (bool, error) {
+		jobs, err := r.ci.findJobs(ctx, branch, sha, start)
+		return len(jobs) > 0, err
+	})
+}
+
+// waitForApply waits for the apply job for the sha on the branch that started
+// after since, and the other jobs of its workflow, to finish. The other jobs
+// may each take jobTimeout, the apply job and any approval jobs are only
+// limited by the context.
+func (r *req) waitForApply(branch, sha string, since time.Time) error {
+	ctx := r.context()
+	fmt.Printf("Waiting for %s job to complete\n", r.config.ApplyJob)
+	noted := false
+	for {
+		jobs, err := r.ci.findJobs(ctx, branch, sha, since)
+		if err != nil {
+			return err
+		}
+
+		applied := false
+		for i, job := range jobs {
+			fmt.Printf("%d) Job: %s SHA: %s Status: %s\n", i, job.name, sha, job.status)
+			timeout := r.jobTimeout
+			if job.approval {
+				timeout = 0
+			}
+			if job.name == r.config.ApplyJob {
+				timeout = 0
+				applied = true
+				if !noted {
+					r.noteRITM("The RDS instances are being created",
+						strings.TrimSuffix("Terraform apply started: "+job.url, ": "))
+					noted = true
+				}
+			}
+			job, err = r.ci.waitForJob(ctx, job, timeout)
+			if err != nil {
+				return err
+			}
+			if job.failed || (job.skipped && job.name == r.config.ApplyJob) {
+				return fmt.Errorf("%s %s", job.name, job.status)
+			}
+		}
+		if applied && len(jobs) >= r.config.WorkflowJobs {
+			r.noteRITM("The RDS instances have been created", "Terraform apply finished")
+			return nil
+		}
+		err = sleep(ctx, ciPollInterval)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// pollJob calls get every interval until the job is finished, returning an
+// error if it takes longer than timeout, or 0 to wait until ctx is done
+func pollJob(ctx context.Context, job ciJob, timeout, interval time.Duration,
+	get func(context.Context, ciJob) (ciJob, error)) (ciJob, error) {
+	wait := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		wait, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for count := 0; !job.finished; count++ {
+		if count%10 == 0 {
+			fmt.Printf("waiting for job %s [%s] to finish\n", job.name, job.id)
+		}
+		err := sleep(wait, interval)
+		if err == nil {
+			job, err = get(wait, job)
+		}
+		if err != nil && ctx.Err() == nil && wait.Err() != nil {
+			return job, fmt.Errorf("job timeout exceeded while waiting for job %s [%s] to finish", job.name, job.id)
+		}
+		if err != nil {
+			return job, err
+		}
+	}
+	fmt.Printf("job %s [%s] finished with status %s\n", job.name, job.id, job.status)
+	return job, nil
+}
+
+// sleep waits for d, returning the context's error if it is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
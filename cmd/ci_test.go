@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testRunner returns jobs, finishing each one when it is waited for
+type testRunner struct {
+	jobs      []ciJob
+	triggered []string
+	found     []string // commits the jobs were looked up for
+	waited    []string
+	err       error
+}
+
+func (r *testRunner) trigger(ctx context.Context, branch string) error {
+	r.triggered = append(r.triggered, branch)
+	return r.err
+}
+
+func (r *testRunner) findJobs(ctx context.Context, branch, sha string, since time.Time) ([]ciJob, error) {
+	r.found = append(r.found, sha)
+	return r.jobs, r.err
+}
+
+func (r *testRunner) waitForJob(ctx context.Context, job ciJob, timeout time.Duration) (ciJob, error) {
+	r.waited = append(r.waited, fmt.Sprintf("%s %s", job.name, timeout))
+	job.finished = true
+	return job, nil
+}
+
+func (r *testRunner) setSecret(ctx context.Context, name, value string) error { return r.err }
+
+func (r *testRunner) deleteSecret(ctx context.Context, name string) error { return r.err }
+
+func TestWaitForApply(t *testing.T) {
+	snow := fakeSnow(t)
+	defer snow.Close()
+
+	tt := map[string]struct {
+		merge  string // merge commit, none after a fast-forward merge
+		jobs   []ciJob
+		found  string // commit the jobs are looked up for
+		waited []string
+		notes  []string
+		err    string
+	}{
+		"applied": {
+			merge: "fed789",
+			jobs: []ciJob{{name: "validate"}, {name: "plan_terraform"}, {name: "hold", approval: true},
+				{name: "apply_terraform", url: "https://ci.example.gov/42"}},
+			found:  "fed789",
+			waited: []string{"validate 5m0s", "plan_terraform 5m0s", "hold 0s", "apply_terraform 0s"},
+			notes:  []string{"Terraform apply started: https://ci.example.gov/42", "Terraform apply finished"},
+		},
+		"lint skipped": {
+			jobs:   []ciJob{{name: "lint", status: "not_run", finished: true, skipped: true}, {name: "apply_terraform"}},
+			found:  "abc123",
+			waited: []string{"lint 5m0s", "apply_terraform 0s"},
+			notes:  []string{"Terraform apply started", "Terraform apply finished"},
+		},
+		"apply skipped": {
+			jobs:   []ciJob{{name: "apply_terraform", url: "https://ci.example.gov/44", status: "not_run", finished: true, skipped: true}},
+			found:  "abc123",
+			waited: []string{"apply_terraform 0s"},
+			notes:  []string{"Terraform apply started: https://ci.example.gov/44"},
+			err:    "apply_terraform not_run",
+		},
+		"plan failed": {
+			jobs:   []ciJob{{name: "plan_terraform", status: "failed", finished: true, failed: true}, {name: "apply_terraform"}},
+			found:  "abc123",
+			waited: []string{"plan_terraform 5m0s"},
+			err:    "plan_terraform failed",
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			c := defaultConfig()
+			c.WorkflowJobs = len(tc.jobs)
+			ci := &testRunner{jobs: tc.jobs}
+			r := &req{ci: ci, config: c, repoName: "test-repo", timeoutOptions: timeoutOptions{jobTimeout: 5 * time.Minute},
+				ritm: &ritm{Number: "RITM0001001", SysID: testSysID}, snowClient: snow.client()}
+			snow.updates = nil
+
+			err := r.waitForMergedApply(&pullRequest{Base: "master", HeadSHA: "abc123", MergeCommitSHA: tc.merge, MergedAt: time.Now()})
+			if tc.err == "" && err != nil {
+				t.Errorf("waitForMergedApply() failed: unexpected error: %v", err)
+			} else if tc.err != "" && (err == nil || err.Error() != tc.err) {
+				t.Errorf("waitForMergedApply() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+			if len(ci.found) != 1 || ci.found[0] != tc.found {
+				t.Errorf("waitForMergedApply() failed: expected jobs for %s, looked up: %v", tc.found, ci.found)
+			}
+			if fmt.Sprint(ci.waited) != fmt.Sprint(tc.waited) {
+				t.Errorf("waitForMergedApply() failed: expected to wait for %v, waited for %v", tc.waited, ci.waited)
+			}
+			var notes []string
+			for _, u := range snow.updates {
+				notes = append(notes, fmt.Sprint(u["work_notes"]))
+				if u["comments"] == nil {
+					t.Errorf("waitForMergedApply() failed: expected a comment with work note %v", u["work_notes"])
+				}
+			}
+			if fmt.Sprint(notes) != fmt.Sprint(tc.notes) {
+				t.Errorf("waitForMergedApply() failed: expected work notes %q, got: %q", tc.notes, notes)
+			}
+		})
+	}
+}
+
+func TestPollJob(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	job, err := pollJob(ctx, ciJob{id: "1", name: "apply_terraform"}, time.Minute, time.Millisecond,
+		func(ctx context.Context, job ciJob) (ciJob, error) {
+			calls++
+			job.finished = calls == 3
+			return job, nil
+		})
+	if err != nil || !job.finished || calls != 3 {
+		t.Errorf("pollJob() failed: expected finished job after 3 calls, got: %+v after %d, %v", job, calls, err)
+	}
+
+	unfinished := func(ctx context.Context, job ciJob) (ciJob, error) { return job, nil }
+	_, err = pollJob(ctx, ciJob{id: "2", name: "apply_terraform"}, time.Millisecond, 2*time.Millisecond, unfinished)
+	if err == nil || err.Error() != "job timeout exceeded while waiting for job apply_terraform [2] to finish" {
+		t.Errorf("pollJob() failed: expected timeout error, got: %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = pollJob(cancelled, ciJob{id: "3", name: "apply_terraform"}, 0, time.Hour, unfinished)
+	if err != context.Canceled {
+		t.Errorf("pollJob() failed: expected cancelled error, got: %v", err)
+	}
+}
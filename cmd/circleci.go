@@ -1,97 +1,197 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/google/go-github/v28/github"
-	"github.com/jszwedko/go-circleci"
 )
 
-func newCircleClient(token string) *circleci.Client {
-	return &circleci.Client{Token: token}
+const circleTimeout = 30 * time.Second // CircleCI API request timeout
+
+// circleCI runs the jobs with the CircleCI v2 pipelines API
+type circleCI struct {
+	url      string // CircleCI URL, the API is at <url>/api/v2/
+	slug     string // project slug, gh/<org>/<repo>
+	token    string
+	interval time.Duration
+	client   *http.Client
+}
+
+// circleJob is a CircleCI v2 workflow job. Jobs that have not started, and
+// approval jobs, have no number.
+type circleJob struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Type      string `json:"type"` // build or approval
+	Status    string `json:"status"`
+	JobNumber int    `json:"job_number"`
 }
 
-func (r *req) addPassword() (*circleci.EnvVar, error) {
-	resourceID := strings.ReplaceAll(r.ritm.Identifier, "-", "_")
-	name := "TF_VAR_" + resourceID + "_db_password"
-	value := generatePassword()
-	fmt.Printf("Creating CircleCI environment variable %s in %s project\n", name, r.repoName)
-	return r.circleClient.AddEnvVar("GSA", r.repoName, name, value)
+func (c *circleCI) trigger(ctx context.Context, branch string) error {
+	return c.do(ctx, http.MethodPost, "project/"+c.slug+"/pipeline", map[string]string{"branch": branch}, nil)
 }
 
-func waitForApply(pr *github.PullRequest) error {
-	const sleepSec = 5
-	fmt.Println("Waiting for CircleCI apply_terraform job to complete")
-	client := &circleci.Client{Token: os.Getenv("CIRCLE_TOKEN")}
-	account := *pr.Base.Repo.Owner.Login
-	repo := *pr.Base.Repo.Name
-	branch := *pr.Base.Ref
-	sha := *pr.Head.SHA
-	startTime := *pr.MergedAt // Only interested in builds that started after merge
-	timeout := 5 * time.Minute
-	const numJobs = 4 // Number of jobs in workflow
-	var build *circleci.Build
-
-	for build == nil {
-		builds, err := client.ListRecentBuildsForProject(account, repo, branch, "", numJobs, 0)
+// findJobs returns the jobs of the workflows of the pipelines for the commit
+func (c *circleCI) findJobs(ctx context.Context, branch, sha string, since time.Time) ([]ciJob, error) {
+	var pipelines struct {
+		Items []struct {
+			ID        string    `json:"id"`
+			CreatedAt time.Time `json:"created_at"`
+			VCS       struct {
+				Revision string `json:"revision"`
+			} `json:"vcs"`
+		} `json:"items"`
+	}
+	err := c.do(ctx, http.MethodGet, "project/"+c.slug+"/pipeline?branch="+url.QueryEscape(branch), nil, &pipelines)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []ciJob
+	for _, p := range pipelines.Items {
+		if p.VCS.Revision != sha || !p.CreatedAt.After(since) {
+			continue
+		}
+		var workflows struct {
+			Items []struct {
+				ID string `json:"id"`
+			} `json:"items"`
+		}
+		err = c.do(ctx, http.MethodGet, "pipeline/"+p.ID+"/workflow", nil, &workflows)
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		for i, b := range builds {
-			fmt.Printf("%d) %d Job: %s SHA: %s Status: %s Lifecycle: %s Outcome: %s\n",
-				i, b.BuildNum, b.BuildParameters["CIRCLE_JOB"], b.AllCommitDetails[0].Commit,
-				b.Status, b.Lifecycle, b.Outcome)
-			if b.AllCommitDetails[0].Commit == sha && b.StartTime.After(startTime) {
-				if b.BuildParameters["CIRCLE_JOB"] == "apply_terraform" {
-					timeout = 30 * time.Minute
-					build = b
-				}
-				err := waitForBuild(client, b, timeout)
-				if err != nil {
-					return err
-				}
-				if *b.Failed {
-					return fmt.Errorf("%s %s", b.BuildParameters["CIRCLE_JOB"], b.Status)
-				}
+		for _, w := range workflows.Items {
+			var items []circleJob
+			items, err = c.workflowJobs(ctx, w.ID)
+			if err != nil {
+				return nil, err
+			}
+			for _, j := range items {
+				jobs = append(jobs, c.ciJob(j, w.ID))
 			}
 		}
-		time.Sleep(sleepSec * time.Second)
 	}
-
-	return nil
+	return jobs, nil
 }
 
-// waitForBuild ... used internally to wait for the build matching the given
-// buildNum to complete, does not validate that the build was successful
-// jobTimeout is the duration to wait before giving up
-func waitForBuild(client *circleci.Client, build *circleci.Build, jobTimeout time.Duration) (err error) {
-	const sleepSec = 5
-	var (
-		count   int
-		endTime = time.Now().Add(jobTimeout)
-	)
-	for {
-		if time.Now().After(endTime) {
-			return fmt.Errorf("job timeout exceeded while waiting for build %s [%d] to finish", build.BuildParameters["CIRCLE_JOB"], build.BuildNum)
+// waitForJob polls the job by its number, or in its workflow until it has
+// one
+func (c *circleCI) waitForJob(ctx context.Context, job ciJob, timeout time.Duration) (ciJob, error) {
+	return pollJob(ctx, job, timeout, c.interval, func(ctx context.Context, job ciJob) (ciJob, error) {
+		if job.workflow != "" {
+			return c.findWorkflowJob(ctx, job)
 		}
-		if count%10 == 0 {
-			fmt.Printf("waiting for build %s [%d] to finish\n", build.BuildParameters["CIRCLE_JOB"], build.BuildNum)
+		var j circleJob
+		err := c.do(ctx, http.MethodGet, "project/"+c.slug+"/job/"+job.id, nil, &j)
+		if err != nil {
+			return job, err
+		}
+		j.JobNumber, _ = strconv.Atoi(job.id)
+		return c.ciJob(j, ""), nil
+	})
+}
+
+// findWorkflowJob fetches the unnumbered job from the jobs of its workflow
+func (c *circleCI) findWorkflowJob(ctx context.Context, job ciJob) (ciJob, error) {
+	jobs, err := c.workflowJobs(ctx, job.workflow)
+	if err != nil {
+		return job, err
+	}
+	for _, j := range jobs {
+		if j.ID == job.id {
+			return c.ciJob(j, job.workflow), nil
 		}
-		time.Sleep(sleepSec * time.Second)
-		build, err = client.GetBuild(build.Username, build.Reponame, build.BuildNum)
+	}
+	return job, fmt.Errorf("job %s [%s] not found in workflow %s", job.name, job.id, job.workflow)
+}
+
+func (c *circleCI) workflowJobs(ctx context.Context, workflow string) ([]circleJob, error) {
+	var jobs struct {
+		Items []circleJob `json:"items"`
+	}
+	err := c.do(ctx, http.MethodGet, "workflow/"+workflow+"/job", nil, &jobs)
+	return jobs.Items, err
+}
+
+// setSecret creates or replaces a project environment variable
+func (c *circleCI) setSecret(ctx context.Context, name, value string) error {
+	return c.do(idempotent(ctx), http.MethodPost, "project/"+c.slug+"/envvar", map[string]string{"name": name, "value": value}, nil)
+}
+
+func (c *circleCI) deleteSecret(ctx context.Context, name string) error {
+	err := c.do(ctx, http.MethodDelete, "project/"+c.slug+"/envvar/"+url.PathEscape(name), nil, nil)
+	var se *statusError
+	if errors.As(err, &se) && se.status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// do sends a request to the CircleCI v2 API, encoding in as the JSON body and
+// decoding the response into out, if they are not nil
+func (c *circleCI) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
 		if err != nil {
 			return err
 		}
-		// Lifecycle options:
-		// :queued, :scheduled, :not_run, :not_running, :running or :finished
-		if build.Lifecycle == "finished" {
-			fmt.Printf("job %s [%d] %s with state %s\n", build.BuildParameters["CIRCLE_JOB"], build.BuildNum, build.Lifecycle, build.Status)
-			return nil
-		}
-		count++
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url+"/api/v2/"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Circle-Token", c.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024)) // Best effort, the status is reported regardless
+		return &statusError{status: resp.StatusCode, msg: fmt.Sprintf("CircleCI %s %s failed: %s %s", method,
+			strings.SplitN(path, "?", 2)[0], resp.Status, strings.TrimSpace(string(msg)))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ciJob converts the job of the workflow, linking to its page at
+// <url>/<slug>/<number>. A job without a number is identified by its ID and
+// looked up in the workflow until it has one. Jobs that were not run because
+// of the workflow's filters are not failures.
+func (c *circleCI) ciJob(j circleJob, workflow string) ciJob {
+	job := ciJob{id: strconv.Itoa(j.JobNumber), name: j.Name, status: j.Status, approval: j.Type == "approval"}
+	if j.JobNumber == 0 {
+		job.id, job.workflow = j.ID, workflow
+	} else {
+		job.url = c.url + "/" + c.slug + "/" + job.id
+	}
+	switch j.Status {
+	case "success":
+		job.finished = true
+	case "not_run":
+		job.finished, job.skipped = true, true
+	case "failed", "infrastructure_fail", "timedout", "canceled", "unauthorized", "terminated-unknown":
+		job.finished, job.failed = true, true
 	}
+	return job
 }
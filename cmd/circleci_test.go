@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeCircleCI serves the pipelines of the gh/grace/test-repo project. The
+// pipeline for the merge commit fed789 after the merge has plan, approval and
+// apply jobs. The approval is given, and the apply job numbered, the second
+// time the workflow's jobs are fetched, and the apply job finishes the second
+// time it is fetched by number.
+func fakeCircleCI(t *testing.T, merged time.Time) (*httptest.Server, *[]string) {
+	var triggered []string
+	workflowChecks, applyChecks := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Circle-Token") != "test" {
+			t.Errorf("unexpected Circle-Token header: %s", r.Header.Get("Circle-Token"))
+		}
+		const project = "/api/v2/project/gh/grace/test-repo"
+		var body interface{}
+		switch r.Method + " " + r.URL.Path {
+		case "POST " + project + "/pipeline":
+			var b map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&b)
+			triggered = append(triggered, b["branch"])
+			body = map[string]interface{}{"id": "p3", "number": 3, "state": "created"}
+		case "GET " + project + "/pipeline":
+			if r.URL.Query().Get("branch") != "main" {
+				t.Errorf("unexpected pipeline branch: %s", r.URL.RawQuery)
+			}
+			body = map[string]interface{}{"items": []map[string]interface{}{
+				{"id": "p4", "created_at": merged.Add(time.Minute), "vcs": map[string]string{"revision": "abc123"}},
+				{"id": "p2", "created_at": merged.Add(time.Minute), "vcs": map[string]string{"revision": "fed789"}},
+				{"id": "p1", "created_at": merged.Add(-time.Hour), "vcs": map[string]string{"revision": "fed789"}},
+				{"id": "p0", "created_at": merged.Add(time.Minute), "vcs": map[string]string{"revision": "def456"}},
+			}}
+		case "GET /api/v2/pipeline/p2/workflow":
+			body = map[string]interface{}{"items": []map[string]string{{"id": "w2", "name": "terraform"}}}
+		case "GET /api/v2/workflow/w2/job":
+			workflowChecks++
+			hold := map[string]interface{}{"id": "j2", "name": "hold", "type": "approval", "status": "on_hold"}
+			apply := map[string]interface{}{"id": "j3", "name": "apply_terraform", "type": "build", "status": "blocked"}
+			if workflowChecks > 1 {
+				hold["status"] = "success"
+				apply["status"], apply["job_number"] = "running", 42
+			}
+			body = map[string]interface{}{"items": []map[string]interface{}{
+				{"id": "j1", "name": "plan_terraform", "type": "build", "status": "success", "job_number": 41}, hold, apply,
+			}}
+		case "GET " + project + "/job/42":
+			applyChecks++
+			status := "running"
+			if applyChecks > 1 {
+				status = "success"
+			}
+			body = map[string]interface{}{"name": "apply_terraform", "status": status, "number": 42}
+		case "DELETE " + project + "/envvar/TF_VAR_missing":
+			w.WriteHeader(http.StatusNotFound)
+			body = map[string]string{"message": "Environment variable not found."}
+		default:
+			t.Errorf("unexpected CircleCI request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	return ts, &triggered
+}
+
+func TestCircleCI(t *testing.T) {
+	merged := time.Now().Add(-time.Minute)
+	ts, triggered := fakeCircleCI(t, merged)
+	defer ts.Close()
+
+	c := defaultConfig()
+	c.CircleOrg, c.WorkflowJobs = "grace", 3
+	ci := &circleCI{url: ts.URL, slug: "gh/grace/test-repo", token: "test", interval: time.Millisecond,
+		client: newHTTPClient(circleTimeout, retryPolicy{})}
+	r := &req{ci: ci, config: c, repoName: "test-repo"}
+
+	_, err := r.triggerApply("main", "abc123")
+	if err != nil {
+		t.Fatalf("triggerApply() failed: unexpected error: %v", err)
+	}
+	if len(*triggered) != 1 || (*triggered)[0] != "main" {
+		t.Errorf("triggerApply() failed: unexpected pipelines triggered: %v", *triggered)
+	}
+
+	err = r.waitForMergedApply(&pullRequest{Base: "main", HeadSHA: "abc123", MergeCommitSHA: "fed789", MergedAt: merged})
+	if err != nil {
+		t.Errorf("waitForMergedApply() failed: unexpected error: %v", err)
+	}
+
+	err = ci.deleteSecret(context.Background(), "TF_VAR_missing")
+	if err != nil {
+		t.Errorf("deleteSecret() failed: unexpected error for missing variable: %v", err)
+	}
+}
+
+func TestCircleJob(t *testing.T) {
+	tt := map[string]ciJob{
+		"success":     {id: "1", name: "apply", status: "success", finished: true},
+		"not_run":     {id: "1", name: "apply", status: "not_run", finished: true, skipped: true},
+		"failed":      {id: "1", name: "apply", status: "failed", finished: true, failed: true},
+		"canceled":    {id: "1", name: "apply", status: "canceled", finished: true, failed: true},
+		"running":     {id: "1", name: "apply", status: "running"},
+		"on_hold":     {id: "1", name: "apply", status: "on_hold"},
+		"not_running": {id: "1", name: "apply", status: "not_running"},
+	}
+	c := &circleCI{url: "https://circleci.com", slug: "gh/grace/test-repo"}
+	for status, expected := range tt {
+		expected.url = "https://circleci.com/gh/grace/test-repo/1"
+		if got := c.ciJob(circleJob{ID: "j1", Name: "apply", Status: status, JobNumber: 1}, "w1"); got != expected {
+			t.Errorf("ciJob() failed: expected %+v, got: %+v", expected, got)
+		}
+	}
+
+	expected := ciJob{id: "j1", name: "hold", status: "on_hold", workflow: "w1", approval: true}
+	if got := c.ciJob(circleJob{ID: "j1", Name: "hold", Type: "approval", Status: "on_hold"}, "w1"); got != expected {
+		t.Errorf("ciJob() failed: expected unnumbered job %+v, got: %+v", expected, got)
+	}
+}
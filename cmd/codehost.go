@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// The first and longest intervals between polls of a pull request waiting to
+// be merged
+const (
+	mergePollInterval    = 10 * time.Second
+	maxMergePollInterval = 5 * time.Minute
+)
+
+// pullRequest is a GitHub pull request or GitLab merge request
+type pullRequest struct {
+	Number   int
+	URL      string
+	State    string // open or closed
+	Merged   bool
+	MergedAt time.Time
+	Base     string // branch the pull request is merged to
+	HeadSHA  string
+
+	MergeCommitSHA string // commit the merge added to the base branch, empty after a fast-forward merge
+}
+
+// mergedSHA returns the commit of the base branch the merge resulted in,
+// which the CI jobs applying the merge run for
+func (pr *pullRequest) mergedSHA() string {
+	if pr.MergeCommitSHA != "" {
+		return pr.MergeCommitSHA
+	}
+	return pr.HeadSHA
+}
+
+// codeHost is the service hosting the infrastructure repositories
+type codeHost interface {
+	cloneURL(repo string) string
+	auth() transport.AuthMethod // credentials for cloning and pushing
+	createPullRequest(ctx context.Context, repo, head, base, title, body string) (*pullRequest, error)
+	findPullRequest(ctx context.Context, repo, head string) (*pullRequest, error) // nil if the branch has none open
+	requestReviewers(ctx context.Context, repo string, number int, reviewers []string) error
+	getPullRequest(ctx context.Context, repo string, number int) (*pullRequest, error)
+	branchHead(ctx context.Context, repo, branch string) (string, error)
+}
+
+// newCodeHost returns the code host of the configuration, authenticated with
+// the token in its environment variable
+func newCodeHost(c *config) (codeHost, error) {
+	token := os.Getenv(c.tokenEnv())
+	if c.CodeHost == gitLabHost {
+		return newGitLab(c.GitLabURL, c.Owner, token, newRetryPolicy(c.MaxAttempts)), nil
+	}
+	return newGitHub(c, token)
+}
+
+// pullRequest creates a pull request for the branch and requests a review.
+// A previous run may have opened it before failing, so the open pull request
+// of the branch is reused if there is one.
+func (r *req) pullRequest(title, body string) (*pullRequest, error) {
+	ctx := r.context()
+	pr, err := r.host.findPullRequest(ctx, r.repoName, r.branch)
+	if err != nil {
+		return nil, err
+	}
+	if pr != nil {
+		fmt.Printf("Reusing open Pull request: %d\n", pr.Number)
+	} else {
+		pr, err = r.createPullRequest(ctx, title, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(r.config.Reviewers) == 0 {
+		return pr, nil
+	}
+
+	return pr, r.host.requestReviewers(ctx, r.repoName, pr.Number, r.config.Reviewers)
+}
+
+// createPullRequest opens the pull request. An attempt failing with a
+// transient error may have opened it anyway, so the open pull request of the
+// branch is looked up before trying again.
+func (r *req) createPullRequest(ctx context.Context, title, body string) (*pullRequest, error) {
+	fmt.Println("Creating Pull request")
+	var pr *pullRequest
+	err := r.retry.call(ctx, func() (err error) {
+		pr, err = r.host.createPullRequest(ctx, r.repoName, r.branch, r.config.BaseBranch, title, body)
+		return err
+	}, func() (bool, error) {
+		found, err := r.host.findPullRequest(ctx, r.repoName, r.branch)
+		if found != nil {
+			fmt.Printf("Pull request %d was opened by the failed attempt\n", found.Number)
+			pr = found
+		}
+		return found != nil, err
+	})
+	return pr, err
+}
+
+// prBody links the pull request to the RITM and summarizes the databases
+func (r *req) prBody() string {
+	body := ritmLink(r.ritm)
+	for _, env := range r.ritm.environments() {
+		body += fmt.Sprintf("\n- %s: %s %s RDS in %s account", env.name, env.size, r.ritm.Engine, r.ritm.Account)
+	}
+	return body
+}
+
+// ritmLink returns a markdown link to the RITM in ServiceNow
+func ritmLink(ritm *ritm) string {
+	serviceNowURL := fmt.Sprintf("https://%s/nav_to.do?uri=sc_req_item.do%%3Fsys_id%%3D", os.Getenv("SN_INSTANCE"))
+	return fmt.Sprintf("[%s](%s%s)", ritm.Number, serviceNowURL, ritm.SysID)
+}
+
+// getPullRequest fetches a pull request by number
+func (r *req) getPullRequest(number int) (*pullRequest, error) {
+	fmt.Printf("Fetching Pull request: %d\n", number)
+	return r.host.getPullRequest(r.context(), r.repoName, number)
+}
+
+// branchHead returns the SHA of the latest commit on the branch
+func (r *req) branchHead(branch string) (string, error) {
+	return r.host.branchHead(r.context(), r.repoName, branch)
+}
+
+// waitForMerge polls the pull request until it is closed or the context is
+// done, returning the merged pull request. The polls back off exponentially,
+// and a webhook event closing the pull request ends the wait.
+func (r *req) waitForMerge(pr *pullRequest) (*pullRequest, error) {
+	ctx := r.context()
+
+	var closed <-chan *pullRequest
+	if r.merges != nil {
+		var done func()
+		closed, done = r.merges.wait(r.repoName, pr.Number)
+		defer done()
+	}
+
+	var err error
+	interval := mergePollInterval
+	fmt.Print("Waiting for Pull Request to be merged")
+	for pr.State != "closed" {
+		fmt.Print(".")
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return pr, ctx.Err()
+		case event := <-closed:
+			pr = event
+			continue
+		case <-time.After(interval):
+			interval *= 2
+			if interval > maxMergePollInterval {
+				interval = maxMergePollInterval
+			}
+		}
+		pr, err = r.host.getPullRequest(ctx, r.repoName, pr.Number)
+		if err != nil {
+			return pr, err
+		}
+	}
+	fmt.Println()
+
+	if !pr.Merged {
+		return pr, fmt.Errorf("pull request %s but not merged", pr.State)
+	}
+
+	return pr, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeHost returns the pull request with the state given, which is open if
+// opened is set. Creating it fails with createErr, after opening it if
+// openedAnyway is set.
+type fakeHost struct {
+	codeHost
+	pr           *pullRequest
+	createErr    error
+	opened       bool
+	openedAnyway bool
+	creates      int
+}
+
+func (h *fakeHost) createPullRequest(ctx context.Context, repo, head, base, title, body string) (*pullRequest, error) {
+	h.creates++
+	if h.createErr != nil && h.creates == 1 {
+		h.opened = h.openedAnyway
+		return nil, h.createErr
+	}
+	h.opened = true
+	return h.pr, nil
+}
+
+func (h *fakeHost) findPullRequest(ctx context.Context, repo, head string) (*pullRequest, error) {
+	if !h.opened {
+		return nil, nil
+	}
+	return h.pr, nil
+}
+
+func (h *fakeHost) getPullRequest(ctx context.Context, repo string, number int) (*pullRequest, error) {
+	return h.pr, nil
+}
+
+// nolint: funlen
+func TestWaitForMerge(t *testing.T) {
+	open := &pullRequest{Number: 7, State: "open"}
+	merged := &pullRequest{Number: 7, State: "closed", Merged: true}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	// The host still returns the open pull request, so only the webhook
+	// event ends the wait before the first poll
+	tt := map[string]struct {
+		event *pullRequest
+		ctx   context.Context
+		err   string
+	}{
+		"webhook":  {event: merged},
+		"closed":   {event: &pullRequest{Number: 7, State: "closed"}, err: "pull request closed but not merged"},
+		"timeout":  {ctx: expired, err: "context deadline exceeded"},
+		"canceled": {ctx: cancelled, err: "context canceled"},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			r := &req{ctx: tc.ctx, host: &fakeHost{pr: open}, repoName: "test-repo"}
+			if tc.event != nil {
+				r.merges = newMergeEvents()
+				go func() {
+					for !r.merges.notify("test-repo", tc.event) {
+						time.Sleep(time.Millisecond)
+					}
+				}()
+			}
+
+			pr, err := r.waitForMerge(open)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("waitForMerge() failed: unexpected error: %v", err)
+				}
+				if pr != merged {
+					t.Errorf("waitForMerge() failed: unexpected pull request: %+v", pr)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("waitForMerge() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestPullRequestRetry(t *testing.T) {
+	unavailable := &statusError{status: http.StatusBadGateway, msg: "bad gateway"}
+	tt := map[string]struct {
+		host    *fakeHost
+		creates int
+		err     string
+	}{
+		"retried":       {host: &fakeHost{createErr: unavailable}, creates: 2},
+		"opened anyway": {host: &fakeHost{createErr: unavailable, openedAnyway: true}, creates: 1},
+		"already open":  {host: &fakeHost{createErr: fmt.Errorf("already exists"), opened: true}, creates: 0},
+		"not transient": {host: &fakeHost{createErr: fmt.Errorf("already exists")}, creates: 1, err: "already exists"},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tc.host.pr = &pullRequest{Number: 7}
+			r := &req{config: &config{}, host: tc.host, repoName: "test-repo", retry: retryPolicy{attempts: 3}}
+			pr, err := r.pullRequest("RITM0001001", "body")
+			if tc.host.creates != tc.creates {
+				t.Errorf("pullRequest() failed: expected %d creates, got: %d", tc.creates, tc.host.creates)
+			}
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("pullRequest() failed: expected error: %s\nGot: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil || pr == nil || pr.Number != 7 {
+				t.Errorf("pullRequest() failed: expected pull request 7, got: %+v %v", pr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Environment variables overriding the configuration file
+const (
+	envConfig     = "GRACE_PAAS_RDS_CONFIG"
+	envOwner      = "GRACE_PAAS_RDS_OWNER"
+	envCircleOrg  = "GRACE_PAAS_RDS_CIRCLECI_ORG"
+	envBaseBranch = "GRACE_PAAS_RDS_BASE_BRANCH"
+	envReviewers  = "GRACE_PAAS_RDS_REVIEWERS"
+	envEmail      = "GRACE_PAAS_RDS_EMAIL"
+	envGitHubURL  = "GRACE_PAAS_RDS_GITHUB_URL"
+	envCodeHost   = "GRACE_PAAS_RDS_CODE_HOST"
+	envGitHubAPI  = "GRACE_PAAS_RDS_GITHUB_API_URL"
+	envGitHubUp   = "GRACE_PAAS_RDS_GITHUB_UPLOAD_URL"
+	envGitLabURL  = "GRACE_PAAS_RDS_GITLAB_URL"
+	envCIRunner   = "GRACE_PAAS_RDS_CI_RUNNER"
+	envApplyJob   = "GRACE_PAAS_RDS_APPLY_JOB"
+	envJobs       = "GRACE_PAAS_RDS_WORKFLOW_JOBS"
+	envWorkflow   = "GRACE_PAAS_RDS_ACTIONS_WORKFLOW"
+	envCircleURL  = "GRACE_PAAS_RDS_CIRCLECI_URL"
+	envAttempts   = "GRACE_PAAS_RDS_MAX_ATTEMPTS"
+	envState      = "GRACE_PAAS_RDS_PROVISIONED_STATE"
+)
+
+// Code hosts of the infrastructure repositories
+const (
+	gitHubHost = "github"
+	gitLabHost = "gitlab"
+)
+
+// config is the organization the pipeline runs in: where the infrastructure
+// repositories are, who reviews the pull requests and who commits them
+type config struct {
+	CodeHost   string   `json:"code_host" yaml:"code_host"`       // github or gitlab
+	Owner      string   `json:"owner" yaml:"owner"`               // GitHub organization or GitLab group of the repositories
+	CircleOrg  string   `json:"circleci_org" yaml:"circleci_org"` // CircleCI organization, defaults to owner
+	BaseBranch string   `json:"base_branch" yaml:"base_branch"`   // branch the pull requests are merged to
+	Reviewers  []string `json:"reviewers" yaml:"reviewers"`       // GitHub team slugs or GitLab usernames asked to review
+	Email      string   `json:"email" yaml:"email"`               // commit author email
+	GitHubURL  string   `json:"github_url" yaml:"github_url"`     // GitHub web URL the repositories are cloned from
+	GitLabURL  string   `json:"gitlab_url" yaml:"gitlab_url"`     // GitLab web URL, the API is at <gitlab_url>/api/v4/
+
+	// GitHub Enterprise API URLs, default to <github_url>/api/v3/ and
+	// <github_url>/api/uploads/ unless github_url is https://github.com
+	GitHubAPIURL    string `json:"github_api_url" yaml:"github_api_url"`
+	GitHubUploadURL string `json:"github_upload_url" yaml:"github_upload_url"`
+
+	CIRunner        string `json:"ci_runner" yaml:"ci_runner"`               // circleci, github-actions or local
+	ApplyJob        string `json:"apply_job" yaml:"apply_job"`               // name of the job running terraform apply
+	WorkflowJobs    int    `json:"workflow_jobs" yaml:"workflow_jobs"`       // number of jobs in the apply workflow
+	ActionsWorkflow string `json:"actions_workflow" yaml:"actions_workflow"` // GitHub Actions workflow file started to apply
+	CircleCIURL     string `json:"circleci_url" yaml:"circleci_url"`         // CircleCI URL, the API is at <circleci_url>/api/v2/
+
+	MaxAttempts int `json:"max_attempts" yaml:"max_attempts"` // attempts of each API call failing with a transient error
+
+	ProvisionedState string `json:"provisioned_state" yaml:"provisioned_state"` // RITM state once provisioned
+
+	Repos map[string]repoConfig `json:"repos,omitempty" yaml:"repos,omitempty"`
+}
+
+// repoConfig overrides the configuration for one repository. Unset fields
+// keep the organization's values.
+type repoConfig struct {
+	BaseBranch string   `json:"base_branch,omitempty" yaml:"base_branch,omitempty"`
+	Reviewers  []string `json:"reviewers,omitempty" yaml:"reviewers,omitempty"` // [] requests no reviews
+	Email      string   `json:"email,omitempty" yaml:"email,omitempty"`
+}
+
+// defaultConfig is used for any settings not in the configuration file
+func defaultConfig() *config {
+	return &config{
+		CodeHost:   gitHubHost,
+		Owner:      "GSA",
+		BaseBranch: "master",
+		Email:      "grace-staff@gsa.gov",
+		GitHubURL:  "https://github.com",
+		GitLabURL:  "https://gitlab.com",
+
+		CIRunner:        circleCIRunner,
+		ApplyJob:        "apply_terraform",
+		ActionsWorkflow: "terraform.yml",
+		CircleCIURL:     "https://circleci.com",
+
+		MaxAttempts: defaultMaxAttempts,
+
+		ProvisionedState: "work_in_progress",
+	}
+}
+
+// configOptions select the configuration file and override its settings
+type configOptions struct {
+	configFile      string
+	codeHost        string
+	owner           string
+	circleOrg       string
+	baseBranch      string
+	reviewers       string
+	email           string
+	githubURL       string
+	githubAPIURL    string
+	githubUploadURL string
+	gitlabURL       string
+	ciRunner        string
+	applyJob        string
+	workflowJobs    int
+	actionsWorkflow string
+	circleURL       string
+	maxAttempts     int
+	state           string
+	reviewSet       bool // -reviewers was given, so "" requests no reviews
+}
+
+func (o *configOptions) addFlags(flags *flag.FlagSet) {
+	flags.StringVar(&o.configFile, "config", "", "Configuration file (YAML or JSON), or "+envConfig)
+	flags.StringVar(&o.codeHost, "code-host", "", "Code host of the repository: github (default) or gitlab, or "+envCodeHost)
+	flags.StringVar(&o.owner, "owner", "", "GitHub organization or GitLab group of the repository, or "+envOwner)
+	flags.StringVar(&o.circleOrg, "circleci-org", "", "CircleCI organization, defaults to the owner, or "+envCircleOrg)
+	flags.StringVar(&o.baseBranch, "base-branch", "", "Branch the pull requests are merged to, or "+envBaseBranch)
+	flags.Func("reviewers", "Comma separated GitHub teams or GitLab users to request reviews from, or "+envReviewers, func(s string) error {
+		o.reviewers, o.reviewSet = s, true
+		return nil
+	})
+	flags.StringVar(&o.email, "email", "", "Commit author email, or "+envEmail)
+	flags.StringVar(&o.githubURL, "github-url", "", "GitHub URL the repositories are cloned from, or "+envGitHubURL)
+	flags.StringVar(&o.githubAPIURL, "github-api-url", "", "GitHub Enterprise API URL, or "+envGitHubAPI)
+	flags.StringVar(&o.githubUploadURL, "github-upload-url", "", "GitHub Enterprise upload URL, or "+envGitHubUp)
+	flags.StringVar(&o.gitlabURL, "gitlab-url", "", "GitLab URL the repositories are cloned from, or "+envGitLabURL)
+	flags.StringVar(&o.ciRunner, "ci-runner", "", "CI runner: circleci (default), github-actions or local, or "+envCIRunner)
+	flags.StringVar(&o.applyJob, "apply-job", "", "Name of the CI job running terraform apply, or "+envApplyJob)
+	flags.IntVar(&o.workflowJobs, "workflow-jobs", 0, "Number of jobs in the CI apply workflow, or "+envJobs)
+	flags.StringVar(&o.actionsWorkflow, "actions-workflow", "", "GitHub Actions workflow file started to apply, or "+envWorkflow)
+	flags.StringVar(&o.circleURL, "circleci-url", "", "CircleCI URL, or "+envCircleURL)
+	flags.IntVar(&o.maxAttempts, "max-attempts", 0, "Attempts of each API call failing with a transient error, or "+envAttempts)
+	flags.StringVar(&o.state, "provisioned-state", "",
+		"RITM state once provisioned: work_in_progress (default) or closed_complete, or "+envState)
+}
+
+// setting is a configuration setting with its environment variable and flag
+type setting struct {
+	value *string
+	env   string
+	flag  string
+}
+
+// settings returns the settings of c that can be overridden
+func (o *configOptions) settings(c *config) []setting {
+	return []setting{
+		{value: &c.CodeHost, env: envCodeHost, flag: o.codeHost},
+		{value: &c.Owner, env: envOwner, flag: o.owner},
+		{value: &c.CircleOrg, env: envCircleOrg, flag: o.circleOrg},
+		{value: &c.BaseBranch, env: envBaseBranch, flag: o.baseBranch},
+		{value: &c.Email, env: envEmail, flag: o.email},
+		{value: &c.GitHubURL, env: envGitHubURL, flag: o.githubURL},
+		{value: &c.GitHubAPIURL, env: envGitHubAPI, flag: o.githubAPIURL},
+		{value: &c.GitHubUploadURL, env: envGitHubUp, flag: o.githubUploadURL},
+		{value: &c.GitLabURL, env: envGitLabURL, flag: o.gitlabURL},
+		{value: &c.CIRunner, env: envCIRunner, flag: o.ciRunner},
+		{value: &c.ApplyJob, env: envApplyJob, flag: o.applyJob},
+		{value: &c.ActionsWorkflow, env: envWorkflow, flag: o.actionsWorkflow},
+		{value: &c.CircleCIURL, env: envCircleURL, flag: o.circleURL},
+		{value: &c.ProvisionedState, env: envState, flag: o.state},
+	}
+}
+
+// loadConfig returns the configuration for the repository: the defaults,
+// overridden by the configuration file, its settings for the repository,
+// the environment variables and the flags, in that order
+func (o *configOptions) loadConfig(repo string) (*config, error) {
+	c := defaultConfig()
+	path := o.configFile
+	if path == "" {
+		path = os.Getenv(envConfig)
+	}
+	if path != "" {
+		err := c.read(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.useRepo(repo)
+	err := o.override(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	c.setDefaults()
+
+	err = c.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	return c, nil
+}
+
+// useRepo applies the configuration file's settings for the repository
+func (c *config) useRepo(repo string) {
+	rc, ok := c.Repos[repo]
+	if !ok {
+		return
+	}
+	override(&c.BaseBranch, rc.BaseBranch)
+	override(&c.Email, rc.Email)
+	if rc.Reviewers != nil {
+		c.Reviewers = rc.Reviewers
+	}
+}
+
+// override applies the environment variables, then the flags
+func (o *configOptions) override(c *config) error {
+	settings := o.settings(c)
+	for _, s := range settings {
+		override(s.value, os.Getenv(s.env))
+	}
+	if v, ok := os.LookupEnv(envReviewers); ok {
+		c.Reviewers = splitList(v)
+	}
+	for _, n := range []struct {
+		value *int
+		env   string
+	}{
+		{value: &c.WorkflowJobs, env: envJobs},
+		{value: &c.MaxAttempts, env: envAttempts},
+	} {
+		if v := os.Getenv(n.env); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s must be a number, got %q", n.env, v)
+			}
+			*n.value = i
+		}
+	}
+
+	for _, s := range settings {
+		override(s.value, s.flag)
+	}
+	if o.reviewSet {
+		c.Reviewers = splitList(o.reviewers)
+	}
+	if o.workflowJobs != 0 {
+		c.WorkflowJobs = o.workflowJobs
+	}
+	if o.maxAttempts != 0 {
+		c.MaxAttempts = o.maxAttempts
+	}
+	return nil
+}
+
+// setDefaults sets the settings that default to other settings
+func (c *config) setDefaults() {
+	if c.CircleOrg == "" {
+		c.CircleOrg = c.Owner
+	}
+	if c.WorkflowJobs == 0 {
+		c.WorkflowJobs = 4
+		if c.CIRunner == localRunner {
+			c.WorkflowJobs = 1 // The local runner has only the apply job
+		}
+	}
+	if c.Reviewers == nil && c.CodeHost == gitHubHost {
+		c.Reviewers = []string{"grace-developers"} // A GitHub team, so GitLab requests no reviews by default
+	}
+	c.GitHubURL = strings.TrimSuffix(c.GitHubURL, "/")
+	c.GitLabURL = strings.TrimSuffix(c.GitLabURL, "/")
+	c.CircleCIURL = strings.TrimSuffix(c.CircleCIURL, "/")
+	if c.GitHubURL == "https://github.com" || !validURL(c.GitHubURL) {
+		return // Not GitHub Enterprise, or reported when validated
+	}
+	if c.GitHubAPIURL == "" {
+		c.GitHubAPIURL = c.GitHubURL + "/api/v3/"
+	}
+	if c.GitHubUploadURL == "" {
+		c.GitHubUploadURL = c.GitHubURL + "/api/uploads/"
+	}
+}
+
+// read reads the YAML or JSON configuration file over the defaults,
+// rejecting unknown fields
+func (c *config) read(path string) error {
+	fmt.Printf("Loading configuration from: %s\n", path)
+	b, err := ioutil.ReadFile(path) // #nosec G304
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	} else {
+		err = yaml.UnmarshalStrict(b, c)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return nil
+}
+
+// validate checks the settings before anything is contacted
+func (c *config) validate() error {
+	name := regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?$`)
+	var errs []string
+	if c.CodeHost != gitHubHost && c.CodeHost != gitLabHost {
+		errs = append(errs, fmt.Sprintf("code_host must be %s or %s, got %q", gitHubHost, gitLabHost, c.CodeHost))
+	}
+	if !name.MatchString(c.Owner) {
+		errs = append(errs, fmt.Sprintf("owner must be a GitHub organization or GitLab group, got %q", c.Owner))
+	}
+	if !name.MatchString(c.CircleOrg) {
+		errs = append(errs, fmt.Sprintf("circleci_org must be a CircleCI organization, got %q", c.CircleOrg))
+	}
+	if !validBranch(c.BaseBranch) {
+		errs = append(errs, fmt.Sprintf("base_branch must be a branch name, got %q", c.BaseBranch))
+	}
+	for _, team := range c.Reviewers {
+		if !regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`).MatchString(team) {
+			errs = append(errs, fmt.Sprintf("reviewers must be GitHub team slugs or GitLab usernames, got %q", team))
+		}
+	}
+	if a, err := mail.ParseAddress(c.Email); err != nil || a.Address != c.Email {
+		errs = append(errs, fmt.Sprintf("email must be an email address, got %q", c.Email))
+	}
+	if c.MaxAttempts < 1 {
+		errs = append(errs, fmt.Sprintf("max_attempts must be at least 1, got %d", c.MaxAttempts))
+	}
+	if _, ok := provisionedState(c.ProvisionedState); !ok {
+		errs = append(errs, fmt.Sprintf("provisioned_state must be work_in_progress or closed_complete, got %q", c.ProvisionedState))
+	}
+	errs = append(errs, c.urlErrors()...)
+	errs = append(errs, c.ciErrors()...)
+	errs = append(errs, c.repoErrors()...)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// urlErrors checks the code host URLs. The GitHub Enterprise API URLs are
+// only set for GitHub Enterprise.
+func (c *config) urlErrors() []string {
+	var errs []string
+	for _, u := range []struct {
+		name, value string
+		optional    bool
+	}{
+		{name: "github_url", value: c.GitHubURL},
+		{name: "github_api_url", value: c.GitHubAPIURL, optional: true},
+		{name: "github_upload_url", value: c.GitHubUploadURL, optional: true},
+		{name: "gitlab_url", value: c.GitLabURL},
+		{name: "circleci_url", value: c.CircleCIURL},
+	} {
+		if (!u.optional || u.value != "") && !validURL(u.value) {
+			errs = append(errs, fmt.Sprintf("%s must be an http or https URL, got %q", u.name, u.value))
+		}
+	}
+	return errs
+}
+
+// ciErrors checks the CI runner settings
+func (c *config) ciErrors() []string {
+	var errs []string
+	switch c.CIRunner {
+	case circleCIRunner, localRunner:
+	case actionsRunner:
+		if c.CodeHost != gitHubHost {
+			errs = append(errs, fmt.Sprintf("ci_runner %s needs code_host %s", actionsRunner, gitHubHost))
+		}
+		if c.ActionsWorkflow == "" || strings.ContainsAny(c.ActionsWorkflow, "/?#") {
+			errs = append(errs, fmt.Sprintf("actions_workflow must be a workflow file name, got %q", c.ActionsWorkflow))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("ci_runner must be one of %s, got %q",
+			strings.Join([]string{circleCIRunner, actionsRunner, localRunner}, ", "), c.CIRunner))
+	}
+	if strings.TrimSpace(c.ApplyJob) == "" {
+		errs = append(errs, "apply_job must be set")
+	}
+	if c.WorkflowJobs < 1 {
+		errs = append(errs, fmt.Sprintf("workflow_jobs must be at least 1, got %d", c.WorkflowJobs))
+	}
+	if c.CIRunner == localRunner && c.WorkflowJobs > 1 {
+		errs = append(errs, fmt.Sprintf("workflow_jobs must be 1 for ci_runner %s, got %d", localRunner, c.WorkflowJobs))
+	}
+	return errs
+}
+
+// repoErrors checks the repository overrides
+func (c *config) repoErrors() []string {
+	repos := make([]string, 0, len(c.Repos))
+	for repo := range c.Repos {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	var errs []string
+	for _, repo := range repos {
+		if b := c.Repos[repo].BaseBranch; b != "" && !validBranch(b) {
+			errs = append(errs, fmt.Sprintf("repos: %s: base_branch must be a branch name, got %q", repo, b))
+		}
+	}
+	return errs
+}
+
+// validBranch returns true if name is a valid git branch name
+func validBranch(name string) bool {
+	return regexp.MustCompile(`^[A-Za-z0-9._/-]+$`).MatchString(name) &&
+		!strings.Contains(name, "..") && !strings.Contains(name, "//") &&
+		!strings.HasPrefix(name, "-") && !strings.HasPrefix(name, "/") &&
+		!strings.HasSuffix(name, "/") && !strings.HasSuffix(name, ".lock")
+}
+
+// validURL returns true if s is an http or https URL
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// tokenEnvs returns the environment variables holding the code host and CI
+// runner tokens
+func (c *config) tokenEnvs() []string {
+	envs := []string{c.tokenEnv()}
+	if c.CIRunner == circleCIRunner {
+		envs = append(envs, "CIRCLE_TOKEN")
+	}
+	return envs
+}
+
+// tokenEnv returns the environment variable holding the code host's token
+func (c *config) tokenEnv() string {
+	if c.CodeHost == gitLabHost {
+		return "GITLAB_TOKEN"
+	}
+	return "GITHUB_TOKEN"
+}
+
+// override sets *s to v, unless v is empty
+func override(s *string, v string) {
+	if v != "" {
+		*s = v
+	}
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// nolint: funlen
+func TestLoadConfig(t *testing.T) {
+	file := filepath.Join("testdata", "config.yaml")
+	// fromFile is the configuration in the file for repositories without
+	// overrides
+	fromFile := func(c *config) {
+		c.Owner, c.CircleOrg, c.BaseBranch, c.Reviewers = "grace-org", "grace-org", "main", []string{"dba-team"}
+		c.Email, c.GitHubURL = "rds@example.gov", "https://github.example.gov"
+		c.GitHubAPIURL, c.GitHubUploadURL = c.GitHubURL+"/api/v3/", c.GitHubURL+"/api/uploads/"
+	}
+	tt := map[string]struct {
+		opts     configOptions
+		env      map[string]string
+		repo     string
+		expected func(c *config) // changes to the default configuration
+		err      string
+	}{
+		"defaults": {expected: func(c *config) { c.Reviewers = []string{"grace-developers"} }},
+		"file": {
+			opts:     configOptions{configFile: file},
+			repo:     "test-repo",
+			expected: fromFile,
+		},
+		"repo override": {
+			env:  map[string]string{envConfig: file},
+			repo: "legacy-infra",
+			expected: func(c *config) {
+				fromFile(c)
+				c.BaseBranch, c.Reviewers = "master", []string{}
+			},
+		},
+		"env and flags": {
+			opts: configOptions{configFile: file, owner: "flag-org", reviewers: "a, b,", reviewSet: true, workflowJobs: 2},
+			env: map[string]string{envOwner: "env-org", envCircleOrg: "circle-org", envEmail: "env@example.gov", envReviewers: "",
+				envJobs: "3", envApplyJob: "terraform-apply", envAttempts: "6", envState: "closed_complete"},
+			repo: "audited-infra",
+			expected: func(c *config) {
+				fromFile(c)
+				c.Owner, c.CircleOrg, c.Reviewers, c.Email = "flag-org", "circle-org", []string{"a", "b"}, "env@example.gov"
+				c.WorkflowJobs, c.ApplyJob, c.MaxAttempts = 2, "terraform-apply", 6
+				c.ProvisionedState = "closed_complete"
+			},
+		},
+		"env reviewers": {
+			env:      map[string]string{envReviewers: ""},
+			expected: func(c *config) { c.Reviewers = []string{} },
+		},
+		"gitlab": {
+			opts: configOptions{codeHost: gitLabHost, gitlabURL: "https://gitlab.example.gov/", ciRunner: localRunner},
+			expected: func(c *config) {
+				c.CodeHost, c.GitLabURL, c.CIRunner = gitLabHost, "https://gitlab.example.gov", localRunner
+				c.Reviewers = nil // The default team is a GitHub team
+			},
+		},
+		"invalid": {
+			opts: configOptions{codeHost: "bitbucket", owner: "GSA org", baseBranch: "feature..x", reviewers: "a b", reviewSet: true,
+				email: "GRACE <grace@gsa.gov>", githubURL: "github.com", ciRunner: "jenkins", workflowJobs: -1,
+				maxAttempts: -1, state: "closed"},
+			err: `invalid config: code_host must be github or gitlab, got "bitbucket"; ` +
+				`owner must be a GitHub organization or GitLab group, got "GSA org"; ` +
+				`circleci_org must be a CircleCI organization, got "GSA org"; ` +
+				`base_branch must be a branch name, got "feature..x"; ` +
+				`reviewers must be GitHub team slugs or GitLab usernames, got "a b"; ` +
+				`email must be an email address, got "GRACE <grace@gsa.gov>"; ` +
+				`max_attempts must be at least 1, got -1; ` +
+				`provisioned_state must be work_in_progress or closed_complete, got "closed"; ` +
+				`github_url must be an http or https URL, got "github.com"; ` +
+				`ci_runner must be one of circleci, github-actions, local, got "jenkins"; ` +
+				`workflow_jobs must be at least 1, got -1`,
+		},
+		"actions on gitlab": {
+			opts: configOptions{codeHost: gitLabHost, ciRunner: actionsRunner, actionsWorkflow: "workflows/apply.yml"},
+			err: `invalid config: ci_runner github-actions needs code_host github; ` +
+				`actions_workflow must be a workflow file name, got "workflows/apply.yml"`,
+		},
+		"local workflow jobs": {
+			opts: configOptions{ciRunner: localRunner, workflowJobs: 4},
+			err:  `invalid config: workflow_jobs must be 1 for ci_runner local, got 4`,
+		},
+		"invalid workflow jobs": {
+			env: map[string]string{envJobs: "four"},
+			err: `invalid config: GRACE_PAAS_RDS_WORKFLOW_JOBS must be a number, got "four"`,
+		},
+		"missing file": {
+			opts: configOptions{configFile: filepath.Join("testdata", "missing.yaml")},
+			err:  "no such file or directory",
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			for _, k := range []string{envConfig, envCodeHost, envOwner, envCircleOrg, envBaseBranch, envReviewers, envEmail,
+				envGitHubURL, envGitHubAPI, envGitHubUp, envGitLabURL, envCIRunner, envApplyJob, envJobs, envWorkflow, envCircleURL,
+				envAttempts, envState} {
+				old, ok := os.LookupEnv(k)
+				if v, set := tc.env[k]; set {
+					os.Setenv(k, v)
+				} else {
+					os.Unsetenv(k)
+				}
+				if ok {
+					defer os.Setenv(k, old)
+				} else {
+					defer os.Unsetenv(k)
+				}
+			}
+
+			c, err := tc.opts.loadConfig(tc.repo)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("loadConfig() failed: expected error: %s\nGot: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() failed: unexpected error: %v", err)
+			}
+			expected := defaultConfig()
+			tc.expected(expected)
+			expected.setDefaults()
+			c.Repos = nil
+			if !jsonEqual(c, expected) {
+				t.Errorf("loadConfig() failed: expected: %+v\nGot: %+v", *expected, *c)
+			}
+		})
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tt := map[string]struct {
+		name    string
+		content string
+		err     string
+	}{
+		"json": {
+			name:    "config.json",
+			content: `{"owner": "grace-org", "circleci_org": "grace-org", "repos": {"infra": {"base_branch": "main"}}}`,
+		},
+		"unknown field": {name: "config.yaml", content: "organization: grace-org\n", err: "field organization not found"},
+		"unknown json":  {name: "config.json", content: `{"base": "main"}`, err: `unknown field "base"`},
+		"bad repo":      {name: "config.yaml", content: "repos: {infra: {base_branch: -main}}\n", err: "repos: infra: base_branch"},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			err := ioutil.WriteFile(path, []byte(tc.content), 0600)
+			if err != nil {
+				t.Fatalf("unable to write config: %v", err)
+			}
+			c := defaultConfig()
+			err = c.read(path)
+			if err == nil {
+				c.setDefaults()
+				err = c.validate()
+			}
+			if tc.err == "" && err != nil {
+				t.Errorf("read() failed: unexpected error: %v", err)
+			} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("read() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
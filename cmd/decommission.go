@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// decommission removes the databases provisioned by a RITM, as requested by a
+// decommission RITM
+type decommission struct {
+	*req
+	original *ritm // the RITM that provisioned the databases
+}
+
+// decommissionRITM runs the decommission subcommand. Errors are posted to the
+// decommission RITM once it has been read.
+func decommissionRITM(ctx context.Context, progName string, args []string) error {
+	d, err := newDecommission(ctx, progName, args)
+	if err == nil {
+		err = d.run()
+	}
+	d.record(err)
+	return err
+}
+
+func newDecommission(ctx context.Context, progName string, args []string) (*decommission, error) {
+	d := &decommission{req: &req{ctx: ctx, format: tfConst}}
+	flags := flag.NewFlagSet(progName, flag.ContinueOnError)
+	var buf bytes.Buffer
+	flags.SetOutput(&buf)
+	d.addChangeFlags(flags, "decommission")
+	flags.StringVar(&d.stateDir, "state-dir", filepath.Join(os.TempDir(), "grace-paas-rds"),
+		"Directory for the pipeline state files, used to resume an interrupted decommission")
+	d.secretOptions.addFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		fmt.Println(buf.String())
+		return d, err
+	}
+
+	err = d.checkChange()
+	if err == nil {
+		err = d.secretOptions.check(false)
+	}
+	if err != nil {
+		flags.PrintDefaults()
+		return d, err
+	}
+
+	err = d.newClients()
+	if err != nil {
+		return d, err
+	}
+	err = d.parseRITM()
+	if err != nil {
+		return d, err
+	}
+	err = d.ritm.validateDecommission()
+	if err != nil {
+		return d, err
+	}
+
+	p := &req{ritmNumber: d.ritm.Provisioning, snowClient: d.snowClient}
+	err = p.parseRITM()
+	if err != nil {
+		return d, err
+	}
+	if p.ritm.CatalogItemName != catalogItemName {
+		return d, fmt.Errorf("%s is not a %s", p.ritm.Number, catalogItemName)
+	}
+	d.original = p.ritm
+
+	d.secrets, err = d.newSecretStore()
+	return d, err
+}
+
+// run disables deletion protection on the databases if needed, then removes
+// their configuration. Each step is a pull request with its own state file,
+// so an interrupted decommission resumes where it stopped.
+func (d *decommission) run() error {
+	defer d.removeClone()
+
+	removing, err := loadState(d.stateDir, d.ritm.Number)
+	if err != nil {
+		return err
+	}
+	if !removing.started() {
+		err = d.disableProtection()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = d.useBranch(d.ritm.Number)
+	if err != nil {
+		return err
+	}
+	return d.runStages([]stage{
+		{name: stageClone, local: true, run: d.cloneConfig},
+		{name: stageBranch, local: true, run: d.newBranch},
+		{name: stageWrite, local: true, run: d.removeConfig},
+		{name: stageCommit, run: d.commitStage},
+		{name: stagePullRequest, run: func() error {
+			return d.openPullRequest(d.ritm.Number+" decommission "+d.original.Number, d.prBody(
+				"Removes the databases, which take a final snapshot when they are deleted:"))
+		}},
+		{name: stageMerge, run: d.mergeStage},
+		{name: stageApply, run: d.applyStage},
+		{name: stagePassword, run: d.removePasswords},
+		{name: stageRITM, run: d.closeRITMs},
+	})
+}
+
+// disableProtection turns deletion protection off in a first pull request,
+// unless it is already off on the base branch
+func (d *decommission) disableProtection() error {
+	err := d.useBranch(d.ritm.Number + "-protection")
+	if err != nil || d.state.done(stageApply) {
+		return err
+	}
+	defer d.removeClone()
+
+	err = d.cloneConfig()
+	if err != nil {
+		return err
+	}
+	f, err := readTFFile(d.fullPath)
+	if err != nil {
+		return err
+	}
+	changed, err := unprotect(f)
+	if err != nil {
+		return err
+	}
+	if !changed && !d.state.started() {
+		fmt.Println("Deletion protection is already disabled")
+		return nil
+	}
+
+	return d.runStages([]stage{
+		{name: stageBranch, local: true, run: d.newBranch},
+		{name: stageWrite, local: true, run: d.unprotectConfig},
+		{name: stageCommit, run: d.commitStage},
+		{name: stagePullRequest, run: func() error {
+			return d.openPullRequest(d.ritm.Number+" disable deletion protection", d.prBody(
+				"Disables deletion protection so a second pull request can remove the databases:"))
+		}},
+		{name: stageMerge, run: d.mergeStage},
+		{name: stageApply, run: d.applyStage},
+	})
+}
+
+// cloneConfig clones the repository and finds the configuration of the
+// provisioning RITM, which may have been generated in either format
+func (d *decommission) cloneConfig() error {
+	err := d.cloneStage()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"rds_" + d.original.Number + ".tf.json", "rds_" + d.original.Number + ".tf"} {
+		d.relPath = filepath.Join(tfConst, name)
+		d.fullPath = filepath.Join(d.tempDir, d.relPath)
+		if fileExists(d.fullPath) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no terraform for %s found in %s repository", d.original.Number, d.repoName)
+}
+
+func (d *decommission) unprotectConfig() error {
+	f, err := readTFFile(d.fullPath)
+	if err != nil {
+		return err
+	}
+	_, err = unprotect(f)
+	if err != nil {
+		return err
+	}
+	return f.write()
+}
+
+// removeConfig deletes the configuration, once every database in it can be
+// deleted with a final snapshot
+func (d *decommission) removeConfig() error {
+	f, err := readTFFile(d.fullPath)
+	if err != nil {
+		return err
+	}
+	changed, err := unprotect(f)
+	if err != nil {
+		return err
+	}
+	if changed {
+		return fmt.Errorf("deletion protection must be disabled in %s before it is removed", d.relPath)
+	}
+
+	fmt.Printf("Removing terraform file: %s\n", d.relPath)
+	return os.Remove(d.fullPath)
+}
+
+// unprotect disables deletion protection on every module and makes sure each
+// primary keeps its final snapshot, returning true if the file changed
+func unprotect(f *tfFile) (bool, error) {
+	var changed bool
+	set := func(module, name string, v interface{}) error {
+		changed = true
+		return f.setAttribute(module, name, v)
+	}
+
+	for _, name := range f.moduleNames() {
+		if v, ok := f.attribute(name, "deletion_protection"); ok && v != false {
+			err := set(name, "deletion_protection", false)
+			if err != nil {
+				return changed, err
+			}
+		}
+		if f.stringAttribute(name, "replicate_source_db") != "" {
+			continue // Replicas are deleted without a snapshot
+		}
+		if v, ok := f.attribute(name, "skip_final_snapshot"); ok && v != false {
+			err := set(name, "skip_final_snapshot", false)
+			if err != nil {
+				return changed, err
+			}
+		}
+		if f.stringAttribute(name, "final_snapshot_identifier") == "" {
+			id := f.stringAttribute(name, "identifier")
+			if id == "" {
+				return changed, fmt.Errorf("module %s in %s has no identifier", name, f.path)
+			}
+			err := set(name, "final_snapshot_identifier", finalSnapshotIdentifier(id))
+			if err != nil {
+				return changed, err
+			}
+		}
+	}
+	return changed, nil
+}
+
+// prBody links the pull request to both RITMs and lists the databases
+func (d *decommission) prBody(summary string) string {
+	body := fmt.Sprintf("%s\n\nDecommissions %s\n\n%s", ritmLink(d.ritm), ritmLink(d.original), summary)
+	for _, env := range d.original.environments() {
+		body += fmt.Sprintf("\n- %s: %s", env.name, env.identifier(d.original))
+		for n := 1; n < env.count; n++ {
+			body += ", " + env.replicaIdentifier(d.original, n)
+		}
+	}
+	return body
+}
+
+// removePasswords deletes the stored master passwords of the removed
+// databases
+func (d *decommission) removePasswords() error {
+	for _, env := range d.original.environments() {
+		err := d.secrets.remove(d.context(), env.identifier(d.original))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeRITMs closes the provisioning and decommission RITMs
+func (d *decommission) closeRITMs() error {
+	err := setRITMState(d.context(), d.snowClient, d.original, 3,
+		fmt.Sprintf("RDS decommissioned by %s via GRACE-PaaS CI/CD Pipeline", d.ritm.Number))
+	if err != nil {
+		return err
+	}
+	return setRITMState(d.context(), d.snowClient, d.ritm, 3, "RDS decommissioned via GRACE-PaaS CI/CD Pipeline")
+}
+
+// record posts the error to the decommission RITM, if it has been read
+func (d *decommission) record(e error) {
+	if e == nil || d.ritm == nil || d.snowClient == nil || d.commentInterrupted(e, "Decommissioning") {
+		return
+	}
+
+	err := reopenRITM(context.Background(), d.snowClient, d.ritm, "decommissioning RDS", e)
+	if err != nil {
+		fmt.Printf("Unable to update RITM: %v\n", err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewDecommission(t *testing.T) {
+	oldArgs, oldEnv := captureEnv()
+	defer resetEnv(oldArgs, oldEnv)
+	snow := fakeSnow(t)
+	defer snow.Close()
+	env := map[string]string{
+		"CIRCLE_TOKEN": "test",
+		"GITHUB_TOKEN": "test",
+		"SN_INSTANCE":  snow.URL,
+		"SN_PASSWORD":  "pass",
+		"SN_USER":      "user",
+	}
+	request := filepath.Join("testdata", "decommission.json")
+
+	tt := map[string]struct {
+		args []string
+		err  string
+	}{
+		"happy":   {args: []string{"-request", request, "-repo", "test-repo"}},
+		"no repo": {args: []string{"-request", request}, err: "reponame must be set"},
+		"no ritm": {args: []string{"-repo", "test-repo"}, err: "request, ritm or sys-id must be set"},
+		"bad store": {
+			args: []string{"-request", request, "-repo", "test-repo", "-secret-store", "x"},
+			err:  "secret-store must be one of ci, secretsmanager, ssm, vault, file",
+		},
+		"provisioning request": {
+			args: []string{"-request", filepath.Join("testdata", "test.json"), "-repo", "test-repo"},
+			err:  `invalid RITM (1 errors): provisioning_ritm: must be a RITM number, got ""`,
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			resetEnv(oldArgs, env)
+			d, err := newDecommission(context.Background(), "decommission", tc.args)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("newDecommission() failed: unexpected error: %v", err)
+				}
+				if d.ritm.Number != "RITM0002001" || d.original.Number != "RITM0001001" || d.original.Identifier != "test" {
+					t.Errorf("newDecommission() failed: unexpected RITMs: %+v %+v", d.ritm, d.original)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("newDecommission() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+// nolint: funlen
+func TestUnprotect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unprotect")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, format := range []string{tfConst, hclConst} {
+		f, err := readTFFile(writeTestTerraform(t, dir, format))
+		if err != nil {
+			t.Fatalf("readTFFile() failed: unexpected error: %v", err)
+		}
+		changed, err := unprotect(f)
+		if err != nil || !changed {
+			t.Fatalf("unprotect() failed: expected %s file to change, got: %v %v", format, changed, err)
+		}
+		for _, name := range f.moduleNames() {
+			if v, _ := f.attribute(name, "deletion_protection"); v != false {
+				t.Errorf("unprotect() failed: %s deletion_protection is %v", name, v)
+			}
+		}
+		changed, err = unprotect(f)
+		if err != nil || changed {
+			t.Errorf("unprotect() failed: expected unprotected %s file to be unchanged, got: %v %v", format, changed, err)
+		}
+	}
+
+	path := filepath.Join(dir, "edited.tf.json")
+	err = ioutil.WriteFile(path, []byte(`{"module": {
+		"test_prod": {"identifier": "test-prod", "deletion_protection": true, "skip_final_snapshot": true},
+		"test_prod_replica_1": {"identifier": "test-prod-replica-1", "replicate_source_db": "${module.test_prod.this_db_instance_id}"}
+	}}`), 0600)
+	if err != nil {
+		t.Fatalf("unable to write test file: %v", err)
+	}
+	f, err := readTFFile(path)
+	if err != nil {
+		t.Fatalf("readTFFile() failed: unexpected error: %v", err)
+	}
+	_, err = unprotect(f)
+	if err != nil {
+		t.Fatalf("unprotect() failed: unexpected error: %v", err)
+	}
+	for attr, expected := range map[string]interface{}{
+		"deletion_protection":       false,
+		"skip_final_snapshot":       false,
+		"final_snapshot_identifier": "test-prod-final-shapshot",
+	} {
+		if v, _ := f.attribute("test_prod", attr); v != expected {
+			t.Errorf("unprotect() failed: expected %s %v, got: %v", attr, expected, v)
+		}
+	}
+	if _, ok := f.attribute("test_prod_replica_1", "final_snapshot_identifier"); ok {
+		t.Errorf("unprotect() failed: final snapshot added to replica")
+	}
+}
+
+// Tests that the clone is removed when deletion protection is already off
+func TestDisableProtectionUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "decommission-repo")
+	err := os.MkdirAll(filepath.Join(repo, tfConst), 0750)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(repo, tfConst, "rds_RITM0001001.tf.json"),
+			[]byte(`{"module": {"test_dev": {"identifier": "test-dev", "deletion_protection": false,
+				"final_snapshot_identifier": "test-dev-final-snapshot"}}}`), 0600)
+	}
+	if err != nil {
+		t.Fatalf("unable to write terraform file: %v", err)
+	}
+	initRepo(t, repo)
+
+	d := &decommission{
+		req: &req{
+			ctx:      context.Background(),
+			ritm:     &ritm{Number: "RITM0002001"},
+			host:     &localHost{dir: dir},
+			repoName: "decommission-repo",
+			stateDir: filepath.Join(dir, "state"),
+		},
+		original: &ritm{Number: "RITM0001001"},
+	}
+	err = d.disableProtection()
+	if err != nil {
+		t.Fatalf("disableProtection() failed: unexpected error: %v", err)
+	}
+	if d.state.started() {
+		t.Errorf("disableProtection() failed: unexpected stages run: %+v", d.state)
+	}
+	if _, err := os.Stat(d.tempDir); !os.IsNotExist(err) {
+		t.Errorf("disableProtection() failed: expected %s to be removed, got: %v", d.tempDir, err)
+	}
+}
+
+func TestDecommissionComplete(t *testing.T) {
+	snow := fakeSnow(t)
+	defer snow.Close()
+
+	store := &testStore{passwords: map[string]string{"test-dev": "one", "test-prod": "two", "other-dev": "three"}}
+	original := &ritm{Number: "RITM0001001", SysID: testSysID, Identifier: "test", DevCount: "1", ProdCount: "2"}
+	d := &decommission{
+		req: &req{
+			ritm:       &ritm{Number: "RITM0002001", SysID: "fedcba9876543210fedcba9876543210"},
+			secrets:    store,
+			snowClient: snow.client(),
+		},
+		original: original,
+	}
+
+	body := d.prBody("Removes the databases:")
+	if !strings.Contains(body, "Decommissions [RITM0001001]") || !strings.HasSuffix(body,
+		"Removes the databases:\n- development: test-dev\n- production: test-prod, test-prod-replica-1") {
+		t.Errorf("prBody() failed: unexpected body: %s", body)
+	}
+
+	err := d.removePasswords()
+	if err != nil {
+		t.Fatalf("removePasswords() failed: unexpected error: %v", err)
+	}
+	if len(store.passwords) != 1 || store.passwords["other-dev"] != "three" {
+		t.Errorf("removePasswords() failed: unexpected passwords: %v", store.passwords)
+	}
+
+	err = d.closeRITMs()
+	if err != nil {
+		t.Fatalf("closeRITMs() failed: unexpected error: %v", err)
+	}
+	d.record(nil)
+	d.record(os.ErrNotExist)
+	expected := []string{
+		testSysID + " 3 RDS decommissioned by RITM0002001 via GRACE-PaaS CI/CD Pipeline",
+		"fedcba9876543210fedcba9876543210 3 RDS decommissioned via GRACE-PaaS CI/CD Pipeline",
+		"fedcba9876543210fedcba9876543210 8 Error decommissioning RDS, the request has been reopened for review",
+	}
+	if len(snow.updates) != len(expected) {
+		t.Fatalf("closeRITMs() failed: expected %d updates, got: %v", len(expected), snow.updates)
+	}
+	for i, u := range snow.updates {
+		got := fmt.Sprintf("%v %v %v", u["sys_id"], u["state"], u["comments"])
+		if got != expected[i] {
+			t.Errorf("closeRITMs() failed: expected update: %s\nGot: %s", expected[i], got)
+		}
+	}
+	if note := snow.updates[2]["work_notes"]; note != "Error decommissioning RDS: file does not exist" {
+		t.Errorf("record() failed: unexpected work note: %v", note)
+	}
+}
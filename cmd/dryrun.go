@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handleDryRun generates the terraform to a local file and prints the changes
+// handleTerraform would make, without contacting GitHub, CircleCI or ServiceNow
+func (r *req) handleDryRun() error {
+	tf, err := r.ritm.generateTerraform(r.catalog, r.secrets)
+	if err != nil {
+		return err
+	}
+
+	err = r.writeTerraform(tf)
+	if err != nil {
+		return err
+	}
+
+	r.printPlan(os.Stdout)
+	return nil
+}
+
+// printPlan describes the pipeline changes for the request. Password values
+// are never printed.
+func (r *req) printPlan(w io.Writer) {
+	update := r.ritmUpdate(nil)
+	fmt.Fprintf(w, "Dry run: GitHub, CircleCI and ServiceNow were not contacted\n")
+	fmt.Fprintf(w, "Terraform file: %s (committed as %s)\n", r.fullPath, filepath.Join(tfConst, filepath.Base(r.fullPath)))
+	fmt.Fprintf(w, "Branch: %s in %s/%s repository\n", r.ritm.Number, r.config.Owner, r.repoName)
+	fmt.Fprintf(w, "Pull request title: %s\n", r.ritm.Number)
+	fmt.Fprintf(w, "Pull request base: %s, reviewers: %s\n", r.config.BaseBranch, strings.Join(r.config.Reviewers, ", "))
+	fmt.Fprintf(w, "Pull request body:\n%s\n", r.prBody())
+	for _, env := range r.ritm.environments() {
+		fmt.Fprintf(w, "Master password: %s\n", r.secrets.location(env.identifier(r.ritm)))
+	}
+	fmt.Fprintf(w, "ServiceNow %s (%s) update: state %d (%s), comments %q, work notes %q\n", r.ritm.Number, r.ritm.SysID,
+		update["state"], ritmStateName(update["state"].(int)), update["comments"], update["work_notes"])
+}
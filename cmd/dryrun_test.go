@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandleDryRun(t *testing.T) {
+	oldArgs, oldEnv := captureEnv()
+	outFile := filepath.Join(os.TempDir(), "rds_dry_run.tf")
+	resetEnv([]string{"cmd1", "-request", filepath.Join("testdata", "test.json"), "-format", "hcl",
+		"-repo", "test", "-dry-run", "-outfile", outFile},
+		map[string]string{
+			"CIRCLE_TOKEN": "",
+			"GITHUB_TOKEN": "",
+			"SN_INSTANCE":  "",
+			"SN_PASSWORD":  "",
+			"SN_USER":      "",
+		})
+	defer resetEnv(oldArgs, oldEnv)
+
+	r, err := newReq(context.Background())
+	if err != nil {
+		t.Fatalf("newReq() failed: unexpected error: %v", err)
+	}
+	if r.host != nil || r.ci != nil || r.snowClient != nil {
+		t.Errorf("newReq() failed: clients created for dry run")
+	}
+
+	err = handleRITM(context.Background(), r)
+	if err != nil {
+		t.Fatalf("handleRITM() failed: unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("handleDryRun() failed: unable to read %s: %v", outFile, err)
+	}
+	if !strings.Contains(string(b), `module "test_dev" {`) {
+		t.Errorf("handleDryRun() failed: unexpected terraform:\n%s", b)
+	}
+
+	err = os.Remove(outFile)
+	if err != nil {
+		t.Fatalf("handleDryRun() failed. Unable to remove testFile: %v", err)
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	var r req
+	r.inFile = filepath.Join("testdata", "test.json")
+	err := r.parseRITM()
+	if err != nil {
+		t.Fatalf("printPlan() failed. Unable to parse test data: %v", err)
+	}
+	r.repoName = "test-repo"
+	r.config = &config{Owner: "GSA", BaseBranch: "main", Reviewers: []string{"dba", "grace-developers"},
+		ProvisionedState: "closed_complete"}
+	r.fullPath = "rds_RITM0001001.tf.json"
+	r.secrets = &ciStore{repo: r.repoName}
+
+	var buf bytes.Buffer
+	r.printPlan(&buf)
+	out := buf.String()
+	for _, expected := range []string{
+		"Branch: RITM0001001 in GSA/test-repo repository",
+		"Pull request title: RITM0001001",
+		"Pull request base: main, reviewers: dba, grace-developers",
+		"- production: large postgres12 RDS",
+		"Master password: CircleCI environment variable TF_VAR_test_prod_db_password in test-repo project",
+		"update: state 3 (Closed Complete)",
+		`work notes "RDS instances provisioned: test-dev (development), test-test (test), test-prod (production)"`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("printPlan() failed: expected output to contain: %s\nGot:\n%s", expected, out)
+		}
+	}
+}
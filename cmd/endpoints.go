@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+)
+
+// endpointFinder looks up the address of an applied RDS instance
+type endpointFinder interface {
+	endpoint(ctx context.Context, id string) (string, error)
+}
+
+// rdsEndpoints looks up the endpoints with the RDS API, which needs AWS
+// credentials for the account of the instances where the pipeline runs
+type rdsEndpoints struct {
+	client rdsiface.RDSAPI
+}
+
+func newRDSEndpoints(cfgs ...*aws.Config) (endpointFinder, error) {
+	sess, err := session.NewSession(cfgs...)
+	if err != nil {
+		return nil, err
+	}
+	return &rdsEndpoints{client: rds.New(sess)}, nil
+}
+
+// endpoint returns the address and port of the instance
+func (e *rdsEndpoints) endpoint(ctx context.Context, id string) (string, error) {
+	out, err := e.client.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{DBInstanceIdentifier: aws.String(id)})
+	if err != nil {
+		return "", err
+	}
+	if len(out.DBInstances) == 0 || out.DBInstances[0].Endpoint == nil {
+		return "", fmt.Errorf("RDS instance %s has no endpoint", id)
+	}
+	endpoint := out.DBInstances[0].Endpoint
+	return fmt.Sprintf("%s:%d", aws.StringValue(endpoint.Address), aws.Int64Value(endpoint.Port)), nil
+}
+
+// noteEndpoints comments the endpoints of the applied instances on the RITM.
+// Endpoints that cannot be looked up are left out of the comment, and their
+// errors are only in the work note.
+func (r *req) noteEndpoints() {
+	if r.endpoints == nil {
+		return
+	}
+	var found, missing []string
+	for _, env := range r.ritm.environments() {
+		for _, id := range env.instanceIdentifiers(r.ritm) {
+			endpoint, err := r.endpoints.endpoint(r.context(), id)
+			if err != nil {
+				fmt.Printf("Unable to look up the endpoint of %s: %v\n", id, err)
+				missing = append(missing, fmt.Sprintf("%s (%v)", id, err))
+				continue
+			}
+			found = append(found, fmt.Sprintf("%s (%s)", id, endpoint))
+		}
+	}
+
+	var comment string
+	if len(found) > 0 {
+		comment = "RDS endpoints available: " + strings.Join(found, ", ")
+	}
+	note := comment
+	if len(missing) > 0 {
+		note = strings.TrimSpace(note + "\nRDS endpoints not found: " + strings.Join(missing, ", "))
+	}
+	r.noteRITM(comment, note)
+}
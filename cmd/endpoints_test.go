@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeRDS serves the DescribeDBInstances query API for the instances in
+// addresses, which are not found otherwise
+func fakeRDS(t *testing.T, addresses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil || r.Form.Get("Action") != "DescribeDBInstances" {
+			t.Errorf("unexpected RDS call: %v %v", r.Form, err)
+		}
+		id := r.Form.Get("DBInstanceIdentifier")
+		address, ok := addresses[id]
+		w.Header().Set("Content-Type", "text/xml")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>DBInstanceNotFound</Code>`+
+				`<Message>DBInstance %s not found.</Message></Error><RequestId>1</RequestId></ErrorResponse>`, id)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `<DescribeDBInstancesResponse><DescribeDBInstancesResult><DBInstances><DBInstance>`+
+			`<DBInstanceIdentifier>%s</DBInstanceIdentifier><Endpoint><Address>%s</Address><Port>5432</Port></Endpoint>`+
+			`</DBInstance></DBInstances></DescribeDBInstancesResult></DescribeDBInstancesResponse>`, id, address)
+	}))
+}
+
+func TestNoteEndpoints(t *testing.T) {
+	snow := fakeSnow(t)
+	defer snow.Close()
+	ts := fakeRDS(t, map[string]string{
+		"test-dev":           "test-dev.abc123.us-east-1.rds.amazonaws.com",
+		"test-dev-replica-1": "test-dev-replica-1.abc123.us-east-1.rds.amazonaws.com",
+	})
+	defer ts.Close()
+
+	endpoints, err := newRDSEndpoints(testAWSConfig(ts.URL))
+	if err != nil {
+		t.Fatalf("newRDSEndpoints() failed: unexpected error: %v", err)
+	}
+	r := &req{
+		endpoints:  endpoints,
+		ritm:       &ritm{Number: "RITM0001001", SysID: testSysID, Identifier: "test", DevCount: "2", ProdCount: "1"},
+		snowClient: snow.client(),
+	}
+	r.noteEndpoints()
+
+	comment := "RDS endpoints available: test-dev (test-dev.abc123.us-east-1.rds.amazonaws.com:5432), " +
+		"test-dev-replica-1 (test-dev-replica-1.abc123.us-east-1.rds.amazonaws.com:5432)"
+	if len(snow.updates) != 1 || snow.updates[0]["comments"] != comment {
+		t.Fatalf("noteEndpoints() failed: expected comment: %s\nGot: %v", comment, snow.updates)
+	}
+	note := fmt.Sprint(snow.updates[0]["work_notes"])
+	expected := comment + "\nRDS endpoints not found: test-prod (DBInstanceNotFound: DBInstance test-prod not found."
+	if !strings.HasPrefix(note, expected) {
+		t.Errorf("noteEndpoints() failed: expected work note: %s\nGot: %s", expected, note)
+	}
+
+	// Without any endpoint, the requester is not told of one
+	snow.updates = nil
+	r.ritm.DevCount = "0"
+	r.noteEndpoints()
+	if len(snow.updates) != 1 || snow.updates[0]["comments"] != nil {
+		t.Errorf("noteEndpoints() failed: expected only a work note, got: %v", snow.updates)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// environment is a single deployment environment requested in the RITM
+type environment struct {
+	name     string // development, test or production
+	suffix   string // appended to the RITM identifier
+	rawCount string // count as entered in the RITM
+	count    int    // number of instances requested
+	size     string // small, medium or large
+	multiAZ  string // "Yes" or "No"
+}
+
+// allEnvironments returns every environment in the RITM, including any with
+// a count of zero
+func (ritm *ritm) allEnvironments() []environment {
+	envs := []environment{
+		{name: "development", suffix: "dev", rawCount: ritm.DevCount, size: ritm.DevSize, multiAZ: ritm.DevMultiAZ},
+		{name: "test", suffix: "test", rawCount: ritm.TestCount, size: ritm.TestSize, multiAZ: ritm.TestMultiAZ},
+		{name: "production", suffix: "prod", rawCount: ritm.ProdCount, size: ritm.ProdSize, multiAZ: ritm.ProdMultiAZ},
+	}
+	for i := range envs {
+		n, err := strconv.Atoi(strings.TrimSpace(envs[i].rawCount))
+		if err == nil && n > 0 {
+			envs[i].count = n
+		}
+	}
+	return envs
+}
+
+// environments returns the environments requested in the RITM, skipping any
+// with a count of zero
+func (ritm *ritm) environments() []environment {
+	var envs []environment
+	for _, env := range ritm.allEnvironments() {
+		if env.count > 0 {
+			envs = append(envs, env)
+		}
+	}
+	return envs
+}
+
+// identifier returns the RDS identifier for the environment
+func (env environment) identifier(ritm *ritm) string {
+	return ritm.Identifier + "-" + env.suffix
+}
+
+// replicaIdentifier returns the RDS identifier for the nth read replica of the
+// environment's primary instance
+func (env environment) replicaIdentifier(ritm *ritm, n int) string {
+	return fmt.Sprintf("%s-replica-%d", env.identifier(ritm), n)
+}
+
+// instanceIdentifiers returns the RDS identifiers of the environment's
+// primary instance and its read replicas
+func (env environment) instanceIdentifiers(ritm *ritm) []string {
+	ids := []string{env.identifier(ritm)}
+	for n := 1; n < env.count; n++ {
+		ids = append(ids, env.replicaIdentifier(ritm, n))
+	}
+	return ids
+}
+
+// resourceName converts an RDS identifier to a Terraform resource name
+func resourceName(id string) string {
+	return strings.ReplaceAll(id, "-", "_") // Conforms to our naming standard
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestEnvironments(t *testing.T) {
+	r := &ritm{
+		Identifier: "test-rds",
+		DevCount:   "1",
+		DevSize:    "small",
+		DevMultiAZ: "No",
+		TestCount:  "0",
+		TestSize:   "medium",
+		ProdCount:  "2",
+		ProdSize:   "large",
+	}
+
+	envs := r.environments()
+	if len(envs) != 2 {
+		t.Fatalf("environments() failed: expected 2 environments, got: %d", len(envs))
+	}
+
+	expected := []string{"test-rds-dev", "test-rds-prod"}
+	for i, env := range envs {
+		if env.identifier(r) != expected[i] {
+			t.Errorf("environments() failed: expected identifier: %s got: %s", expected[i], env.identifier(r))
+		}
+	}
+
+	if envs[1].count != 2 || envs[1].size != "large" {
+		t.Errorf("environments() failed: unexpected production environment: %+v", envs[1])
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	expected := "test_rds_dev"
+	got := resourceName("test-rds-dev")
+	if got != expected {
+		t.Errorf("resourceName() failed: expected: %s got: %s", expected, got)
+	}
+}
@@ -8,23 +8,15 @@ import (
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 )
 
 func (r *req) cloneRepo() (*git.Repository, error) {
 	fmt.Printf("Cloning repository: %s to: %s\n", r.repoName, r.tempDir)
-	url := r.githubURL + r.repoName
+	url := r.host.cloneURL(r.repoName)
 	directory := r.tempDir
-	token := os.Getenv("GITHUB_TOKEN")
 
-	resp, err := git.PlainClone(directory, false, &git.CloneOptions{
-		// The intended use of a GitHub personal access token is in replace of your password
-		// because access tokens can easily be revoked.
-		// https://help.github.com/articles/creating-a-personal-access-token-for-the-command-line/
-		Auth: &http.BasicAuth{
-			Username: "access_token", // yes, this can be anything except an empty string
-			Password: token,
-		},
+	resp, err := git.PlainCloneContext(r.context(), directory, false, &git.CloneOptions{
+		Auth:     r.host.auth(),
 		URL:      url,
 		Progress: os.Stdout,
 	})
@@ -36,19 +28,28 @@ func (r *req) cloneRepo() (*git.Repository, error) {
 }
 
 func (r *req) newBranch() error {
-	fmt.Printf("Adding branch: %s\n", r.ritm.Number)
-	branch := plumbing.ReferenceName("refs/heads/" + r.ritm.Number)
+	branch := plumbing.ReferenceName("refs/heads/" + r.branch)
+	opts := &git.CheckoutOptions{
+		Create: true,
+		Force:  false,
+		Branch: branch,
+	}
+
+	// Reuse the branch if a previous run pushed it
+	remote, err := r.repo.Reference(plumbing.NewRemoteReferenceName("origin", r.branch), true)
+	if err == nil {
+		fmt.Printf("Reusing existing branch: %s\n", r.branch)
+		opts.Hash = remote.Hash()
+	} else {
+		fmt.Printf("Adding branch: %s\n", r.branch)
+	}
 
 	w, err := r.repo.Worktree()
 	if err != nil {
 		return err
 	}
 
-	err = w.Checkout(&git.CheckoutOptions{
-		Create: true,
-		Force:  false,
-		Branch: branch,
-	})
+	err = w.Checkout(opts)
 	if err != nil {
 		return err
 	}
@@ -67,10 +68,10 @@ func (r *req) commit() error {
 	if err != nil {
 		return err
 	}
-	_, err = w.Commit(r.ritm.Number, &git.CommitOptions{
+	_, err = w.Commit(r.branch, &git.CommitOptions{
 		Author: &object.Signature{
 			Name:  r.ritm.Number,
-			Email: r.email,
+			Email: r.config.Email,
 			When:  time.Now(),
 		},
 	})
@@ -78,14 +79,11 @@ func (r *req) commit() error {
 		return err
 	}
 
-	fmt.Println("Pushing changes to GitHub")
-	err = r.repo.Push(&git.PushOptions{
-		Auth: &http.BasicAuth{
-			Username: "access_token", // yes, this can be anything except an empty string
-			Password: os.Getenv("GITHUB_TOKEN"),
-		},
+	fmt.Println("Pushing changes")
+	err = r.repo.PushContext(r.context(), &git.PushOptions{
+		Auth: r.host.auth(),
 	})
-	if err != nil {
+	if err != nil && err != git.NoErrAlreadyUpToDate {
 		return err
 	}
 
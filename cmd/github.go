@@ -2,78 +2,124 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"os"
 	"time"
 
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/google/go-github/v28/github"
 	"golang.org/x/oauth2"
 )
 
-func newAuthenticatedClient() *github.Client {
-	ctx := context.Background()
+const gitHubTimeout = 30 * time.Second // GitHub REST API request timeout
+
+// gitHub hosts the repositories on github.com or GitHub Enterprise
+type gitHub struct {
+	client *github.Client
+	owner  string
+	url    string // web URL the repositories are cloned from
+	token  string
+}
+
+// newGitHub returns a GitHub client, using the GitHub Enterprise API URLs if
+// they are configured
+func newGitHub(c *config, token string) (*gitHub, error) {
 	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: os.Getenv("GITHUB_TOKEN")},
+		&oauth2.Token{AccessToken: token},
 	)
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, newHTTPClient(gitHubTimeout, newRetryPolicy(c.MaxAttempts)))
 	tc := oauth2.NewClient(ctx, ts)
 
-	return github.NewClient(tc)
+	client := github.NewClient(tc)
+	if c.GitHubAPIURL != "" {
+		var err error
+		client, err = github.NewEnterpriseClient(c.GitHubAPIURL, c.GitHubUploadURL, tc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &gitHub{client: client, owner: c.Owner, url: c.GitHubURL, token: token}, nil
 }
 
-func (r *req) pullRequest() (*github.PullRequest, error) {
-	fmt.Println("Creating Pull request")
-	ctx := context.Background()
-	baseBranch := "master"
-	commitBranch := r.ritm.Number
-	owner := "GSA"
-	serviceNowURL := fmt.Sprintf("https://%s/nav_to.do?uri=sc_req_item.do%%3Fsys_id%%3D", os.Getenv("SN_INSTANCE"))
-	prBody := fmt.Sprintf("[%s](%s%s)\n- %s %s RDS in %s account",
-		r.ritm.Number, serviceNowURL, r.ritm.SysID, r.ritm.DevSize, r.ritm.Engine, r.ritm.Account)
+func (g *gitHub) cloneURL(repo string) string {
+	return g.url + "/" + g.owner + "/" + repo
+}
+
+func (g *gitHub) auth() transport.AuthMethod {
+	// The intended use of a GitHub personal access token is in replace of your password
+	// because access tokens can easily be revoked.
+	// https://help.github.com/articles/creating-a-personal-access-token-for-the-command-line/
+	return &http.BasicAuth{
+		Username: "access_token", // yes, this can be anything except an empty string
+		Password: g.token,
+	}
+}
+
+func (g *gitHub) createPullRequest(ctx context.Context, repo, head, base, title, body string) (*pullRequest, error) {
 	newPR := &github.NewPullRequest{
-		Title: &r.ritm.Number,
-		Head:  &commitBranch,
-		Base:  &baseBranch,
-		Body:  &prBody,
+		Title: &title,
+		Head:  &head,
+		Base:  &base,
+		Body:  &body,
 	}
 
-	pr, _, err := r.githubClient.PullRequests.Create(ctx, owner, r.repoName, newPR)
+	pr, _, err := g.client.PullRequests.Create(ctx, g.owner, repo, newPR)
 	if err != nil {
-		return pr, err
+		return nil, err
 	}
+	return fromGitHub(pr), nil
+}
 
-	revReq := github.ReviewersRequest{
-		TeamReviewers: []string{"grace-developers"},
+// findPullRequest returns the open pull request of the branch, or nil
+func (g *gitHub) findPullRequest(ctx context.Context, repo, head string) (*pullRequest, error) {
+	prs, _, err := g.client.PullRequests.List(ctx, g.owner, repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  g.owner + ":" + head,
+	})
+	if err != nil || len(prs) == 0 {
+		return nil, err
 	}
+	return fromGitHub(prs[0]), nil
+}
 
-	_, _, err = r.githubClient.PullRequests.RequestReviewers(ctx, owner, r.repoName, *pr.Number, revReq)
-	if err != nil {
-		return pr, err
+// requestReviewers requests reviews from the GitHub teams. Requesting the
+// same reviews again changes nothing, so the request is retried.
+func (g *gitHub) requestReviewers(ctx context.Context, repo string, number int, reviewers []string) error {
+	revReq := github.ReviewersRequest{
+		TeamReviewers: reviewers,
 	}
 
-	return pr, nil
+	_, _, err := g.client.PullRequests.RequestReviewers(idempotent(ctx), g.owner, repo, number, revReq)
+	return err
 }
 
-func waitForMerge(pr *github.PullRequest) error {
-	client := newAuthenticatedClient()
-	ctx := context.Background()
-	owner := *pr.Base.Repo.Owner.Login
-	repo := *pr.Base.Repo.Name
-	var err error
-
-	fmt.Print("Waiting for Pull Request to be merged")
-	for *pr.State != "closed" {
-		fmt.Print(".")
-		time.Sleep(10 * time.Second)
-		pr, _, err = client.PullRequests.Get(ctx, owner, repo, *pr.Number)
-		if err != nil {
-			return err
-		}
+func (g *gitHub) getPullRequest(ctx context.Context, repo string, number int) (*pullRequest, error) {
+	pr, _, err := g.client.PullRequests.Get(ctx, g.owner, repo, number)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println()
+	return fromGitHub(pr), nil
+}
 
-	if !*pr.Merged {
-		return fmt.Errorf("pull request %s but not merged", *pr.State)
+func (g *gitHub) branchHead(ctx context.Context, repo, branch string) (string, error) {
+	b, _, err := g.client.Repositories.GetBranch(ctx, g.owner, repo, branch)
+	if err != nil {
+		return "", err
 	}
+	return b.GetCommit().GetSHA(), nil
+}
+
+// fromGitHub converts a GitHub pull request
+func fromGitHub(pr *github.PullRequest) *pullRequest {
+	return &pullRequest{
+		Number:   pr.GetNumber(),
+		URL:      pr.GetHTMLURL(),
+		State:    pr.GetState(),
+		Merged:   pr.GetMerged(),
+		MergedAt: pr.GetMergedAt(),
+		Base:     pr.GetBase().GetRef(),
+		HeadSHA:  pr.GetHead().GetSHA(),
 
-	return nil
+		MergeCommitSHA: pr.GetMergeCommitSHA(),
+	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeEnterprise serves the pull requests of the GSA/test-repo repository
+// under the GitHub Enterprise API path. The RITM0001001 branch has no open
+// pull request until it is created.
+func fakeEnterprise(t *testing.T) *httptest.Server {
+	opened := false
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test" {
+			t.Errorf("unexpected Authorization header: %s", r.Header.Get("Authorization"))
+		}
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/v3/repos/GSA/test-repo/pulls":
+			if body["head"] != "RITM0001001" || body["base"] != "main" || body["title"] != "RITM0001001" {
+				t.Errorf("unexpected pull request: %v", body)
+			}
+			opened = true
+			_, _ = w.Write([]byte(`{"number": 7, "state": "open", "html_url": "https://github.example.gov/GSA/test-repo/pull/7"}`))
+		case "POST /api/v3/repos/GSA/test-repo/pulls/7/requested_reviewers":
+			if teams, _ := json.Marshal(body["team_reviewers"]); string(teams) != `["dba","grace-developers"]` {
+				t.Errorf("unexpected reviewers: %v", body)
+			}
+			_, _ = w.Write([]byte(`{"number": 7}`))
+		case "GET /api/v3/repos/GSA/test-repo/pulls":
+			if r.URL.Query().Get("head") != "GSA:RITM0001001" || r.URL.Query().Get("state") != "open" {
+				t.Errorf("unexpected pull request query: %s", r.URL.RawQuery)
+			}
+			if !opened {
+				_, _ = w.Write([]byte(`[]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"number": 7, "state": "open"}]`))
+		case "GET /api/v3/repos/GSA/test-repo/pulls/7":
+			_, _ = w.Write([]byte(`{"number": 7, "state": "closed", "merged": true, "merged_at": "2021-05-01T12:00:00Z",
+				"base": {"ref": "main"}, "head": {"sha": "def456"}, "merge_commit_sha": "fed789"}`))
+		default:
+			t.Errorf("unexpected GitHub request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGitHubEnterprise(t *testing.T) {
+	ts := fakeEnterprise(t)
+	defer ts.Close()
+
+	c := defaultConfig()
+	c.BaseBranch = "main"
+	c.Reviewers = []string{"dba", "grace-developers"}
+	c.GitHubURL = ts.URL
+	c.GitHubAPIURL = ts.URL + "/api/v3/"
+	c.GitHubUploadURL = ts.URL + "/api/uploads/"
+	host, err := newGitHub(c, "test")
+	if err != nil {
+		t.Fatalf("newGitHub() failed: unexpected error: %v", err)
+	}
+	if u := host.cloneURL("test-repo"); u != ts.URL+"/GSA/test-repo" {
+		t.Errorf("cloneURL() failed: unexpected URL: %s", u)
+	}
+
+	r := &req{config: c, host: host, branch: "RITM0001001", repoName: "test-repo"}
+	pr, err := r.pullRequest("RITM0001001", "body")
+	if err != nil {
+		t.Fatalf("pullRequest() failed: unexpected error: %v", err)
+	}
+	if pr.Number != 7 || pr.State != "open" || pr.URL != "https://github.example.gov/GSA/test-repo/pull/7" {
+		t.Errorf("pullRequest() failed: unexpected pull request: %+v", pr)
+	}
+
+	pr, err = host.findPullRequest(context.Background(), "test-repo", "RITM0001001")
+	if err != nil || pr == nil || pr.Number != 7 {
+		t.Errorf("findPullRequest() failed: expected pull request 7, got: %+v %v", pr, err)
+	}
+
+	pr, err = r.getPullRequest(7)
+	if err != nil {
+		t.Fatalf("getPullRequest() failed: unexpected error: %v", err)
+	}
+	pr, err = r.waitForMerge(pr)
+	if err != nil {
+		t.Fatalf("waitForMerge() failed: unexpected error: %v", err)
+	}
+	if !pr.Merged || pr.MergedAt.IsZero() || pr.Base != "main" || pr.HeadSHA != "def456" || pr.mergedSHA() != "fed789" {
+		t.Errorf("waitForMerge() failed: unexpected pull request: %+v", pr)
+	}
+}
+
+func TestNewCodeHost(t *testing.T) {
+	c := defaultConfig()
+	host, err := newCodeHost(c)
+	if err != nil {
+		t.Fatalf("newCodeHost() failed: unexpected error: %v", err)
+	}
+	if u := host.cloneURL("test-repo"); u != "https://github.com/GSA/test-repo" {
+		t.Errorf("newCodeHost() failed: unexpected GitHub clone URL: %s", u)
+	}
+
+	c.CodeHost = gitLabHost
+	host, err = newCodeHost(c)
+	if err != nil {
+		t.Fatalf("newCodeHost() failed: unexpected error: %v", err)
+	}
+	if u := host.cloneURL("test-repo"); u != "https://gitlab.com/GSA/test-repo.git" {
+		t.Errorf("newCodeHost() failed: unexpected GitLab clone URL: %s", u)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const gitLabTimeout = 30 * time.Second // GitLab REST API request timeout
+
+// gitLab hosts the repositories on GitLab, using merge requests for the
+// changes
+type gitLab struct {
+	url    string // web URL, the API is at <url>/api/v4
+	owner  string // group of the projects
+	token  string
+	client *http.Client
+}
+
+// mergeRequest is the GitLab REST API merge request
+type mergeRequest struct {
+	IID          int        `json:"iid"`
+	WebURL       string     `json:"web_url"`
+	State        string     `json:"state"` // opened, closed, locked or merged
+	MergedAt     *time.Time `json:"merged_at"`
+	TargetBranch string     `json:"target_branch"`
+	SHA          string     `json:"sha"`
+
+	MergeCommitSHA  string `json:"merge_commit_sha"`  // unset by fast-forward merges
+	SquashCommitSHA string `json:"squash_commit_sha"` // the merged commit of a squashed fast-forward merge
+}
+
+func newGitLab(webURL, owner, token string, policy retryPolicy) *gitLab {
+	return &gitLab{url: webURL, owner: owner, token: token, client: newHTTPClient(gitLabTimeout, policy)}
+}
+
+func (g *gitLab) cloneURL(repo string) string {
+	return g.url + "/" + g.owner + "/" + repo + ".git"
+}
+
+func (g *gitLab) auth() transport.AuthMethod {
+	return &githttp.BasicAuth{
+		Username: "oauth2", // GitLab requires this username for access tokens
+		Password: g.token,
+	}
+}
+
+func (g *gitLab) createPullRequest(ctx context.Context, repo, head, base, title, body string) (*pullRequest, error) {
+	var mr mergeRequest
+	err := g.do(ctx, http.MethodPost, g.project(repo)+"/merge_requests", map[string]string{
+		"source_branch": head,
+		"target_branch": base,
+		"title":         title,
+		"description":   body,
+	}, &mr)
+	if err != nil {
+		return nil, err
+	}
+	return mr.pullRequest(), nil
+}
+
+// requestReviewers sets the GitLab users as the reviewers of the merge request
+func (g *gitLab) requestReviewers(ctx context.Context, repo string, number int, reviewers []string) error {
+	var ids []int
+	for _, name := range reviewers {
+		var users []struct {
+			ID int `json:"id"`
+		}
+		err := g.do(ctx, http.MethodGet, "users?username="+url.QueryEscape(name), nil, &users)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return fmt.Errorf("GitLab user %s not found", name)
+		}
+		ids = append(ids, users[0].ID)
+	}
+
+	path := fmt.Sprintf("%s/merge_requests/%d", g.project(repo), number)
+	return g.do(ctx, http.MethodPut, path, map[string][]int{"reviewer_ids": ids}, nil)
+}
+
+// findPullRequest returns the open merge request of the branch, or nil
+func (g *gitLab) findPullRequest(ctx context.Context, repo, head string) (*pullRequest, error) {
+	var mrs []mergeRequest
+	err := g.do(ctx, http.MethodGet, g.project(repo)+"/merge_requests?state=opened&source_branch="+url.QueryEscape(head), nil, &mrs)
+	if err != nil || len(mrs) == 0 {
+		return nil, err
+	}
+	return mrs[0].pullRequest(), nil
+}
+
+func (g *gitLab) getPullRequest(ctx context.Context, repo string, number int) (*pullRequest, error) {
+	var mr mergeRequest
+	err := g.do(ctx, http.MethodGet, fmt.Sprintf("%s/merge_requests/%d", g.project(repo), number), nil, &mr)
+	if err != nil {
+		return nil, err
+	}
+	return mr.pullRequest(), nil
+}
+
+func (g *gitLab) branchHead(ctx context.Context, repo, branch string) (string, error) {
+	var b struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+	err := g.do(ctx, http.MethodGet, g.project(repo)+"/repository/branches/"+url.PathEscape(branch), nil, &b)
+	return b.Commit.ID, err
+}
+
+// project is the API path of the repository's project
+func (g *gitLab) project(repo string) string {
+	return "projects/" + url.PathEscape(g.owner+"/"+repo)
+}
+
+// do sends a request to the GitLab REST API, encoding in as the JSON body and
+// decoding the response into out, if they are not nil
+func (g *gitLab) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, g.url+"/api/v4/"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", g.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024)) // Best effort, the status is reported regardless
+		return &statusError{status: resp.StatusCode, msg: fmt.Sprintf("GitLab %s %s failed: %s %s", method,
+			strings.SplitN(path, "?", 2)[0], resp.Status, strings.TrimSpace(string(msg)))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// pullRequest converts the merge request. Locked merge requests are being
+// merged, so are still open.
+func (mr *mergeRequest) pullRequest() *pullRequest {
+	pr := &pullRequest{
+		Number:  mr.IID,
+		URL:     mr.WebURL,
+		State:   "open",
+		Merged:  mr.State == "merged",
+		Base:    mr.TargetBranch,
+		HeadSHA: mr.SHA,
+
+		MergeCommitSHA: mr.MergeCommitSHA,
+	}
+	if pr.MergeCommitSHA == "" {
+		pr.MergeCommitSHA = mr.SquashCommitSHA
+	}
+	if mr.State == "closed" || mr.State == "merged" {
+		pr.State = "closed"
+	}
+	if mr.MergedAt != nil {
+		pr.MergedAt = *mr.MergedAt
+	}
+	return pr
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeGitLab serves the merge requests of the GSA/test-repo project. The
+// RITM0001001 branch has no open merge request until it is created.
+func fakeGitLab(t *testing.T) *httptest.Server {
+	opened := false
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "test" {
+			t.Errorf("unexpected PRIVATE-TOKEN header: %s", r.Header.Get("PRIVATE-TOKEN"))
+		}
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		const project = "/api/v4/projects/GSA%2Ftest-repo"
+		switch r.Method + " " + r.URL.EscapedPath() + "?" + r.URL.RawQuery {
+		case "POST " + project + "/merge_requests?":
+			if body["source_branch"] != "RITM0001001" || body["target_branch"] != "main" || body["description"] != "body" {
+				t.Errorf("unexpected merge request: %v", body)
+			}
+			opened = true
+			_, _ = w.Write([]byte(`{"iid": 7, "state": "opened", "web_url": "https://gitlab.example.gov/GSA/test-repo/-/merge_requests/7"}`))
+		case "GET " + project + "/merge_requests?state=opened&source_branch=RITM0001001":
+			if !opened {
+				_, _ = w.Write([]byte(`[]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"iid": 7, "state": "opened"}]`))
+		case "GET " + project + "/merge_requests?state=opened&source_branch=RITM0001002":
+			_, _ = w.Write([]byte(`[]`))
+		case "GET /api/v4/users?username=dba":
+			_, _ = w.Write([]byte(`[{"id": 42, "username": "dba"}]`))
+		case "GET /api/v4/users?username=nobody":
+			_, _ = w.Write([]byte(`[]`))
+		case "PUT " + project + "/merge_requests/7?":
+			if ids, _ := json.Marshal(body["reviewer_ids"]); string(ids) != `[42]` {
+				t.Errorf("unexpected reviewers: %v", body)
+			}
+			_, _ = w.Write([]byte(`{"iid": 7}`))
+		case "GET " + project + "/merge_requests/7?":
+			_, _ = w.Write([]byte(`{"iid": 7, "state": "merged", "merged_at": "2021-05-01T12:00:00Z", "target_branch": "main",
+				"sha": "def456", "merge_commit_sha": "fed789"}`))
+		case "GET " + project + "/merge_requests/8?":
+			_, _ = w.Write([]byte(`{"iid": 8, "state": "closed", "target_branch": "main"}`))
+		case "GET " + project + "/repository/branches/main?":
+			_, _ = w.Write([]byte(`{"name": "main", "commit": {"id": "abc123"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "404 Not found"}`))
+		}
+	}))
+}
+
+// nolint: funlen
+func TestGitLab(t *testing.T) {
+	ts := fakeGitLab(t)
+	defer ts.Close()
+
+	c := defaultConfig()
+	c.BaseBranch = "main"
+	c.Reviewers = []string{"dba"}
+	host := newGitLab(ts.URL, "GSA", "test", retryPolicy{})
+	r := &req{config: c, host: host, branch: "RITM0001001", repoName: "test-repo"}
+
+	pr, err := r.pullRequest("RITM0001001", "body")
+	if err != nil {
+		t.Fatalf("pullRequest() failed: unexpected error: %v", err)
+	}
+	if pr.Number != 7 || pr.State != "open" || pr.URL != "https://gitlab.example.gov/GSA/test-repo/-/merge_requests/7" {
+		t.Errorf("pullRequest() failed: unexpected pull request: %+v", pr)
+	}
+
+	pr, err = r.getPullRequest(7)
+	if err != nil {
+		t.Fatalf("getPullRequest() failed: unexpected error: %v", err)
+	}
+	pr, err = r.waitForMerge(pr)
+	if err != nil {
+		t.Fatalf("waitForMerge() failed: unexpected error: %v", err)
+	}
+	if !pr.Merged || pr.MergedAt.IsZero() || pr.Base != "main" || pr.HeadSHA != "def456" || pr.mergedSHA() != "fed789" {
+		t.Errorf("waitForMerge() failed: unexpected pull request: %+v", pr)
+	}
+
+	pr, err = r.getPullRequest(8)
+	if err != nil {
+		t.Fatalf("getPullRequest() failed: unexpected error: %v", err)
+	}
+	_, err = r.waitForMerge(pr)
+	if err == nil || err.Error() != "pull request closed but not merged" {
+		t.Errorf("waitForMerge() failed: expected closed error, got: %v", err)
+	}
+
+	sha, err := r.branchHead("main")
+	if err != nil || sha != "abc123" {
+		t.Errorf("branchHead() failed: expected abc123, got: %s %v", sha, err)
+	}
+
+	err = host.requestReviewers(context.Background(), "test-repo", 7, []string{"nobody"})
+	if err == nil || err.Error() != "GitLab user nobody not found" {
+		t.Errorf("requestReviewers() failed: expected missing user error, got: %v", err)
+	}
+
+	for branch, number := range map[string]int{"RITM0001001": 7, "RITM0001002": 0} {
+		pr, err = host.findPullRequest(context.Background(), "test-repo", branch)
+		if err != nil || (pr == nil) != (number == 0) || pr != nil && pr.Number != number {
+			t.Errorf("findPullRequest() failed: %s: expected merge request %d, got: %+v %v", branch, number, pr, err)
+		}
+	}
+
+	_, err = r.getPullRequest(9)
+	expected := `GitLab GET projects/GSA%2Ftest-repo/merge_requests/9 failed: 404 Not Found {"message": "404 Not found"}`
+	if err == nil || err.Error() != expected {
+		t.Errorf("getPullRequest() failed: expected error: %s\nGot: %v", expected, err)
+	}
+}
+
+func TestMergeRequestSHA(t *testing.T) {
+	tt := map[string]struct {
+		mr       mergeRequest
+		expected string
+	}{
+		"merge commit":        {mr: mergeRequest{SHA: "def456", MergeCommitSHA: "fed789", SquashCommitSHA: "cba321"}, expected: "fed789"},
+		"squash fast-forward": {mr: mergeRequest{SHA: "def456", SquashCommitSHA: "cba321"}, expected: "cba321"},
+		"fast-forward":        {mr: mergeRequest{SHA: "def456"}, expected: "def456"},
+	}
+	for name, tc := range tt {
+		if sha := tc.mr.pullRequest().mergedSHA(); sha != tc.expected {
+			t.Errorf("mergedSHA() failed: %s: expected %s, got: %s", name, tc.expected, sha)
+		}
+	}
+}
@@ -5,16 +5,19 @@ go 1.16
 require (
 	github.com/Microsoft/go-winio v0.5.0 // indirect
 	github.com/andrewstuart/servicenow v0.0.0-20171220221443-86b30969a69e
+	github.com/aws/aws-sdk-go v1.38.40
 	github.com/go-git/go-billy/v5 v5.3.1 // indirect
 	github.com/go-git/go-git/v5 v5.3.0
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/go-github/v28 v28.1.1
-	github.com/jszwedko/go-circleci v0.3.0
+	github.com/hashicorp/hcl/v2 v2.10.1
 	github.com/kevinburke/ssh_config v1.1.0 // indirect
 	github.com/sergi/go-diff v1.2.0 // indirect
-	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a // indirect
+	github.com/zclconf/go-cty v1.8.0
+	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
 	golang.org/x/net v0.0.0-20210510120150-4163338589ed // indirect
 	golang.org/x/oauth2 v0.0.0-20210427180440-81ed05c6b58c
 	golang.org/x/sys v0.0.0-20210514084401-e8d321eab015 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Parameter apply methods
+const (
+	applyImmediate     = "immediate"
+	applyPendingReboot = "pending-reboot"
+)
+
+// dbParameter is a parameter of the instance's parameter group
+type dbParameter struct {
+	ApplyMethod string `json:"apply_method,omitempty" yaml:"apply_method,omitempty"` // immediate (default) or pending-reboot
+	Name        string `json:"name" yaml:"name"`
+	Value       string `json:"value" yaml:"value"`
+}
+
+// dbOption is an option of the instance's option group
+type dbOption struct {
+	OptionName     string          `json:"option_name" yaml:"option_name"`
+	OptionSettings []optionSetting `json:"option_settings,omitempty" yaml:"option_settings,omitempty"`
+}
+
+// optionSetting is a setting of an option group option
+type optionSetting struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// parameterOverride allows a RITM to set a parameter to a value matching
+// the pattern
+type parameterOverride struct {
+	ApplyMethod string `json:"apply_method,omitempty" yaml:"apply_method,omitempty"` // for static parameters
+	Pattern     string `json:"pattern" yaml:"pattern"`                               // must match the whole value
+}
+
+// validateGroups checks the engine's parameters, options and allowed
+// overrides
+func (e *engineSpec) validateGroups() []string {
+	var errs []string
+	names := map[string]bool{}
+	for _, p := range e.Parameters {
+		switch {
+		case p.Name == "":
+			errs = append(errs, "parameter name must be set")
+		case names[p.Name]:
+			errs = append(errs, fmt.Sprintf("parameter %s is defined more than once", p.Name))
+		}
+		names[p.Name] = true
+		if !validApplyMethod(p.ApplyMethod) {
+			errs = append(errs, fmt.Sprintf("parameter %s has invalid apply_method %q", p.Name, p.ApplyMethod))
+		}
+	}
+	for _, o := range e.Options {
+		if o.OptionName == "" {
+			errs = append(errs, "option_name must be set")
+		}
+	}
+	for _, name := range e.overrideNames() {
+		o := e.Overrides[name]
+		if names[name] {
+			errs = append(errs, fmt.Sprintf("override %s would replace a catalog parameter", name))
+		}
+		if _, err := regexp.Compile(o.Pattern); err != nil || o.Pattern == "" {
+			errs = append(errs, fmt.Sprintf("override %s has invalid pattern %q", name, o.Pattern))
+		}
+		if !validApplyMethod(o.ApplyMethod) {
+			errs = append(errs, fmt.Sprintf("override %s has invalid apply_method %q", name, o.ApplyMethod))
+		}
+	}
+	return errs
+}
+
+func validApplyMethod(m string) bool {
+	return m == "" || m == applyImmediate || m == applyPendingReboot
+}
+
+// overrideNames returns the parameters a RITM may override, sorted
+func (e *engineSpec) overrideNames() []string {
+	names := make([]string, 0, len(e.Overrides))
+	for name := range e.Overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parameters returns the catalog parameters followed by the overrides
+func (e *engineSpec) parameters(overrides []dbParameter) []dbParameter {
+	return append(append([]dbParameter{}, e.Parameters...), overrides...)
+}
+
+// override returns the parameter setting name to value, if the engine allows
+// it
+func (e *engineSpec) override(name, value string) (dbParameter, error) {
+	o, ok := e.Overrides[name]
+	if !ok {
+		allowed := strings.Join(e.overrideNames(), ", ")
+		if allowed == "" {
+			allowed = "none"
+		}
+		return dbParameter{}, fmt.Errorf("%s cannot be overridden for %s, allowed: %s", name, e.Family, allowed)
+	}
+	if !regexp.MustCompile(`^(?:` + o.Pattern + `)$`).MatchString(value) { // Checked when the catalog is loaded
+		return dbParameter{}, fmt.Errorf("%s value %q does not match %s", name, value, o.Pattern)
+	}
+	return dbParameter{ApplyMethod: o.ApplyMethod, Name: name, Value: value}, nil
+}
+
+// parameterOverrides parses the RITM's parameter_overrides, one name=value
+// per line, into the engine's allowed overrides sorted by name
+func (ritm *ritm) parameterOverrides(e *engineSpec) ([]dbParameter, error) {
+	var params []dbParameter
+	var errs []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(ritm.ParameterOverrides, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			errs = append(errs, fmt.Sprintf("%q is not name=value", line))
+			continue
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if seen[name] {
+			errs = append(errs, fmt.Sprintf("%s is set more than once", name))
+			continue
+		}
+		seen[name] = true
+		p, err := e.override(name, value)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		params = append(params, p)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params, nil
+}
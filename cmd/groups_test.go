@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParameterOverrides(t *testing.T) {
+	tt := map[string]struct {
+		engine    string
+		overrides string
+		expected  []dbParameter
+		err       string
+	}{
+		"none": {engine: "postgres12"},
+		"sorted": {
+			engine:    "postgres12",
+			overrides: "work_mem = 8192\r\n\nmax_connections=500\n",
+			expected: []dbParameter{
+				{ApplyMethod: applyPendingReboot, Name: "max_connections", Value: "500"},
+				{Name: "work_mem", Value: "8192"},
+			},
+		},
+		"not allowed": {
+			engine:    "postgres12",
+			overrides: "rds.force_ssl=0",
+			err:       "rds.force_ssl cannot be overridden for postgres12, allowed: log_min_duration_statement, max_connections, work_mem",
+		},
+		"invalid value": {
+			engine:    "mysql8.0",
+			overrides: "long_query_time=1.5\nslow_query_log=yes",
+			err:       `slow_query_log value "yes" does not match 0|1`,
+		},
+		"errors": {
+			engine:    "mysql8.0",
+			overrides: "max_connections\nslow_query_log=1\nslow_query_log=0",
+			err:       `"max_connections" is not name=value; slow_query_log is set more than once`,
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			r := &ritm{ParameterOverrides: tc.overrides}
+			params, err := r.parameterOverrides(testCatalog(t).Engines[tc.engine])
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("parameterOverrides() failed: expected error: %s\nGot: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parameterOverrides() failed: unexpected error: %v", err)
+			}
+			if !jsonEqual(params, tc.expected) {
+				t.Errorf("parameterOverrides() failed: expected: %+v\nGot: %+v", tc.expected, params)
+			}
+		})
+	}
+}
+
+func TestGenerateGroups(t *testing.T) {
+	r := testRITM(t)
+	r.Engine = "mysql5.7"
+	r.ParameterOverrides = "max_connections=500"
+	tf, err := r.generateTerraform(testCatalog(t), &ciStore{})
+	if err != nil {
+		t.Fatalf("generateTerraform() failed: unexpected error: %v", err)
+	}
+
+	m := tf.Module["test_prod"]
+	if !m.CreateDBParameterGroup || !m.CreateDBOptionGroup || m.Family != "mysql5.7" {
+		t.Errorf("generateTerraform() failed: expected parameter and option groups, got: %+v", m)
+	}
+	if n := len(m.Parameters); n != 3 || m.Parameters[n-1].Name != "max_connections" {
+		t.Errorf("generateTerraform() failed: unexpected parameters: %+v", m.Parameters)
+	}
+	if len(m.Options) != 1 || m.Options[0].OptionName != "MARIADB_AUDIT_PLUGIN" {
+		t.Errorf("generateTerraform() failed: unexpected options: %+v", m.Options)
+	}
+
+	r.ParameterOverrides = "tls_version=TLSv1"
+	err = r.validate(testCatalog(t))
+	if err == nil || !strings.Contains(err.Error(), "parameter_overrides: tls_version cannot be overridden for mysql5.7") {
+		t.Errorf("validate() failed: expected parameter_overrides error, got: %v", err)
+	}
+}
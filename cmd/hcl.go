@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// hcl renders the configuration as native HCL syntax. The configuration is
+// first marshalled to JSON so both outputs are built from the same document.
+func (tf *terraform) hcl() ([]byte, error) {
+	b, err := json.Marshal(tf)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Data     []map[string]map[string]map[string]interface{} `json:"data"`
+		Module   map[string]map[string]interface{}              `json:"module"`
+		Resource []map[string]map[string]map[string]interface{} `json:"resource"`
+		Variable []map[string]map[string]interface{}            `json:"variable"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err = dec.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	f := hclwrite.NewEmptyFile()
+	body := f.Body()
+	for _, vars := range doc.Variable {
+		for _, name := range sortedKeys(vars) {
+			block := body.AppendNewBlock("variable", []string{name}).Body()
+			for _, attr := range sortedKeys(vars[name]) {
+				if attr == "type" { // Type constraints are expressions, not strings
+					block.SetAttributeRaw(attr, rawTokens(fmt.Sprint(vars[name][attr])))
+					continue
+				}
+				block.SetAttributeRaw(attr, valueTokens(vars[name][attr]))
+			}
+			body.AppendNewline()
+		}
+	}
+
+	appendTypedBlocks(body, "data", doc.Data)
+
+	for _, name := range sortedKeys(doc.Module) {
+		block := body.AppendNewBlock("module", []string{name}).Body()
+		setAttributes(block, doc.Module[name], "source", "version")
+		body.AppendNewline()
+	}
+
+	appendTypedBlocks(body, "resource", doc.Resource)
+
+	return hclwrite.Format(bytes.TrimSpace(f.Bytes())), nil
+}
+
+// appendTypedBlocks appends resource or data blocks, labelled by type and name
+func appendTypedBlocks(body *hclwrite.Body, blockType string, groups []map[string]map[string]map[string]interface{}) {
+	for _, group := range groups {
+		for _, typ := range sortedKeys(group) {
+			for _, name := range sortedKeys(group[typ]) {
+				block := body.AppendNewBlock(blockType, []string{typ, name}).Body()
+				setAttributes(block, group[typ][name])
+				body.AppendNewline()
+			}
+		}
+	}
+}
+
+func (tf *terraform) writeHCLFile(outFile string) error {
+	fmt.Printf("Writing HCL to file: %s\n", outFile)
+	b, err := tf.hcl()
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(outFile, append(b, '\n'), 0600)
+	return err
+}
+
+// setAttributes sets the attributes of a block body, with any first
+// attributes given ahead of the rest in alphabetical order
+func setAttributes(body *hclwrite.Body, attrs map[string]interface{}, first ...string) {
+	for _, name := range first {
+		if v, ok := attrs[name]; ok {
+			body.SetAttributeRaw(name, valueTokens(v))
+		}
+	}
+	for _, name := range sortedKeys(attrs) {
+		if !contains(first, name) {
+			body.SetAttributeRaw(name, valueTokens(attrs[name]))
+		}
+	}
+}
+
+// valueTokens converts a decoded JSON value to HCL tokens, converting
+// interpolation-only strings such as "${var.x}" to bare references
+func valueTokens(v interface{}) hclwrite.Tokens {
+	switch v := v.(type) {
+	case string:
+		if ref, ok := interpolation(v); ok {
+			return rawTokens(ref)
+		}
+		return quotedTokens(v)
+	case json.Number:
+		return rawTokens(v.String())
+	case bool:
+		return rawTokens(fmt.Sprint(v))
+	case []interface{}:
+		return listTokens(v)
+	case map[string]interface{}:
+		tokens := hclwrite.Tokens{
+			{Type: hclsyntax.TokenOBrace, Bytes: []byte("{")},
+			{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")},
+		}
+		for _, k := range sortedKeys(v) {
+			tokens = append(tokens,
+				&hclwrite.Token{Type: hclsyntax.TokenIdent, Bytes: []byte(k)},
+				&hclwrite.Token{Type: hclsyntax.TokenEqual, Bytes: []byte("=")})
+			tokens = append(tokens, valueTokens(v[k])...)
+			tokens = append(tokens, &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")})
+		}
+		return append(tokens, &hclwrite.Token{Type: hclsyntax.TokenCBrace, Bytes: []byte("}")})
+	}
+	return rawTokens("null")
+}
+
+// listTokens converts a list, placing each element on its own line if the
+// list contains objects
+func listTokens(list []interface{}) hclwrite.Tokens {
+	multiline := false
+	for _, e := range list {
+		if _, ok := e.(map[string]interface{}); ok {
+			multiline = true
+		}
+	}
+
+	newline := &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")}
+	comma := &hclwrite.Token{Type: hclsyntax.TokenComma, Bytes: []byte(",")}
+	tokens := hclwrite.Tokens{{Type: hclsyntax.TokenOBrack, Bytes: []byte("[")}}
+	for i, e := range list {
+		switch {
+		case multiline:
+			tokens = append(tokens, newline)
+		case i > 0:
+			tokens = append(tokens, comma)
+		}
+		tokens = append(tokens, valueTokens(e)...)
+		if multiline {
+			tokens = append(tokens, comma)
+		}
+	}
+	if multiline {
+		tokens = append(tokens, newline)
+	}
+	return append(tokens, &hclwrite.Token{Type: hclsyntax.TokenCBrack, Bytes: []byte("]")})
+}
+
+// interpolation returns the expression of a string made up of a single
+// "${...}" interpolation
+func interpolation(s string) (string, bool) {
+	if !strings.HasPrefix(s, "${") || !strings.HasSuffix(s, "}") {
+		return "", false
+	}
+	expr := s[2 : len(s)-1]
+	if strings.Contains(expr, "${") || strings.Contains(expr, "}") {
+		return "", false
+	}
+	return expr, true
+}
+
+// quotedTokens returns a quoted string literal, leaving any template
+// interpolations in place
+func quotedTokens(s string) hclwrite.Tokens {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return hclwrite.Tokens{
+		{Type: hclsyntax.TokenOQuote, Bytes: []byte(`"`)},
+		{Type: hclsyntax.TokenQuotedLit, Bytes: []byte(s)},
+		{Type: hclsyntax.TokenCQuote, Bytes: []byte(`"`)},
+	}
+}
+
+// rawTokens lexes an HCL expression into tokens
+func rawTokens(src string) hclwrite.Tokens {
+	lexed, _ := hclsyntax.LexExpression([]byte(src), "", hcl.Pos{Line: 1, Column: 1})
+	tokens := make(hclwrite.Tokens, 0, len(lexed))
+	for _, t := range lexed {
+		if t.Type == hclsyntax.TokenEOF {
+			break
+		}
+		tokens = append(tokens, &hclwrite.Token{Type: t.Type, Bytes: t.Bytes})
+	}
+	return tokens
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
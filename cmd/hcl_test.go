@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// configSchema matches the top level blocks generated by generateTerraform
+func configSchema() *hcl.BodySchema {
+	return &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "variable", LabelNames: []string{"name"}},
+			{Type: "data", LabelNames: []string{"type", "name"}},
+			{Type: "module", LabelNames: []string{"name"}},
+			{Type: "resource", LabelNames: []string{"type", "name"}},
+		},
+	}
+}
+
+// refContext builds an evaluation context in which every reference evaluates
+// to its own traversal as a string, so references can be compared by value
+func refContext(traversals []hcl.Traversal) *hcl.EvalContext {
+	tree := map[string]interface{}{}
+	for _, tr := range traversals {
+		node := tree
+		var path []string
+		for _, step := range tr {
+			var name string
+			switch s := step.(type) {
+			case hcl.TraverseRoot:
+				name = s.Name
+			case hcl.TraverseAttr:
+				name = s.Name
+			case hcl.TraverseIndex:
+				name = s.Key.AsString()
+			}
+			path = append(path, name)
+			if len(path) == len(tr) {
+				node[name] = strings.Join(path, ".")
+				break
+			}
+			next, ok := node[name].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				node[name] = next
+			}
+			node = next
+		}
+	}
+
+	var toValue func(interface{}) cty.Value
+	toValue = func(v interface{}) cty.Value {
+		if s, ok := v.(string); ok {
+			return cty.StringVal(s)
+		}
+		attrs := map[string]cty.Value{}
+		for k, e := range v.(map[string]interface{}) {
+			attrs[k] = toValue(e)
+		}
+		return cty.ObjectVal(attrs)
+	}
+
+	ctx := &hcl.EvalContext{Variables: map[string]cty.Value{}}
+	for k, v := range tree {
+		ctx.Variables[k] = toValue(v)
+	}
+	return ctx
+}
+
+// blockAttributes returns the attributes of every block keyed by the block
+// type and labels
+func blockAttributes(t *testing.T, f *hcl.File) map[string]hcl.Attributes {
+	content, diags := f.Body.Content(configSchema())
+	if diags.HasErrors() {
+		t.Fatalf("unable to decode configuration: %v", diags)
+	}
+	blocks := map[string]hcl.Attributes{}
+	for _, b := range content.Blocks {
+		attrs, diags := b.Body.JustAttributes()
+		if diags.HasErrors() {
+			t.Fatalf("unable to decode %s %v: %v", b.Type, b.Labels, diags)
+		}
+		blocks[b.Type+"."+strings.Join(b.Labels, ".")] = attrs
+	}
+	return blocks
+}
+
+// TestHCLEquivalence parses the JSON and HCL renderings of the same
+// configuration and checks every block and attribute evaluates identically
+// nolint: funlen
+func TestHCLEquivalence(t *testing.T) {
+	var r req
+	r.inFile = filepath.Join("testdata", "test.json")
+	err := r.parseRITM()
+	if err != nil {
+		t.Fatalf("tf.hcl() failed. Unable to parse test data: %v", err)
+	}
+	r.ritm.ProdCount = "2"
+
+	tf, err := r.ritm.generateTerraform(testCatalog(t), &ciStore{})
+	if err != nil {
+		t.Fatalf("tf.hcl() failed. Unable to generate terraform: %v", err)
+	}
+	jsonSrc, err := json.Marshal(tf)
+	if err != nil {
+		t.Fatalf("tf.hcl() failed. Unable to marshal JSON: %v", err)
+	}
+	hclSrc, err := tf.hcl()
+	if err != nil {
+		t.Fatalf("tf.hcl() failed: unexpected error: %v", err)
+	}
+
+	p := hclparse.NewParser()
+	jsonFile, diags := p.ParseJSON(jsonSrc, "rds.tf.json")
+	if diags.HasErrors() {
+		t.Fatalf("tf.hcl() failed. Unable to parse JSON: %v", diags)
+	}
+	hclFile, diags := p.ParseHCL(hclSrc, "rds.tf")
+	if diags.HasErrors() {
+		t.Fatalf("tf.hcl() failed. Unable to parse HCL: %v\n%s", diags, hclSrc)
+	}
+
+	jsonBlocks := blockAttributes(t, jsonFile)
+	hclBlocks := blockAttributes(t, hclFile)
+	if len(jsonBlocks) != len(hclBlocks) {
+		t.Fatalf("tf.hcl() failed. Expected %d blocks, got: %d", len(jsonBlocks), len(hclBlocks))
+	}
+
+	for key, jsonAttrs := range jsonBlocks {
+		hclAttrs, ok := hclBlocks[key]
+		if !ok {
+			t.Errorf("tf.hcl() failed. Missing block: %s", key)
+			continue
+		}
+		if len(jsonAttrs) != len(hclAttrs) {
+			t.Errorf("tf.hcl() failed. %s expected %d attributes, got: %d", key, len(jsonAttrs), len(hclAttrs))
+		}
+		for name, ja := range jsonAttrs {
+			ha, ok := hclAttrs[name]
+			if !ok {
+				t.Errorf("tf.hcl() failed. %s missing attribute: %s", key, name)
+				continue
+			}
+
+			if strings.HasPrefix(key, "variable.") && name == "type" {
+				jv, _ := ja.Expr.Value(nil)
+				src := string(ha.Expr.Range().SliceBytes(hclSrc))
+				if jv.AsString() != src {
+					t.Errorf("tf.hcl() failed. %s.type expected: %s got: %s", key, jv.AsString(), src)
+				}
+				continue
+			}
+
+			jv, diags := ja.Expr.Value(refContext(ja.Expr.Variables()))
+			if diags.HasErrors() {
+				t.Fatalf("tf.hcl() failed. Unable to evaluate JSON %s.%s: %v", key, name, diags)
+			}
+			hv, diags := ha.Expr.Value(refContext(ha.Expr.Variables()))
+			if diags.HasErrors() {
+				t.Fatalf("tf.hcl() failed. Unable to evaluate HCL %s.%s: %v", key, name, diags)
+			}
+			if !jv.RawEquals(hv) {
+				t.Errorf("tf.hcl() failed. %s.%s expected: %#v got: %#v", key, name, jv, hv)
+			}
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	tt := map[string]struct {
+		expr string
+		ok   bool
+	}{
+		"${var.test_db_password}":       {expr: "var.test_db_password", ok: true},
+		"alias/test":                    {},
+		"${var.a}-${var.b}":             {},
+		"prefix-${var.test}":            {},
+		"${aws_security_group.test.id}": {expr: "aws_security_group.test.id", ok: true},
+	}
+	for s, tc := range tt {
+		expr, ok := interpolation(s)
+		if expr != tc.expr || ok != tc.ok {
+			t.Errorf("interpolation(%q) failed: expected: %q %v got: %q %v", s, tc.expr, tc.ok, expr, ok)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// localTerraform runs terraform apply in a clone of the repository instead of
+// a CI service. The terraform binary and the credentials it needs must be
+// available where the pipeline runs.
+type localTerraform struct {
+	host    codeHost
+	repo    string
+	job     string           // name of the apply job
+	applied map[string]ciJob // finished apply jobs by commit, so each commit is applied once
+}
+
+// trigger does nothing, the commit is applied when its job is waited for
+func (l *localTerraform) trigger(ctx context.Context, branch string) error {
+	return nil
+}
+
+// findJobs returns the apply job for the commit
+func (l *localTerraform) findJobs(ctx context.Context, branch, sha string, since time.Time) ([]ciJob, error) {
+	if job, ok := l.applied[sha]; ok {
+		return []ciJob{job}, nil
+	}
+	return []ciJob{{id: sha, name: l.job, status: "pending"}}, nil
+}
+
+// waitForJob clones the repository at the job's commit and runs terraform
+// init and apply in its terraform directory
+func (l *localTerraform) waitForJob(ctx context.Context, job ciJob, timeout time.Duration) (ciJob, error) {
+	if job.finished {
+		return job, nil
+	}
+	wait := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		wait, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	dir, err := ioutil.TempDir("", l.repo+"-apply")
+	if err != nil {
+		return job, err
+	}
+	defer os.RemoveAll(dir)
+
+	fmt.Printf("Cloning repository: %s at %s to: %s\n", l.repo, job.id, dir)
+	repo, err := git.PlainCloneContext(wait, dir, false, &git.CloneOptions{
+		Auth:     l.host.auth(),
+		URL:      l.host.cloneURL(l.repo),
+		Progress: os.Stdout,
+	})
+	if err != nil {
+		return job, err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return job, err
+	}
+	err = w.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(job.id)})
+	if err != nil {
+		return job, err
+	}
+
+	job.finished, job.status = true, "success"
+	for _, args := range [][]string{{"init", "-input=false"}, {"apply", "-input=false", "-auto-approve"}} {
+		cmd := exec.CommandContext(wait, "terraform", args...) // #nosec G204
+		cmd.Dir = filepath.Join(dir, tfConst)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		err = cmd.Run()
+		if ctx.Err() != nil {
+			return job, ctx.Err()
+		}
+		if wait.Err() != nil {
+			return job, fmt.Errorf("job timeout exceeded while waiting for job %s [%s] to finish", job.name, job.id)
+		}
+		if err != nil {
+			job.failed, job.status = true, fmt.Sprintf("failed: terraform %s: %v", args[0], err)
+			break
+		}
+	}
+	l.applied[job.id] = job
+	return job, nil
+}
+
+func (l *localTerraform) setSecret(ctx context.Context, name, value string) error {
+	return fmt.Errorf("the %s CI runner cannot store secrets", localRunner)
+}
+
+func (l *localTerraform) deleteSecret(ctx context.Context, name string) error {
+	return fmt.Errorf("the %s CI runner cannot store secrets", localRunner)
+}
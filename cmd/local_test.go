@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// localHost clones repositories from a local directory
+type localHost struct {
+	codeHost
+	dir string
+}
+
+func (h *localHost) cloneURL(repo string) string { return filepath.Join(h.dir, repo) }
+
+func (h *localHost) auth() transport.AuthMethod { return nil }
+
+// initRepo creates a repository with a terraform directory and returns the
+// commit hash
+func initRepo(t *testing.T, dir string) string {
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("unable to create repository: %v", err)
+	}
+	err = os.MkdirAll(filepath.Join(dir, tfConst), 0750)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, tfConst, "main.tf"), []byte("# test\n"), 0600)
+	}
+	if err != nil {
+		t.Fatalf("unable to write terraform file: %v", err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("unable to open worktree: %v", err)
+	}
+	_, err = w.Add(tfConst)
+	if err != nil {
+		t.Fatalf("unable to add terraform directory: %v", err)
+	}
+	hash, err := w.Commit("test", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatalf("unable to commit: %v", err)
+	}
+	return hash.String()
+}
+
+// nolint: funlen
+func TestLocalTerraform(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sha := initRepo(t, filepath.Join(dir, "test-repo"))
+
+	// terraform is a script recording its arguments, failing apply when FAIL_APPLY is set
+	bin := filepath.Join(dir, "bin")
+	log := filepath.Join(dir, "terraform.log")
+	script := "#!/bin/sh\nls main.tf > /dev/null || exit 1\necho \"$@\" >> " + log +
+		"\nif [ \"$1\" = apply ] && [ -n \"$FAIL_APPLY\" ]; then exit 1; fi\n"
+	err = os.MkdirAll(bin, 0750)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(bin, "terraform"), []byte(script), 0700) // #nosec G306
+	}
+	if err != nil {
+		t.Fatalf("unable to write terraform script: %v", err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	c := defaultConfig()
+	c.CIRunner = localRunner
+	c.setDefaults()
+	ci, err := newCIRunner(c, &localHost{dir: dir}, "test-repo")
+	if err != nil {
+		t.Fatalf("newCIRunner() failed: unexpected error: %v", err)
+	}
+	r := &req{ci: ci, config: c, repoName: "test-repo"}
+
+	start, err := r.triggerApply(c.BaseBranch, sha)
+	if err != nil {
+		t.Fatalf("triggerApply() failed: unexpected error: %v", err)
+	}
+	err = r.waitForApply(c.BaseBranch, sha, start)
+	if err != nil {
+		t.Fatalf("waitForApply() failed: unexpected error: %v", err)
+	}
+	b, _ := ioutil.ReadFile(log) // #nosec G304
+	expected := "init -input=false\napply -input=false -auto-approve\n"
+	if string(b) != expected {
+		t.Errorf("waitForApply() failed: expected terraform commands:\n%s\nGot:\n%s", expected, b)
+	}
+
+	// the commit has been applied, so it is not applied again
+	err = r.waitForApply(c.BaseBranch, sha, start)
+	if err != nil {
+		t.Errorf("waitForApply() failed: unexpected error: %v", err)
+	}
+	b, _ = ioutil.ReadFile(log) // #nosec G304
+	if string(b) != expected {
+		t.Errorf("waitForApply() failed: commit applied again:\n%s", b)
+	}
+
+	os.Setenv("FAIL_APPLY", "1")
+	defer os.Unsetenv("FAIL_APPLY")
+	ci.(*localTerraform).applied = map[string]ciJob{}
+	err = r.waitForApply(c.BaseBranch, sha, start)
+	if err == nil || !strings.HasPrefix(err.Error(), "apply_terraform failed: terraform apply:") {
+		t.Errorf("waitForApply() failed: expected apply error, got: %v", err)
+	}
+
+	err = ci.setSecret(context.Background(), "TF_VAR_test", "secret")
+	if err == nil || err.Error() != "the local CI runner cannot store secrets" {
+		t.Errorf("setSecret() failed: expected error, got: %v", err)
+	}
+}
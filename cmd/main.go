@@ -15,6 +15,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -22,13 +23,13 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"syscall"
 
-	"github.com/andrewstuart/servicenow"
 	git "github.com/go-git/go-git/v5"
-	"github.com/google/go-github/v28/github"
-	"github.com/jszwedko/go-circleci"
 )
 
 const (
@@ -40,84 +41,184 @@ const (
 	maintenanceDay   = "Thu" // Thursday...assuming we aren't crossing a day boundary
 	yes              = "Yes" // ServiceNow uses "Yes"/"No" instead of booleans
 	tfConst          = "terraform"
+	hclConst         = "hcl"
 )
 
 // req is a provisioning request object
 type req struct {
-	circleClient *circleci.Client
-	email        string
-	fullPath     string
-	format       string // json or terraform
-	githubClient *github.Client
-	githubURL    string
-	inFile       string
-	ritm         *ritm
-	relPath      string
-	repo         *git.Repository
-	repoName     string
-	snowClient   *servicenow.Client
-	tempDir      string
-	reqMap       map[string]interface{}
+	catalog     *catalog
+	catalogFile string
+	ci          ciRunner
+	config      *config
+	configOptions
+	ctx        context.Context // cancelled to stop waiting, the background context if nil
+	dryRun     bool
+	endpoints  endpointFinder // of the applied instances, nil if they are not looked up
+	fullPath   string
+	format     string // json, terraform or hcl
+	host       codeHost
+	inFile     string
+	merges     *mergeEvents // webhook events for the pull requests, nil without a webhook
+	ritm       *ritm
+	ritmNumber string
+	secretOptions
+	secrets secretStore
+	timeoutOptions
+	branch     string // branch the changes are pushed to
+	pr         *pullRequest
+	relPath    string
+	repo       *git.Repository
+	repoName   string
+	resume     bool
+	retry      retryPolicy // of the calls that are not idempotent
+	snowClient *snowClient
+	state      *pipelineState
+	stateDir   string
+	sysID      string
+	tempDir    string
+	reqMap     map[string]interface{}
 }
 
 // ritm type for the parsed ServiceNow RITM results JSON
 type ritm struct {
-	Account         string `json:"account"`              // "grace-paas-developent",
-	CatalogItemName string `json:"cat_item_name"`        // "GRACE-PaaS AWS RDS Provisioning Request",
-	Comments        string `json:"comments"`             // "",
-	Engine          string `json:"engine"`               // "mysql8.0",
-	Identifier      string `json:"identifier"`           // "test-rds",
-	DevCount        string `json:"development_count"`    // 1,
-	DevMultiAZ      string `json:"development_multi_az"` // false,
-	DevSize         string `json:"development_size"`     // "small",
-	ProdCount       string `json:"production_count"`     // 1,
-	ProdMultiAZ     string `json:"production_multi_az"`  // false,
-	ProdSize        string `json:"production_size"`      // "small",
-	TestCount       string `json:"test_count"`           // 1,
-	TestMultiAZ     string `json:"test_multi_az"`        // false,
-	TestSize        string `json:"test_size"`            // "small",
-	Name            string `json:"name"`                 // "TestDB",
-	Number          string `json:"number"`               // "RITM0001001",
-	OpenedBy        string `json:"opened_by"`            // "by@email.com",
-	Password        string `json:"password"`             // not actually in RITM, but randomly generated
-	RequestedFor    string `json:"requested_for"`        // "for@email.com",
-	Supervisor      string `json:"supervisor"`           // "supervisor@email.com",
-	SysID           string `json:"sys_id"`               // "99aa00000aa9aa00a9a99999a99aaa99",
-	Username        string `json:"username"`             // "TestUser"
+	Account            string `json:"account"`               // "grace-paas-developent",
+	CatalogItemName    string `json:"cat_item_name"`         // "GRACE-PaaS AWS RDS Provisioning Request",
+	Comments           string `json:"comments"`              // "",
+	Engine             string `json:"engine"`                // "mysql8.0", the target family of upgrade requests
+	Identifier         string `json:"identifier"`            // "test-rds",
+	DevCount           string `json:"development_count"`     // 1,
+	DevMultiAZ         string `json:"development_multi_az"`  // false,
+	DevSize            string `json:"development_size"`      // "small",
+	ProdCount          string `json:"production_count"`      // 1,
+	ProdMultiAZ        string `json:"production_multi_az"`   // false,
+	ProdSize           string `json:"production_size"`       // "small",
+	TestCount          string `json:"test_count"`            // 1,
+	TestMultiAZ        string `json:"test_multi_az"`         // false,
+	TestSize           string `json:"test_size"`             // "small",
+	Name               string `json:"name"`                  // "TestDB",
+	Number             string `json:"number"`                // "RITM0001001",
+	OpenedBy           string `json:"opened_by"`             // "by@email.com",
+	ParameterOverrides string `json:"parameter_overrides"`   // "max_connections=500", one per line
+	Password           string `json:"password"`              // not actually in RITM, but randomly generated
+	Provisioning       string `json:"provisioning_ritm"`     // "RITM0001001", decommission requests only
+	Size               string `json:"size"`                  // "medium", modification requests only
+	Storage            string `json:"allocated_storage"`     // "200", modification requests only
+	MultiAZ            string `json:"multi_az"`              // "Yes", modification requests only
+	MinorUpgrade       string `json:"minor_version_upgrade"` // "Yes", modification requests only
+	RequestedFor       string `json:"requested_for"`         // "for@email.com",
+	Supervisor         string `json:"supervisor"`            // "supervisor@email.com",
+	SysID              string `json:"sys_id"`                // "99aa00000aa9aa00a9a99999a99aaa99",
+	Username           string `json:"username"`              // "TestUser"
 }
 
-func newReq() (*req, error) {
-	var r req
+func newReq(ctx context.Context) (*req, error) {
+	r := req{ctx: ctx}
 	flags, output, err := r.parseFlags(os.Args[0], os.Args[1:])
 	if err != nil {
 		fmt.Println(output)
 		return &r, err
 	}
 
+	r.config, err = r.loadConfig(r.repoName)
+	if err != nil {
+		return &r, err
+	}
+
 	err = r.check()
 	if err != nil {
 		flags.PrintDefaults()
 		return &r, err
 	}
 
-	err = r.parseRITM()
+	r.catalog, err = loadCatalog(r.catalogFile)
 	if err != nil {
 		return &r, err
 	}
 
-	if r.format == tfConst {
-		r.email = "grace-staff@gsa.gov"
-		r.githubURL = "https://github.com/GSA/"
-		r.circleClient = newCircleClient(os.Getenv("CIRCLE_TOKEN"))
-		r.githubClient = newAuthenticatedClient()
-		r.snowClient = newSnowClient()
+	return &r, r.init()
+}
+
+// init reads the RITM and creates the clients needed for its format
+func (r *req) init() error {
+	if r.inFile == "" {
+		r.snowClient = newSnowClient(newRetryPolicy(r.config.MaxAttempts))
+	}
+
+	err := r.parseRITM()
+	if err != nil {
+		return err
+	}
+
+	fileName := "rds_" + r.ritm.Number + ".tf.json"
+	if r.format == hclConst {
+		fileName = "rds_" + r.ritm.Number + ".tf"
+	}
+
+	switch {
+	case r.pipeline() && r.dryRun:
+		// No clients are created so nothing can be contacted
+		if r.relPath == "" {
+			r.relPath = fileName
+		}
+		r.fullPath = r.relPath
+	case r.pipeline():
+		// Checked before the clients are created so a conflicting run is
+		// not reported to the RITM. An unreadable state file is reported, so
+		// the RITM is reopened rather than failing on every poll.
+		r.state, err = loadState(r.stateDir, r.ritm.Number)
+		if err != nil {
+			return err
+		}
+		err = r.checkState()
+		if err != nil {
+			r.snowClient = nil // Created early if the RITM was fetched
+			return err
+		}
 
-		r.relPath = filepath.Join(tfConst, "rds_"+r.ritm.Number+".tf.json")
-		r.tempDir = filepath.Join(os.TempDir(), r.repoName)
+		err = r.newClients()
+		if err != nil {
+			return err
+		}
+		r.branch = r.ritm.Number
+		r.relPath = filepath.Join(tfConst, fileName)
+		r.tempDir = filepath.Join(os.TempDir(), r.repoName+"-"+r.ritm.Number)
 		r.fullPath = filepath.Join(r.tempDir, r.relPath)
 	}
 
-	return &r, nil
+	if r.pipeline() {
+		r.secrets, err = r.newSecretStore()
+		if err != nil {
+			return err
+		}
+	}
+	if r.pipeline() && !r.dryRun {
+		r.endpoints, err = newRDSEndpoints()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Validated after the clients are created so errors are posted to the RITM
+	err = r.ritm.validate(r.catalog)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// newClients creates the code host, CI runner and ServiceNow clients
+func (r *req) newClients() error {
+	r.retry = newRetryPolicy(r.config.MaxAttempts)
+	r.snowClient = newSnowClient(r.retry)
+
+	var err error
+	r.host, err = newCodeHost(r.config)
+	if err != nil {
+		return err
+	}
+	r.ci, err = newCIRunner(r.config, r.host, r.repoName)
+	return err
 }
 
 func (r *req) parseFlags(progName string, args []string) (*flag.FlagSet, string, error) {
@@ -125,9 +226,20 @@ func (r *req) parseFlags(progName string, args []string) (*flag.FlagSet, string,
 	var buf bytes.Buffer
 	flags.SetOutput(&buf)
 	flags.StringVar(&r.inFile, "request", "", "JSON input file")
+	flags.StringVar(&r.ritmNumber, "ritm", "", "RITM number to fetch from ServiceNow instead of a request file")
+	flags.StringVar(&r.sysID, "sys-id", "", "sys_id of the RITM to fetch from ServiceNow instead of a request file")
 	flags.StringVar(&r.relPath, "outfile", "", "JSON output file")
 	flags.StringVar(&r.repoName, "repo", "", "Repo name")
-	flags.StringVar(&r.format, "format", "json", "Output file format: json or terraform")
+	flags.StringVar(&r.format, "format", "json", "Output file format: json, terraform or hcl")
+	flags.StringVar(&r.catalogFile, "catalog", "", "Engine catalog file (YAML or JSON), defaults to the built-in catalog")
+	flags.BoolVar(&r.resume, "resume", false, "Resume a failed terraform pipeline, skipping completed stages")
+	flags.StringVar(&r.stateDir, "state-dir", filepath.Join(os.TempDir(), "grace-paas-rds"),
+		"Directory for the terraform pipeline state files")
+	flags.BoolVar(&r.dryRun, "dry-run", false,
+		"Generate terraform to outfile and print the pipeline changes without contacting GitHub, CircleCI or ServiceNow")
+	r.secretOptions.addFlags(flags)
+	r.configOptions.addFlags(flags)
+	r.timeoutOptions.addFlags(flags)
 	err := flags.Parse(args)
 	if err != nil {
 		return flags, buf.String(), err
@@ -135,102 +247,99 @@ func (r *req) parseFlags(progName string, args []string) (*flag.FlagSet, string,
 	return flags, buf.String(), nil
 }
 
-func (r *req) checkErr(err error) {
-	if err != nil {
-		fmt.Println(err)
-		if r.ritm != nil {
-			err := r.updateRITM(err)
-			if err != nil {
-				fmt.Println(err)
-			}
+// context returns the context that stops the waits when it is cancelled
+func (r *req) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// reportErr posts the error to the RITM, if it has been read. Interruptions
+// are only commented on, so the RITM is not reopened.
+func (r *req) reportErr(err error) {
+	if r.ritm != nil && r.snowClient != nil && !r.commentInterrupted(err, "Provisioning") {
+		err := r.updateRITM(context.Background(), err)
+		if err != nil {
+			fmt.Printf("Unable to update RITM: %v\n", err)
 		}
-		os.Exit(1)
 	}
 }
 
-func handleRITM(opt ...*req) {
+// handleRITM processes the request given, or the one from the command line
+// arguments, until ctx is cancelled. Errors are posted to the RITM once, here.
+func handleRITM(ctx context.Context, opt ...*req) (err error) {
 	var r *req
-	var err error
 	if len(opt) > 0 {
 		r = opt[0]
+		if r.ctx == nil {
+			r.ctx = ctx
+		}
 	} else {
-		r, err = newReq()
-		r.checkErr(err)
+		r, err = newReq(ctx)
+	}
+	defer func() {
+		if err != nil {
+			r.reportErr(err)
+		}
+	}()
+	if err != nil {
+		return err
 	}
 
 	switch format := r.format; format {
-	case tfConst:
-		r.handleTerraform()
+	case tfConst, hclConst:
+		if r.dryRun {
+			return r.handleDryRun()
+		}
+		return r.handleTerraform()
 	default:
-		r.handleJSON()
+		return r.handleJSON()
 	}
 }
 
-func (r *req) handleTerraform() {
-	repo, err := r.cloneRepo()
-	r.checkErr(err)
-
-	r.repo = repo
-	tf := r.ritm.generateTerraform()
-
-	err = r.newBranch()
-	r.checkErr(err)
-
-	err = tf.writeFile(r.fullPath)
-	r.checkErr(err)
-
-	_, err = r.addPassword()
-	r.checkErr(err)
-
-	err = r.commit()
-	r.checkErr(err)
-
-	err = os.RemoveAll(r.tempDir) // Remove the cloned repo after pushing
-	r.checkErr(err)
-
-	pr, err := r.pullRequest()
-	r.checkErr(err)
-
-	err = waitForMerge(pr)
-	r.checkErr(err)
-
-	err = waitForApply(pr)
-	r.checkErr(err)
-
-	err = r.updateRITM(nil)
-	r.checkErr(err)
-
-	fmt.Println("Processing complete")
+// writeTerraform writes the configuration to fullPath in the requested format
+func (r *req) writeTerraform(tf terraform) error {
+	if r.format == hclConst {
+		return tf.writeHCLFile(r.fullPath)
+	}
+	return tf.writeFile(r.fullPath)
 }
 
-func (r *req) handleJSON() {
-	var tf terraform
-	engines := tf.rdsEngineDefaults()
-	family := r.ritm.Engine
-	options := engines[family].(map[string]interface{})
-	engine := options["engine"]
+func (r *req) handleJSON() error {
+	spec, err := r.catalog.engine(r.ritm.Engine)
+	if err != nil {
+		return err
+	}
 	backupStartTime := randStart() // Number of minutes after start of backupwindow start hour
 
 	// Complete request for grace-actions
 	r.reqMap["action"] = "rds"
-	r.reqMap["engine"] = engine
-	r.reqMap["engine_major_version"] = options["major_engine_version"]
-	r.reqMap["engine_version"] = options["engine_version"]
-	r.reqMap["port"] = options["port"]
-	r.reqMap["enabled_cloudwatch_logs_exports"] = strings.Join(options["enabled_cloudwatch_logs_exports"].([]string), ",")
+	r.reqMap["engine"] = spec.Engine
+	r.reqMap["engine_major_version"] = spec.MajorEngineVersion
+	r.reqMap["engine_version"] = spec.EngineVersion
+	r.reqMap["port"] = spec.Port
+	r.reqMap["enabled_cloudwatch_logs_exports"] = strings.Join(spec.EnabledCloudwatchLogsExports, ",")
 	r.reqMap["backup_window"] = backupWindow(backupStartTime)
 	r.reqMap["maintenance_window"] = maintenanceWindow(backupStartTime)
-	r.reqMap["development_instance_class"] = options[r.ritm.DevSize].(map[string]interface{})["instance_class"]
-	r.reqMap["test_instance_class"] = options[r.ritm.TestSize].(map[string]interface{})["instance_class"]
-	r.reqMap["production_instance_class"] = options[r.ritm.ProdSize].(map[string]interface{})["instance_class"]
-	r.reqMap["development_allocated_storage"] = options[r.ritm.DevSize].(map[string]interface{})["allocated_storage"]
-	r.reqMap["test_allocated_storage"] = options[r.ritm.TestSize].(map[string]interface{})["allocated_storage"]
-	r.reqMap["production_allocated_storage"] = options[r.ritm.ProdSize].(map[string]interface{})["allocated_storage"]
+	// Environments with a count of zero have no size, so their keys are left out
+	for _, env := range r.ritm.environments() {
+		size, err := spec.size(env.size)
+		if err != nil {
+			return err
+		}
+		r.reqMap[env.name+"_instance_class"] = size.InstanceClass
+		r.reqMap[env.name+"_allocated_storage"] = size.AllocatedStorage
+		r.reqMap[env.name+"_replica_count"] = env.count - 1
+	}
 
-	err := r.writeFile()
-	r.checkErr(err)
+	err = r.writeFile()
+	if err != nil {
+		return fmt.Errorf("writing %s failed: %w", r.relPath, err)
+	}
 
 	fmt.Println("Processing complete")
+	return nil
 }
 
 func (r *req) writeFile() error {
@@ -245,14 +354,7 @@ func (r *req) writeFile() error {
 }
 
 func (r *req) parseRITM() error {
-	fmt.Printf("Parsing RITM from: %s\n", r.inFile)
-	jsonFile, err := os.Open(r.inFile) // #nosec G304
-	if err != nil {
-		return err
-	}
-
-	defer jsonFile.Close() // #nosec G307
-	byteValue, err := ioutil.ReadAll(jsonFile)
+	byteValue, err := r.readRITM()
 	if err != nil {
 		return err
 	}
@@ -274,6 +376,22 @@ func (r *req) parseRITM() error {
 	return nil
 }
 
+// readRITM returns the RITM JSON from the request file or from ServiceNow
+func (r *req) readRITM() ([]byte, error) {
+	if r.inFile == "" {
+		return r.fetchRITM()
+	}
+
+	fmt.Printf("Parsing RITM from: %s\n", r.inFile)
+	jsonFile, err := os.Open(r.inFile) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+
+	defer jsonFile.Close() // #nosec G307
+	return ioutil.ReadAll(jsonFile)
+}
+
 func randStart() int {
 	min := backupStartHour * 60
 	max := int(math.Abs(float64(backupEndHour-backupStartHour)))*60 - backupWindowSize
@@ -293,8 +411,9 @@ func maintenanceWindow(m int) string {
 }
 
 func (r *req) check() error {
-	if r.inFile == "" {
-		return fmt.Errorf("request must be set")
+	err := r.checkInput()
+	if err != nil {
+		return err
 	}
 
 	if r.format == "json" {
@@ -304,16 +423,32 @@ func (r *req) check() error {
 		return nil
 	}
 
+	if !r.pipeline() {
+		return fmt.Errorf("format must be json, terraform or hcl")
+	}
+
 	if r.repoName == "" {
 		return fmt.Errorf("reponame must be set if format is 'terraform'")
 	}
 
-	if os.Getenv("GITHUB_TOKEN") == "" {
-		return fmt.Errorf("environment variable GITHUB_TOKEN must be set if format is 'terraform'")
+	err = r.timeoutOptions.check()
+	if err != nil {
+		return err
+	}
+
+	err = r.secretOptions.check(r.dryRun)
+	if err != nil {
+		return err
+	}
+
+	if r.dryRun {
+		return nil // Credentials are not needed for a dry run
 	}
 
-	if os.Getenv("CIRCLE_TOKEN") == "" {
-		return fmt.Errorf("environment variable CIRCLE_TOKEN must be set if format is 'terraform'")
+	for _, name := range r.config.tokenEnvs() {
+		if os.Getenv(name) == "" {
+			return fmt.Errorf("environment variable %s must be set if format is 'terraform'", name)
+		}
 	}
 
 	if os.Getenv("SN_INSTANCE") == "" {
@@ -330,6 +465,72 @@ func (r *req) check() error {
 	return nil
 }
 
+// checkInput checks that exactly one RITM source is given
+func (r *req) checkInput() error {
+	var n int
+	for _, v := range []string{r.inFile, r.ritmNumber, r.sysID} {
+		if v != "" {
+			n++
+		}
+	}
+
+	switch {
+	case n == 0:
+		return fmt.Errorf("request, ritm or sys-id must be set")
+	case n > 1:
+		return fmt.Errorf("only one of request, ritm or sys-id may be set")
+	case r.inFile != "":
+		return nil
+	case r.dryRun:
+		return fmt.Errorf("dry-run cannot fetch the RITM from ServiceNow, use request instead")
+	case r.ritmNumber != "" && !regexp.MustCompile(`^RITM[0-9]+$`).MatchString(r.ritmNumber):
+		return fmt.Errorf("ritm must be a RITM number, got %q", r.ritmNumber)
+	case r.sysID != "" && !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(r.sysID):
+		return fmt.Errorf("sys-id must be a 32 character hexadecimal sys_id, got %q", r.sysID)
+	}
+
+	for _, name := range []string{"SN_INSTANCE", "SN_PASSWORD", "SN_USER"} {
+		if os.Getenv(name) == "" {
+			return fmt.Errorf("environment variable %s must be set to fetch the RITM from ServiceNow", name)
+		}
+	}
+	return nil
+}
+
+// pipeline returns true if the request format runs the GitHub, CircleCI and
+// ServiceNow pipeline
+func (r *req) pipeline() bool {
+	return r.format == tfConst || r.format == hclConst
+}
+
 func main() {
-	handleRITM()
+	var err error
+	var cmd string
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+	}
+
+	// Cancelled on the first signal, so the running stage stops and the RITM
+	// is told where the pipeline was interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	switch cmd {
+	case "serve", "watch":
+		stop() // The server handles the signals itself
+		err = serve(os.Args[0]+" "+cmd, os.Args[2:])
+	case "rotate-password":
+		err = rotatePassword(ctx, os.Args[0]+" "+cmd, os.Args[2:])
+	case "decommission":
+		err = decommissionRITM(ctx, os.Args[0]+" "+cmd, os.Args[2:])
+	case "modify":
+		err = modifyRITM(ctx, os.Args[0]+" "+cmd, os.Args[2:])
+	case "upgrade":
+		err = upgradeRITM(ctx, os.Args[0]+" "+cmd, os.Args[2:])
+	default:
+		err = handleRITM(ctx)
+	}
+	stop()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
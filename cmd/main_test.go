@@ -1,11 +1,13 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"io/ioutil"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -47,14 +49,34 @@ func TestNewReq(t *testing.T) {
 				"SN_USER":      "test",
 			},
 			req: &req{
-				email: "grace-staff@gsa.gov",
+				config: defaultConfig(),
 			},
 		},
 		"no arguments": {
 			args: []string{"cmd2"},
-			err:  "request must be set",
+			err:  "request, ritm or sys-id must be set",
 			req:  &req{},
 		},
+		"request and ritm": {
+			args: []string{"cmd11", "-request", "test", "-ritm", "RITM0001234"},
+			err:  "only one of request, ritm or sys-id may be set",
+			req:  &req{},
+		},
+		"invalid ritm number": {
+			args: []string{"cmd12", "-ritm", "1234", "-outfile", "test"},
+			err:  "ritm must be a RITM number, got \"1234\"",
+			req:  &req{},
+		},
+		"ritm without SN_USER": {
+			args: []string{"cmd13", "-ritm", "RITM0001234", "-outfile", "test"},
+			env: map[string]string{
+				"SN_INSTANCE": "test",
+				"SN_PASSWORD": "test",
+				"SN_USER":     "",
+			},
+			err: "environment variable SN_USER must be set to fetch the RITM from ServiceNow",
+			req: &req{},
+		},
 		"missing file": {
 			args: []string{"cmd3", "-request", "test", "-format", "terraform", "-repo", "test"},
 			env: map[string]string{
@@ -67,6 +89,17 @@ func TestNewReq(t *testing.T) {
 			err: "open test: no such file or directory",
 			req: &req{},
 		},
+		"invalid ritm": {
+			args: []string{"cmd9", "-request", filepath.Join("testdata", "invalid.json"), "-outfile", "test"},
+			err: "invalid RITM (3 errors): identifier: must be set; development_size: must be one of small, medium, large, " +
+				"got \"tiny\"; production_multi_az: must be \"Yes\" or \"No\", got \"Maybe\"",
+			req: &req{},
+		},
+		"unknown format": {
+			args: []string{"cmd10", "-request", "test", "-format", "yaml", "-repo", "test"},
+			err:  "format must be json, terraform or hcl",
+			req:  &req{},
+		},
 		"CIRCLE_TOKEN not set": {
 			args: []string{"cmd4", "-request", "test", "-format", "terraform", "-repo", "test"},
 			env: map[string]string{
@@ -132,36 +165,146 @@ func TestNewReq(t *testing.T) {
 		tc := tc
 		t.Run(name, func(t *testing.T) {
 			resetEnv(tc.args, tc.env)
-			req, err := newReq()
+			req, err := newReq(context.Background())
 			if tc.err == "" && err != nil {
 				t.Errorf("newReq() failed: unexpected error: %v", err)
 			} else if tc.err != "" && (err == nil || tc.err != err.Error()) {
 				t.Errorf("newReq() failed: expected error: %s\nGot: %v\n", tc.err, err)
 			}
-			if tc.req.email != req.email {
-				t.Errorf("newReq() failed: expected: %v\ngot: %v\n", tc.req.email, req.email)
+			if tc.req.config != nil && (req.config == nil || req.config.Email != tc.req.config.Email) {
+				t.Errorf("newReq() failed: expected: %v\ngot: %v\n", tc.req.config, req.config)
 			}
-			t.Logf("CircleCI Client: %v\n", req.circleClient)
+			t.Logf("CI runner: %v\n", req.ci)
 		})
 	}
 
 	resetEnv(oldArgs, oldEnv)
 }
 
-// Tests that it has a non zero exit condition when there is an error
-func TestCheckErr(t *testing.T) {
-	if os.Getenv("BE_CHECKERR") == "1" {
-		r := req{}
-		r.checkErr(fmt.Errorf("testing"))
-		return
+// Tests that a failure is returned and posted to the RITM exactly once
+func TestHandleRITMError(t *testing.T) {
+	ts := fakeSnow(t)
+	defer ts.Close()
+
+	r := &req{
+		format:     "json",
+		inFile:     filepath.Join("testdata", "test.json"),
+		relPath:    filepath.Join("testdata", "missing", "out.json"),
+		snowClient: ts.client(),
+	}
+	var err error
+	r.catalog, err = loadCatalog("")
+	if err != nil {
+		t.Fatalf("loadCatalog() failed: unexpected error: %v", err)
+	}
+	err = r.init()
+	if err != nil {
+		t.Fatalf("init() failed: unexpected error: %v", err)
+	}
+
+	err = handleRITM(context.Background(), r)
+	if err == nil || !strings.HasPrefix(err.Error(), "writing "+r.relPath+" failed: ") {
+		t.Fatalf("handleRITM() failed: expected write error, got: %v", err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("handleRITM() failed: expected wrapped os.ErrNotExist, got: %v", err)
+	}
+
+	if len(ts.updates) != 1 {
+		t.Fatalf("handleRITM() failed: expected 1 RITM update, got: %v", ts.updates)
+	}
+	u := ts.updates[0]
+	if u["sys_id"] != r.ritm.SysID || u["state"] != float64(8) || u["work_notes"] != "Error provisioning RDS: "+err.Error() {
+		t.Errorf("handleRITM() failed: unexpected RITM update: %v", u)
+	}
+}
+
+// Tests that a conflicting run is not posted to the RITM, while an unreadable
+// state file is
+func TestInitState(t *testing.T) {
+	ts := fakeSnow(t)
+	defer ts.Close()
+	dir := t.TempDir()
+	catalog, err := loadCatalog("")
+	if err != nil {
+		t.Fatalf("loadCatalog() failed: unexpected error: %v", err)
+	}
+
+	tt := map[string]struct {
+		state    string
+		err      string
+		reported bool
+	}{
+		"conflict": {
+			state: `{"number": "RITM0001001", "completed": {"clone": "2021-06-01T00:00:00Z"}}`,
+			err:   "a previous run for RITM0001001 stopped after the clone stage, use -resume to continue it",
+		},
+		"corrupt": {state: `{"number": `, err: "invalid state file", reported: true},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			err := ioutil.WriteFile(filepath.Join(dir, "RITM0001001.json"), []byte(tc.state), 0600)
+			if err != nil {
+				t.Fatalf("unable to write state file: %v", err)
+			}
+			ts.updates = nil
+			r := &req{
+				catalog:    catalog,
+				config:     defaultConfig(),
+				format:     tfConst,
+				inFile:     filepath.Join("testdata", "test.json"),
+				snowClient: ts.client(),
+				stateDir:   dir,
+			}
+			err = r.init()
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Fatalf("init() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+			r.reportErr(err)
+			if reported := len(ts.updates) == 1; reported != tc.reported {
+				t.Errorf("reportErr() failed: expected reported %t, got updates: %v", tc.reported, ts.updates)
+			}
+		})
+	}
+}
+
+// Tests that only the sizes of the environments with a count above zero are
+// written
+func TestHandleJSON(t *testing.T) {
+	r := &req{
+		format:  "json",
+		inFile:  filepath.Join("testdata", "test.json"),
+		relPath: filepath.Join(t.TempDir(), "out.json"),
+	}
+	var err error
+	r.catalog, err = loadCatalog("")
+	if err != nil {
+		t.Fatalf("loadCatalog() failed: unexpected error: %v", err)
+	}
+	err = r.init()
+	if err != nil {
+		t.Fatalf("init() failed: unexpected error: %v", err)
+	}
+	r.ritm.TestCount, r.ritm.TestSize = "0", ""
+
+	err = r.handleJSON()
+	if err != nil {
+		t.Fatalf("handleJSON() failed: unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{
+		"development_instance_class": "db.m5.large", "development_allocated_storage": 20, "development_replica_count": 0,
 	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestCheckErr") // #nosec G204
-	cmd.Env = append(os.Environ(), "BE_CHECKERR=1")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
+	for k, v := range expected {
+		if got, ok := r.reqMap[k]; !ok || got != v {
+			t.Errorf("handleJSON() failed: expected %s = %v, got: %v", k, v, got)
+		}
+	}
+	for _, k := range []string{"test_instance_class", "test_allocated_storage", "test_replica_count"} {
+		if got, ok := r.reqMap[k]; ok {
+			t.Errorf("handleJSON() failed: unexpected %s for the disabled environment: %v", k, got)
+		}
 	}
-	t.Fatalf("process ran with err %v, want exit status 1", err)
 }
 
 func TestRandStart(t *testing.T) {
@@ -213,8 +356,11 @@ func TestHandleRITM(t *testing.T) {
 		tc := tc
 		t.Run(name, func(t *testing.T) {
 			resetEnv(tc.args, tc.env)
-			handleRITM()
-			err := os.Remove(filepath.Join(os.TempDir(), "test_out.json"))
+			err := handleRITM(context.Background())
+			if err != nil {
+				t.Fatalf("handleRITM() failed: unexpected error: %v", err)
+			}
+			err = os.Remove(filepath.Join(os.TempDir(), "test_out.json"))
 			if err != nil {
 				t.Fatalf("handleRITM() failed. Unable to remove testFile: %v", err)
 			}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// modification changes the size, storage, multi-AZ or engine minor version of
+// an existing database, as requested by a modification RITM
+type modification struct {
+	*req
+	module string // name of the instance's module block
+}
+
+// attributeChange is a module attribute changed by a modification
+type attributeChange struct {
+	Module string      `json:"module,omitempty"`
+	Name   string      `json:"name"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after"`
+}
+
+// moduleEditor sets the attributes of a module, recording the changes
+type moduleEditor struct {
+	file    *tfFile
+	module  string
+	changes []attributeChange
+	err     error // first error setting an attribute
+}
+
+// modifyRITM runs the modify subcommand. Errors are posted to the
+// modification RITM once it has been read.
+func modifyRITM(ctx context.Context, progName string, args []string) error {
+	m, err := newModification(ctx, progName, args)
+	if err == nil {
+		err = m.run()
+	}
+	m.record(err)
+	return err
+}
+
+func newModification(ctx context.Context, progName string, args []string) (*modification, error) {
+	m := &modification{req: &req{ctx: ctx, format: tfConst}}
+	flags := flag.NewFlagSet(progName, flag.ContinueOnError)
+	var buf bytes.Buffer
+	flags.SetOutput(&buf)
+	m.addChangeFlags(flags, "modification")
+	flags.StringVar(&m.catalogFile, "catalog", "", "Engine catalog file (YAML or JSON), defaults to the built-in catalog")
+	flags.StringVar(&m.stateDir, "state-dir", filepath.Join(os.TempDir(), "grace-paas-rds"),
+		"Directory for the pipeline state files, used to resume an interrupted modification")
+	err := flags.Parse(args)
+	if err != nil {
+		fmt.Println(buf.String())
+		return m, err
+	}
+
+	err = m.checkChange()
+	if err != nil {
+		flags.PrintDefaults()
+		return m, err
+	}
+
+	m.catalog, err = loadCatalog(m.catalogFile)
+	if err != nil {
+		return m, err
+	}
+
+	err = m.newClients()
+	if err != nil {
+		return m, err
+	}
+	err = m.parseRITM()
+	if err != nil {
+		return m, err
+	}
+	err = m.ritm.validateModification()
+	if err != nil {
+		return m, err
+	}
+	return m, m.useBranch(m.ritm.Number)
+}
+
+// run changes the instance's module block in a pull request, closing the
+// RITM once it has been applied
+func (m *modification) run() error {
+	defer m.removeClone()
+
+	return m.runStages([]stage{
+		{name: stageClone, local: true, run: m.cloneModule},
+		{name: stageBranch, local: true, run: m.newBranch},
+		{name: stageWrite, local: true, run: m.modifyStage},
+		{name: stageCommit, run: m.commitStage},
+		{name: stagePullRequest, run: func() error {
+			return m.openPullRequest(m.ritm.Number+" modify "+m.ritm.Identifier, m.prBody())
+		}},
+		{name: stageMerge, run: m.mergeStage},
+		{name: stageApply, run: m.applyStage},
+		{name: stageRITM, run: func() error {
+			return setRITMState(m.context(), m.snowClient, m.ritm, 3, "RDS modified via GRACE-PaaS CI/CD Pipeline")
+		}},
+	})
+}
+
+// cloneModule clones the repository and finds the instance's module
+func (m *modification) cloneModule() error {
+	err := m.cloneStage()
+	if err != nil {
+		return err
+	}
+
+	m.relPath, m.module, err = findModule(m.tempDir, m.ritm.Identifier)
+	m.fullPath = filepath.Join(m.tempDir, m.relPath)
+	return err
+}
+
+func (m *modification) modifyStage() error {
+	f, err := readTFFile(m.fullPath)
+	if err != nil {
+		return err
+	}
+
+	e := &moduleEditor{file: f, module: m.module}
+	err = m.ritm.modify(e, m.catalog)
+	if err != nil {
+		return err
+	}
+	if len(e.changes) == 0 {
+		return fmt.Errorf("%s already has the requested configuration", m.ritm.Identifier)
+	}
+
+	m.state.Changes = e.changes // Saved when the stage completes
+	return f.write()
+}
+
+// modify applies the requested changes to the instance's module
+func (ritm *ritm) modify(e *moduleEditor, c *catalog) error {
+	spec, err := c.engineFor(e.get("engine"), e.get("major_engine_version"))
+	if err != nil {
+		return err
+	}
+
+	if ritm.Size != "" {
+		size, err := spec.size(ritm.Size)
+		if err != nil {
+			return err
+		}
+		e.set("instance_class", size.InstanceClass)
+	}
+
+	if ritm.Storage != "" {
+		err = ritm.modifyStorage(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch ritm.MultiAZ {
+	case yes:
+		e.set("multi_az", true)
+		if e.get("subnet_ids") == "" && e.get("replicate_source_db") == "" {
+			e.set("subnet_ids", "${module.network.back_vpc_subnet_ids}")
+		}
+	case no:
+		if v, ok := e.file.attribute(e.module, "multi_az"); ok && v != false {
+			e.set("multi_az", false)
+		}
+	}
+
+	if ritm.MinorUpgrade == yes {
+		if v := e.get("engine_version"); compareVersions(spec.EngineVersion, v) < 0 {
+			return fmt.Errorf("engine_version %s is newer than the catalog version %s", v, spec.EngineVersion)
+		}
+		e.set("engine_version", spec.EngineVersion)
+	}
+	return e.err
+}
+
+// modifyStorage increases the allocated storage, keeping the storage
+// autoscaling limit at least three times the allocated storage
+func (ritm *ritm) modifyStorage(e *moduleEditor) error {
+	n, _ := strconv.Atoi(strings.TrimSpace(ritm.Storage)) // Checked by validateModification
+	current, err := strconv.Atoi(e.get("allocated_storage"))
+	if err != nil {
+		return fmt.Errorf("allocated_storage of %s is not a number: %q", ritm.Identifier, e.get("allocated_storage"))
+	}
+	if n < current {
+		return fmt.Errorf("allocated_storage cannot be reduced from %d GiB to %d GiB", current, n)
+	}
+
+	e.set("allocated_storage", n)
+	if limit, err := strconv.Atoi(e.get("max_allocated_storage")); err == nil && limit < 3*n {
+		e.set("max_allocated_storage", 3*n)
+	}
+	return nil
+}
+
+// prBody links the pull request to the RITM and shows the changes as a diff
+func (m *modification) prBody() string {
+	return fmt.Sprintf("%s\n\nModifies %s:\n\n%s", ritmLink(m.ritm), m.ritm.Identifier, changeDiff(m.state.Changes))
+}
+
+// changeDiff renders the attribute changes as a diff of their values, grouped
+// by module
+func changeDiff(changes []attributeChange) string {
+	var b strings.Builder
+	b.WriteString("```diff\n")
+	var module string
+	for _, c := range changes {
+		if c.Module != module {
+			module = c.Module
+			fmt.Fprintf(&b, "  module %q\n", module)
+		}
+		if c.Before != nil {
+			before, _ := json.Marshal(c.Before)
+			fmt.Fprintf(&b, "- %s = %s\n", c.Name, before)
+		}
+		after, _ := json.Marshal(c.After)
+		fmt.Fprintf(&b, "+ %s = %s\n", c.Name, after)
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+// get returns a module attribute as a string, or "" if it is not set
+func (e *moduleEditor) get(name string) string {
+	return e.file.stringAttribute(e.module, name)
+}
+
+// set changes an attribute if its value differs, recording the change
+func (e *moduleEditor) set(name string, v interface{}) {
+	v, err := jsonValue(v)
+	before, _ := e.file.attribute(e.module, name)
+	if err == nil && before != nil && jsonEqual(before, v) {
+		return
+	}
+
+	if err == nil {
+		err = e.file.setAttribute(e.module, name, v)
+	}
+	if err != nil {
+		if e.err == nil {
+			e.err = err
+		}
+		return
+	}
+	e.changes = append(e.changes, attributeChange{Module: e.module, Name: name, Before: before, After: v})
+}
+
+// jsonEqual returns true if a and b have the same JSON encoding
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+// record posts the error to the modification RITM, if it has been read
+func (m *modification) record(e error) {
+	if e == nil || m.ritm == nil || m.snowClient == nil || m.commentInterrupted(e, "Modification") {
+		return
+	}
+
+	err := reopenRITM(context.Background(), m.snowClient, m.ritm, "modifying RDS", e)
+	if err != nil {
+		fmt.Printf("Unable to update RITM: %v\n", err)
+	}
+}
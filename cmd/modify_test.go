@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewModification(t *testing.T) {
+	oldArgs, oldEnv := captureEnv()
+	defer resetEnv(oldArgs, oldEnv)
+	env := map[string]string{
+		"CIRCLE_TOKEN": "test",
+		"GITHUB_TOKEN": "test",
+		"SN_INSTANCE":  "test",
+		"SN_PASSWORD":  "test",
+		"SN_USER":      "test",
+	}
+	request := filepath.Join("testdata", "modify.json")
+
+	tt := map[string]struct {
+		args []string
+		env  map[string]string
+		err  string
+	}{
+		"happy":   {args: []string{"-request", request, "-repo", "test-repo", "-state-dir", os.TempDir()}, env: env},
+		"no repo": {args: []string{"-request", request}, env: env, err: "reponame must be set"},
+		"no token": {
+			args: []string{"-request", request, "-repo", "test-repo"},
+			env:  map[string]string{"CIRCLE_TOKEN": "", "GITHUB_TOKEN": "test"},
+			err:  "environment variable CIRCLE_TOKEN must be set",
+		},
+		"no gitlab token": {
+			args: []string{"-request", request, "-repo", "test-repo", "-code-host", gitLabHost},
+			env:  map[string]string{"CIRCLE_TOKEN": "test", "GITHUB_TOKEN": "test", "GITLAB_TOKEN": ""},
+			err:  "environment variable GITLAB_TOKEN must be set",
+		},
+		"provisioning request": {
+			args: []string{"-request", filepath.Join("testdata", "test.json"), "-repo", "test-repo"},
+			env:  env,
+			err:  `invalid RITM (1 errors): changes: at least one of size, allocated_storage, multi_az or minor_version_upgrade must be set`,
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			resetEnv(oldArgs, tc.env)
+			m, err := newModification(context.Background(), "modify", tc.args)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("newModification() failed: unexpected error: %v", err)
+				}
+				if m.ritm.Identifier != "test-prod" || m.branch != "RITM0003001" || m.state == nil {
+					t.Errorf("newModification() failed: unexpected modification: %+v", m.req)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("newModification() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+// nolint: funlen
+func TestModify(t *testing.T) {
+	tt := map[string]struct {
+		ritm    ritm
+		version string // engine_version in the file before the change
+		changes string
+		err     string
+	}{
+		"size": {
+			ritm:    ritm{Identifier: "test-dev", Size: "medium"},
+			changes: `[{"name":"instance_class","before":"db.m5.large","after":"db.m5.xlarge"}]`,
+		},
+		"storage": {
+			ritm: ritm{Identifier: "test-prod", Storage: "200"},
+			changes: `[{"name":"allocated_storage","before":100,"after":200},` +
+				`{"name":"max_allocated_storage","before":300,"after":600}]`,
+		},
+		"reduce storage": {
+			ritm: ritm{Identifier: "test-prod", Storage: "50"},
+			err:  "allocated_storage cannot be reduced from 100 GiB to 50 GiB",
+		},
+		"enable multi-az": {
+			ritm: ritm{Identifier: "test-dev", MultiAZ: yes},
+			changes: `[{"name":"multi_az","after":true},` +
+				`{"name":"subnet_ids","after":"${module.network.back_vpc_subnet_ids}"}]`,
+		},
+		"disable multi-az": {
+			ritm:    ritm{Identifier: "test-prod", MultiAZ: no},
+			changes: `[{"name":"multi_az","before":true,"after":false}]`,
+		},
+		"already single-az": {
+			ritm:    ritm{Identifier: "test-dev", MultiAZ: no},
+			changes: `null`,
+		},
+		"minor upgrade": {
+			ritm:    ritm{Identifier: "test-test", MinorUpgrade: yes},
+			version: "12.2",
+			changes: `[{"name":"engine_version","before":"12.2","after":"12.3"}]`,
+		},
+		"newer than catalog": {
+			ritm:    ritm{Identifier: "test-test", MinorUpgrade: yes},
+			version: "12.10",
+			err:     "engine_version 12.10 is newer than the catalog version 12.3",
+		},
+	}
+	for _, format := range []string{tfConst, hclConst} {
+		for name, tc := range tt {
+			tc := tc
+			t.Run(format+" "+name, func(t *testing.T) {
+				dir, err := ioutil.TempDir("", "modify")
+				if err != nil {
+					t.Fatalf("unable to create temp dir: %v", err)
+				}
+				defer os.RemoveAll(dir)
+				err = os.Mkdir(filepath.Join(dir, tfConst), 0700)
+				if err != nil {
+					t.Fatalf("unable to create terraform dir: %v", err)
+				}
+				writeTestTerraform(t, filepath.Join(dir, tfConst), format)
+
+				relPath, module, err := findModule(dir, tc.ritm.Identifier)
+				if err != nil {
+					t.Fatalf("findModule() failed: unexpected error: %v", err)
+				}
+				f, err := readTFFile(filepath.Join(dir, relPath))
+				if err != nil {
+					t.Fatalf("readTFFile() failed: unexpected error: %v", err)
+				}
+				if tc.version != "" {
+					_ = f.setAttribute(module, "engine_version", tc.version)
+				}
+
+				e := &moduleEditor{file: f, module: module}
+				err = tc.ritm.modify(e, testCatalog(t))
+				if tc.err != "" {
+					if err == nil || err.Error() != tc.err {
+						t.Errorf("modify() failed: expected error: %s\nGot: %v", tc.err, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("modify() failed: unexpected error: %v", err)
+				}
+				for i, c := range e.changes {
+					v, _ := f.attribute(module, c.Name)
+					got, _ := json.Marshal(v)
+					want, _ := json.Marshal(c.After)
+					if c.Module != module || string(got) != string(want) {
+						t.Errorf("modify() failed: expected %s.%s %s, got: %s.%s %s", module, c.Name, want, c.Module, c.Name, got)
+					}
+					e.changes[i].Module = "" // Checked above
+				}
+				b, _ := json.Marshal(e.changes)
+				if string(b) != tc.changes {
+					t.Errorf("modify() failed: expected changes: %s\nGot: %s", tc.changes, b)
+				}
+			})
+		}
+	}
+
+	_, _, err := findModule(os.TempDir(), "missing")
+	if err == nil {
+		t.Errorf("findModule() failed: expected error for missing identifier")
+	}
+}
+
+func TestChangeDiff(t *testing.T) {
+	changes := []attributeChange{
+		{Module: "test_prod", Name: "engine_version", Before: "11.8", After: "12.3"},
+		{Module: "test_prod", Name: "apply_immediately", After: true},
+		{Module: "test_prod_replica_1", Name: "engine_version", Before: "11.8", After: "12.3"},
+	}
+	expected := "```diff\n" +
+		"  module \"test_prod\"\n" +
+		"- engine_version = \"11.8\"\n" +
+		"+ engine_version = \"12.3\"\n" +
+		"+ apply_immediately = true\n" +
+		"  module \"test_prod_replica_1\"\n" +
+		"- engine_version = \"11.8\"\n" +
+		"+ engine_version = \"12.3\"\n" +
+		"```"
+	if got := changeDiff(changes); got != expected {
+		t.Errorf("changeDiff() failed: expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
@@ -1,55 +1,117 @@
-// https://golangbyexample.com/generate-random-password-golang/
 package main
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"strings"
 )
 
-func generatePassword() string {
-	fmt.Println("Generating password")
-	const min = 2             // minimum number of each type of character
-	const passwordLength = 20 // length of password
-	var lowerCharSet = "abcdedfghijklmnopqrstuvwxyz"
-	var upperCharSet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	var specialCharSet = "!@#$%&*"
-	var numberSet = "0123456789"
-	var allCharSet = lowerCharSet + upperCharSet + specialCharSet + numberSet
-	var password strings.Builder
+// Password character classes
+const (
+	lowerCharSet  = "abcdefghijklmnopqrstuvwxyz"
+	upperCharSet  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	numberCharSet = "0123456789"
+)
 
-	// Set special character
-	for i := 0; i < min; i++ {
-		random := rand.Intn(len(specialCharSet))
-		password.WriteString(string(specialCharSet[random]))
+// passwordPolicy defines the length and character classes of the generated
+// master passwords for an engine
+type passwordPolicy struct {
+	Length     int    `json:"length" yaml:"length"`
+	MinLower   int    `json:"min_lower" yaml:"min_lower"`
+	MinUpper   int    `json:"min_upper" yaml:"min_upper"`
+	MinNumber  int    `json:"min_number" yaml:"min_number"`
+	MinSpecial int    `json:"min_special" yaml:"min_special"`
+	Special    string `json:"special" yaml:"special"`
+}
+
+// defaultPasswordPolicy is used for engines without a catalog password policy
+func defaultPasswordPolicy() passwordPolicy {
+	return passwordPolicy{
+		Length:     20,
+		MinLower:   2,
+		MinUpper:   2,
+		MinNumber:  2,
+		MinSpecial: 2,
+		Special:    "!#$%&*",
 	}
+}
 
-	// Set numeric
-	for i := 0; i < min; i++ {
-		random := rand.Intn(len(numberSet))
-		password.WriteString(string(numberSet[random]))
+// charSet returns every character the policy may use
+func (p passwordPolicy) charSet() string {
+	return lowerCharSet + upperCharSet + numberCharSet + p.Special
+}
+
+// validate checks the policy against the engine's password rules
+func (p passwordPolicy) validate(rules namingRules) []string {
+	var errs []string
+	if p.Length < 8 || p.Length > rules.passwordMaxLength {
+		errs = append(errs, fmt.Sprintf("password length must be between 8 and %d, got %d", rules.passwordMaxLength, p.Length))
+	}
+	if p.MinLower < 0 || p.MinUpper < 0 || p.MinNumber < 0 || p.MinSpecial < 0 {
+		errs = append(errs, "password minimums must not be negative")
+	}
+	if p.MinLower+p.MinUpper+p.MinNumber+p.MinSpecial > p.Length {
+		errs = append(errs, "password minimums exceed the password length")
 	}
+	if p.MinSpecial > 0 && p.Special == "" {
+		errs = append(errs, "password special characters must be set if min_special is set")
+	}
+	if i := strings.IndexAny(p.Special, rules.passwordForbidden+lowerCharSet+upperCharSet+numberCharSet); i >= 0 {
+		errs = append(errs, fmt.Sprintf("password special characters must not include %q", p.Special[i]))
+	}
+	for _, c := range p.Special {
+		if c < '!' || c > '~' {
+			errs = append(errs, fmt.Sprintf("password special characters must be printable ASCII, got %q", c))
+		}
+	}
+	return errs
+}
 
-	// Set uppercase
-	for i := 0; i < min; i++ {
-		random := rand.Intn(len(upperCharSet))
-		password.WriteString(string(upperCharSet[random]))
+// generatePassword returns a random password from crypto/rand meeting the
+// policy, rejecting it if it contains any of the forbidden characters
+func generatePassword(p passwordPolicy, forbidden string) (string, error) {
+	fmt.Println("Generating password")
+	var password []byte
+	for _, class := range []struct {
+		set string
+		min int
+	}{
+		{lowerCharSet, p.MinLower},
+		{upperCharSet, p.MinUpper},
+		{numberCharSet, p.MinNumber},
+		{p.Special, p.MinSpecial},
+		{p.charSet(), p.Length - p.MinLower - p.MinUpper - p.MinNumber - p.MinSpecial},
+	} {
+		for i := 0; i < class.min; i++ {
+			n, err := randomInt(len(class.set))
+			if err != nil {
+				return "", err
+			}
+			password = append(password, class.set[n])
+		}
+	}
+
+	// Fisher-Yates shuffle so the required characters are not at the start
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
 	}
 
-	// Set lowercase
-	for i := 0; i < min; i++ {
-		random := rand.Intn(len(lowerCharSet))
-		password.WriteString(string(lowerCharSet[random]))
+	if strings.ContainsAny(string(password), forbidden) {
+		return "", fmt.Errorf("generated password contains a forbidden character, check the catalog password policy")
 	}
+	return string(password), nil
+}
 
-	remainingLength := passwordLength - 4*min
-	for i := 0; i < remainingLength; i++ {
-		random := rand.Intn(len(allCharSet))
-		password.WriteString(string(allCharSet[random]))
+// randomInt returns a uniform random number in [0, max) from crypto/rand
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
 	}
-	inRune := []rune(password.String())
-	rand.Shuffle(len(inRune), func(i, j int) {
-		inRune[i], inRune[j] = inRune[j], inRune[i]
-	})
-	return string(inRune)
+	return int(n.Int64()), nil
 }
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"math"
 	"regexp"
+	"strings"
 	"testing"
 )
 
+// nolint: funlen
 func TestGeneratePassword(t *testing.T) {
-	p := generatePassword()
+	policy := defaultPasswordPolicy()
+	p, err := generatePassword(policy, "/\"@ ")
+	if err != nil {
+		t.Fatalf("generatePassword() failed: unexpected error: %v", err)
+	}
 
 	// Check password is 20 characters long
 	if len(p) != 20 {
@@ -35,9 +42,96 @@ func TestGeneratePassword(t *testing.T) {
 	}
 
 	// Check password has at least two special characters
-	re = regexp.MustCompile(`[\!\@\#\$\%\&\*]`)
+	re = regexp.MustCompile(`[\!\#\$\%\&\*]`)
 	m = re.FindAllString(p, -1)
 	if len(m) < 2 {
 		t.Errorf("generatePassword() failed. Password does not contain at least two special characters: %s %v", p, m)
 	}
+
+	// Check password has no characters RDS forbids
+	if strings.ContainsAny(p, "/\"@ ") {
+		t.Errorf("generatePassword() failed. Password contains a forbidden character: %s", p)
+	}
+
+	// Check a custom policy is applied
+	policy = passwordPolicy{Length: 32, MinNumber: 30, Special: "-"}
+	p, err = generatePassword(policy, "/\"@ ")
+	if err != nil {
+		t.Fatalf("generatePassword() failed: unexpected error: %v", err)
+	}
+	if len(p) != 32 || len(regexp.MustCompile(`[0-9]`).FindAllString(p, -1)) < 30 {
+		t.Errorf("generatePassword() failed. Password does not match custom policy: %s", p)
+	}
+
+	// Check a forbidden character is rejected
+	_, err = generatePassword(passwordPolicy{Length: 8, MinSpecial: 8, Special: "@"}, "/\"@ ")
+	if err == nil {
+		t.Errorf("generatePassword() failed. Expected error for forbidden character")
+	}
+}
+
+// Tests that the passwords are unpredictable: no repeats, every character
+// of the set used, and a character distribution close to uniform
+func TestPasswordEntropy(t *testing.T) {
+	const samples = 2000
+	policy := testCatalog(t).Engines["mysql8.0"].passwordPolicy()
+	set := policy.charSet()
+
+	// Entropy of the unconstrained characters alone must be at least 128 bits
+	free := policy.Length - policy.MinLower - policy.MinUpper - policy.MinNumber - policy.MinSpecial
+	if bits := float64(free) * math.Log2(float64(len(set))); bits < 128 {
+		t.Errorf("generatePassword() failed. Catalog policy has only %.1f bits of entropy", bits)
+	}
+
+	seen := map[string]bool{}
+	counts := map[rune]int{}
+	var total int
+	for i := 0; i < samples; i++ {
+		p, err := generatePassword(policy, "/\"@ ")
+		if err != nil {
+			t.Fatalf("generatePassword() failed: unexpected error: %v", err)
+		}
+		if seen[p] {
+			t.Fatalf("generatePassword() failed. Password repeated after %d samples: %s", i, p)
+		}
+		seen[p] = true
+		for _, c := range p {
+			counts[c]++
+			total++
+		}
+	}
+
+	for _, c := range set {
+		if counts[c] == 0 {
+			t.Errorf("generatePassword() failed. Character %q never used in %d passwords", c, samples)
+		}
+	}
+
+	// Shannon entropy per character, in bits, must be close to the maximum
+	// for the character set
+	var entropy float64
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	max := math.Log2(float64(len(set)))
+	if entropy < 0.95*max {
+		t.Errorf("generatePassword() failed. Character entropy %.2f bits, expected close to %.2f", entropy, max)
+	}
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	rules, _ := engineNamingRules("mysql")
+	if errs := defaultPasswordPolicy().validate(rules); len(errs) != 0 {
+		t.Errorf("validate() failed: unexpected errors for default policy: %v", errs)
+	}
+
+	errs := passwordPolicy{Length: 4, MinSpecial: 1}.validate(rules)
+	expected := []string{
+		"password length must be between 8 and 41, got 4",
+		"password special characters must be set if min_special is set",
+	}
+	if strings.Join(errs, "; ") != strings.Join(expected, "; ") {
+		t.Errorf("validate() failed: expected: %v\nGot: %v", expected, errs)
+	}
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// stage is a single step of the terraform pipeline
+type stage struct {
+	name  string
+	local bool // only changes the cloned repo, so must be rerun until committed
+	run   func() error
+}
+
+func (r *req) handleTerraform() error {
+	err := r.runPipeline()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Processing complete")
+	return nil
+}
+
+// runPipeline runs the terraform pipeline stages for the RITM, removing the
+// cloned repository when it returns
+func (r *req) runPipeline() error {
+	defer r.removeClone()
+
+	return r.runStages([]stage{
+		{name: stageClone, local: true, run: r.cloneStage},
+		{name: stageBranch, local: true, run: r.newBranch},
+		{name: stageWrite, local: true, run: r.writeStage},
+		{name: stagePassword, run: r.addPasswords},
+		{name: stageCommit, run: r.commitStage},
+		{name: stagePullRequest, run: r.pullRequestStage},
+		{name: stageMerge, run: r.mergeStage},
+		{name: stageApply, run: r.applyStage},
+		{name: stageRITM, run: func() error { return r.updateRITM(r.context(), nil) }},
+	})
+}
+
+// runStages runs each stage in order, recording its completion in the state
+// file. Completed stages are skipped, except local stages that have not been
+// committed yet, since the cloned repository does not survive between runs.
+func (r *req) runStages(stages []stage) error {
+	for _, s := range stages {
+		if r.state.done(s.name) && (!s.local || r.state.done(stageCommit)) {
+			fmt.Printf("Skipping completed stage: %s\n", s.name)
+			continue
+		}
+
+		err := r.runStage(s.name, s.run)
+		if err != nil {
+			return err
+		}
+
+		err = r.state.complete(s.name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interruptedError is returned when the pipeline's context is cancelled
+// while a stage is running
+type interruptedError struct {
+	stage string
+	err   error
+}
+
+func (e *interruptedError) Error() string {
+	return fmt.Sprintf("interrupted at stage %s: %v", e.stage, e.err)
+}
+
+func (e *interruptedError) Unwrap() error {
+	return e.err
+}
+
+// runStage runs the stage with its timeout, the stage's calls reading the
+// context with the deadline from r.context()
+func (r *req) runStage(name string, run func() error) error {
+	parent := r.context()
+	timeout := r.stageTimeout(name)
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	defer cancel()
+	r.ctx = ctx
+	defer func() { r.ctx = parent }()
+
+	err := run()
+	switch {
+	case err == nil:
+		return nil
+	case parent.Err() != nil:
+		return &interruptedError{stage: name, err: parent.Err()}
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("%s stage timed out after %s: %w", name, timeout, err)
+	}
+	return fmt.Errorf("%s stage failed: %w", name, err)
+}
+
+// commentInterrupted comments on the RITM that the work stopped at a stage
+// and will continue from it when resumed, returning false if the error is not
+// an interruption
+func (r *req) commentInterrupted(e error, work string) bool {
+	var ie *interruptedError
+	if !errors.As(e, &ie) {
+		return false
+	}
+	if r.ritm == nil || r.snowClient == nil {
+		return true
+	}
+
+	// The pipeline's context is cancelled, the comment is only limited by the ServiceNow timeout
+	comment := fmt.Sprintf("%s interrupted at stage %s, it will continue from that stage when resumed", work, ie.stage)
+	err := r.commentRITM(context.Background(), comment)
+	if err != nil {
+		fmt.Printf("Unable to update RITM: %v\n", err)
+	}
+	return true
+}
+
+// checkState returns an error if a previous run for the RITM exists and the
+// pipeline is not being resumed
+func (r *req) checkState() error {
+	switch {
+	case r.resume || !r.state.started():
+		return nil
+	case r.state.done(stageRITM):
+		return fmt.Errorf("%s has already been provisioned", r.ritm.Number)
+	}
+	return fmt.Errorf("a previous run for %s stopped after the %s stage, use -resume to continue it",
+		r.ritm.Number, r.state.last())
+}
+
+// addChangeFlags adds the RITM and repository flags of the subcommands that
+// change existing databases, describing the kind of RITM they take
+func (r *req) addChangeFlags(flags *flag.FlagSet, kind string) {
+	flags.StringVar(&r.inFile, "request", "", "JSON input file of the "+kind+" RITM")
+	flags.StringVar(&r.ritmNumber, "ritm", "", "Number of the "+kind+" RITM to fetch from ServiceNow")
+	flags.StringVar(&r.sysID, "sys-id", "", "sys_id of the "+kind+" RITM to fetch from ServiceNow")
+	flags.StringVar(&r.repoName, "repo", "", "Repo name")
+	r.configOptions.addFlags(flags)
+	r.timeoutOptions.addFlags(flags)
+}
+
+// checkChange checks the flags and environment of the subcommands that change
+// existing databases, and loads the configuration for the repository
+func (r *req) checkChange() error {
+	err := r.checkInput()
+	if err != nil {
+		return err
+	}
+	if r.repoName == "" {
+		return fmt.Errorf("reponame must be set")
+	}
+	err = r.timeoutOptions.check()
+	if err != nil {
+		return err
+	}
+
+	r.config, err = r.loadConfig(r.repoName)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range append(r.config.tokenEnvs(), "SN_INSTANCE", "SN_PASSWORD", "SN_USER") {
+		if os.Getenv(name) == "" {
+			return fmt.Errorf("environment variable %s must be set", name)
+		}
+	}
+	return nil
+}
+
+// useBranch switches to a branch for the changes, loading its state file and
+// cloning to a directory of its own
+func (r *req) useBranch(branch string) error {
+	var err error
+	r.branch = branch
+	r.pr = nil
+	r.tempDir = filepath.Join(os.TempDir(), r.repoName+"-"+branch)
+	r.state, err = loadState(r.stateDir, branch)
+	return err
+}
+
+// removeClone removes the cloned repository, if any
+func (r *req) removeClone() {
+	if r.tempDir == "" {
+		return
+	}
+	err := os.RemoveAll(r.tempDir)
+	if err != nil {
+		fmt.Printf("Unable to remove %s: %v\n", r.tempDir, err)
+	}
+}
+
+func (r *req) cloneStage() error {
+	err := os.RemoveAll(r.tempDir) // Remove any clone left by a previous run
+	if err != nil {
+		return err
+	}
+
+	r.repo, err = r.cloneRepo()
+	return err
+}
+
+func (r *req) writeStage() error {
+	if r.resume && fileExists(r.fullPath) {
+		fmt.Printf("Reusing terraform from existing branch: %s\n", r.relPath)
+		return nil
+	}
+
+	tf, err := r.ritm.generateTerraform(r.catalog, r.secrets)
+	if err != nil {
+		return err
+	}
+	return r.writeTerraform(tf)
+}
+
+func (r *req) commitStage() error {
+	err := r.commit()
+	if err != nil {
+		return err
+	}
+
+	r.state.Branch = r.branch
+	return os.RemoveAll(r.tempDir) // Remove the cloned repo after pushing
+}
+
+func (r *req) pullRequestStage() error {
+	return r.openPullRequest(r.ritm.Number, r.prBody())
+}
+
+// openPullRequest creates the pull request for the branch, recording it in
+// the state
+func (r *req) openPullRequest(title, body string) error {
+	pr, err := r.pullRequest(title, body)
+	if err != nil {
+		return err
+	}
+	r.pr = pr
+	r.state.PullRequest = pr.Number
+	r.noteRITM("The request is waiting for review", "Pull request opened for review: "+pr.URL)
+	return nil
+}
+
+func (r *req) mergeStage() error {
+	err := r.loadPullRequest()
+	if err != nil {
+		return err
+	}
+
+	r.pr, err = r.waitForMerge(r.pr)
+	if err != nil {
+		return err
+	}
+	r.noteRITM("The request has been approved", "Pull request merged: "+r.pr.URL)
+	return nil
+}
+
+func (r *req) applyStage() error {
+	if r.pr == nil || r.pr.MergedAt.IsZero() {
+		r.pr = nil // Reload to get the merge time
+		err := r.loadPullRequest()
+		if err != nil {
+			return err
+		}
+	}
+	err := r.waitForMergedApply(r.pr)
+	if err != nil {
+		return err
+	}
+	r.noteEndpoints()
+	return nil
+}
+
+// loadPullRequest fetches the pull request created by a previous run
+func (r *req) loadPullRequest() error {
+	if r.pr != nil {
+		return nil
+	}
+
+	pr, err := r.getPullRequest(r.state.PullRequest)
+	if err != nil {
+		return err
+	}
+	r.pr = pr
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
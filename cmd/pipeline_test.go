@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// nolint: funlen
+func TestRunStages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var ran []string
+	fail := stagePullRequest
+	newStage := func(name string, local bool) stage {
+		return stage{name: name, local: local, run: func() error {
+			ran = append(ran, name)
+			if name == fail {
+				return fmt.Errorf("%s failed", name)
+			}
+			return nil
+		}}
+	}
+	stages := []stage{
+		newStage(stageClone, true),
+		newStage(stageBranch, true),
+		newStage(stageWrite, true),
+		newStage(stagePassword, false),
+		newStage(stageCommit, false),
+		newStage(stagePullRequest, false),
+		newStage(stageMerge, false),
+	}
+
+	r := &req{ritm: &ritm{Number: "RITM0001001"}}
+	r.state, err = loadState(dir, r.ritm.Number)
+	if err != nil {
+		t.Fatalf("loadState() failed: unexpected error: %v", err)
+	}
+
+	err = r.runStages(stages)
+	if err == nil || err.Error() != "pull_request stage failed: pull_request failed" {
+		t.Fatalf("runStages() failed: expected pull_request error, got: %v", err)
+	}
+
+	err = r.checkState()
+	expected := "a previous run for RITM0001001 stopped after the commit stage, use -resume to continue it"
+	if err == nil || err.Error() != expected {
+		t.Errorf("checkState() failed: expected: %s\nGot: %v", expected, err)
+	}
+
+	r.resume = true
+	if err = r.checkState(); err != nil {
+		t.Errorf("checkState() failed: unexpected error when resuming: %v", err)
+	}
+
+	ran = nil
+	fail = ""
+	err = r.runStages(stages)
+	if err != nil {
+		t.Fatalf("runStages() failed: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(ran, []string{stagePullRequest, stageMerge}) {
+		t.Errorf("runStages() failed: expected only the remaining stages to run, got: %v", ran)
+	}
+
+	// Local stages are rerun if the previous run stopped before committing
+	r.state.Completed = map[string]time.Time{}
+	for _, s := range []string{stageClone, stageBranch, stageWrite, stagePassword} {
+		r.state.Completed[s] = time.Now()
+	}
+	ran = nil
+	err = r.runStages(stages[:5])
+	if err != nil {
+		t.Fatalf("runStages() failed: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(ran, []string{stageClone, stageBranch, stageWrite, stageCommit}) {
+		t.Errorf("runStages() failed: expected uncommitted local stages to rerun, got: %v", ran)
+	}
+}
+
+// nolint: funlen
+func TestRunStage(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tt := map[string]struct {
+		ctx     context.Context
+		timeout time.Duration
+		err     string
+	}{
+		"failed":      {ctx: context.Background(), err: "merge stage failed: context canceled"},
+		"timeout":     {ctx: context.Background(), timeout: time.Millisecond, err: "merge stage timed out after 1ms: context deadline exceeded"},
+		"interrupted": {ctx: cancelled, timeout: time.Hour, err: "interrupted at stage merge: context canceled"},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			r := &req{ctx: tc.ctx, timeoutOptions: timeoutOptions{mergeTimeout: tc.timeout}}
+			err := r.runStage(stageMerge, func() error {
+				if tc.timeout == 0 {
+					return context.Canceled // A failure that is not caused by the stage's context
+				}
+				<-r.context().Done()
+				return r.context().Err()
+			})
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("runStage() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+			if r.ctx != tc.ctx {
+				t.Errorf("runStage() failed: stage context not restored")
+			}
+		})
+	}
+}
+
+func TestCommentInterrupted(t *testing.T) {
+	snow := fakeSnow(t)
+	defer snow.Close()
+	r := &req{ritm: &ritm{Number: "RITM0001001", SysID: testSysID}, snowClient: snow.client()}
+
+	if r.commentInterrupted(fmt.Errorf("apply failed"), "Provisioning") || len(snow.updates) != 0 {
+		t.Errorf("commentInterrupted() failed: commented on a failure: %v", snow.updates)
+	}
+
+	err := fmt.Errorf("wrapped: %w", &interruptedError{stage: stageApply, err: context.Canceled})
+	if !r.commentInterrupted(err, "Provisioning") {
+		t.Fatalf("commentInterrupted() failed: interruption not detected")
+	}
+	expected := "Provisioning interrupted at stage apply, it will continue from that stage when resumed"
+	if len(snow.updates) != 1 || snow.updates[0]["comments"] != expected {
+		t.Errorf("commentInterrupted() failed: expected comment: %s\nGot: %v", expected, snow.updates)
+	}
+}
+
+func TestStageNotes(t *testing.T) {
+	snow := fakeSnow(t)
+	defer snow.Close()
+	pr := &pullRequest{Number: 7, URL: "https://github.com/GSA/test-repo/pull/7", State: "closed", Merged: true}
+	r := &req{
+		config:     &config{},
+		host:       &fakeHost{pr: pr},
+		repoName:   "test-repo",
+		ritm:       &ritm{Number: "RITM0001001", SysID: testSysID},
+		snowClient: snow.client(),
+		state:      &pipelineState{},
+	}
+
+	err := r.openPullRequest("RITM0001001", "body")
+	if err != nil {
+		t.Fatalf("openPullRequest() failed: unexpected error: %v", err)
+	}
+	err = r.mergeStage()
+	if err != nil {
+		t.Fatalf("mergeStage() failed: unexpected error: %v", err)
+	}
+
+	expected := []string{"Pull request opened for review: " + pr.URL, "Pull request merged: " + pr.URL}
+	comments := []string{"The request is waiting for review", "The request has been approved"}
+	var notes, got []string
+	for _, u := range snow.updates {
+		notes = append(notes, fmt.Sprint(u["work_notes"]))
+		got = append(got, fmt.Sprint(u["comments"]))
+	}
+	if !reflect.DeepEqual(notes, expected) {
+		t.Errorf("stages failed: expected work notes %q, got: %q", expected, notes)
+	}
+	if !reflect.DeepEqual(got, comments) {
+		t.Errorf("stages failed: expected comments %q, got: %q", comments, got)
+	}
+}
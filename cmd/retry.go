@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v28/github"
+)
+
+// The delays between attempts back off exponentially from retryMinDelay to
+// retryMaxDelay, unless the server says how long to wait
+const (
+	defaultMaxAttempts = 4
+	retryMinDelay      = time.Second
+	retryMaxDelay      = time.Minute
+)
+
+// retryPolicy is how often and how long apart the API calls failing with a
+// transient error are attempted
+type retryPolicy struct {
+	attempts int // including the first, less than 2 disables retries
+	minDelay time.Duration
+	maxDelay time.Duration
+}
+
+func newRetryPolicy(attempts int) retryPolicy {
+	return retryPolicy{attempts: attempts, minDelay: retryMinDelay, maxDelay: retryMaxDelay}
+}
+
+// backoff returns the delay before the attempt after the given one: the
+// exponential delay with up to half of it taken off at random, so clients
+// failing together do not retry together
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.maxDelay
+	if attempt < 32 && p.minDelay<<(attempt-1) < p.maxDelay {
+		d = p.minDelay << (attempt - 1)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d - time.Duration(rand.Int63n(int64(d/2)+1)) // #nosec G404 jitter does not need a secure source
+}
+
+// call runs a call that is not idempotent, such as opening a pull request,
+// until it succeeds or fails with an error that is not transient. A failed
+// attempt may still have taken effect, so before each retry done is asked
+// whether it did.
+func (p retryPolicy) call(ctx context.Context, call func() error, done func() (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= p.attempts || !transientError(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := p.backoff(attempt)
+		fmt.Printf("Attempt %d failed: %v, checking whether it took effect in %s\n", attempt, err, wait)
+		if e := sleep(ctx, wait); e != nil {
+			return err
+		}
+		ok, e := done()
+		if e != nil {
+			return fmt.Errorf("%w, and checking whether it succeeded failed: %v", err, e)
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// statusError is the failure status an API answered a request with
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// transientStatus reports whether a request failing with the status may
+// succeed if it is sent again
+func transientStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// transientError reports whether a call failing with err may succeed if it
+// is made again: the request could not be sent or answered, the server was
+// unavailable, or a rate limit was hit
+func transientError(err error) bool {
+	var se *statusError
+	var ge *github.ErrorResponse
+	var rle *github.RateLimitError
+	var are *github.AbuseRateLimitError
+	var ne net.Error
+	switch {
+	case errors.As(err, &se):
+		return transientStatus(se.status)
+	case errors.As(err, &rle), errors.As(err, &are):
+		return true
+	case errors.As(err, &ge):
+		return ge.Response != nil && transientStatus(ge.Response.StatusCode)
+	case errors.As(err, &ne):
+		return true
+	}
+	return false
+}
+
+// retryTransport sends the API requests, retrying those failing with a
+// transient error. Idempotent requests are retried after any transient
+// error, other requests only when a rate limit rejected them, since a
+// gateway failure or a lost connection may come after the request was
+// carried out.
+type retryTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration // of each attempt, including reading the response
+	policy  retryPolicy
+}
+
+// newHTTPClient returns a client retrying the requests with the policy, each
+// attempt limited by the timeout
+func newHTTPClient(timeout time.Duration, policy retryPolicy) *http.Client {
+	return &http.Client{Transport: &retryTransport{next: http.DefaultTransport, timeout: timeout, policy: policy}}
+}
+
+// idempotentKey marks a context whose requests may be repeated
+type idempotentKey struct{}
+
+// idempotent returns a context whose requests are retried like idempotent
+// ones, for requests that only set state, such as updating a record with POST
+func idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Context().Value(idempotentKey{}) != nil
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.send(req)
+		wait, retry := t.retryDelay(req, resp, err, attempt)
+		if !retry || attempt >= t.policy.attempts || ctx.Err() != nil {
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err // The wait would outlast the stage
+		}
+
+		reason := fmt.Sprint(err)
+		if err == nil {
+			reason = resp.Status
+			_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16)) // Best effort, lets the connection be reused
+			resp.Body.Close()
+		}
+		fmt.Printf("%s %s failed with %s, retrying in %s\n", req.Method, req.URL.Path, reason, wait)
+		err = sleep(ctx, wait)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err = rewind(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send makes one attempt, which is cancelled once its timeout passes or its
+// response body is closed
+func (t *retryTransport) send(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// retryDelay returns how long to wait before sending the request again, and
+// false if it should not be sent again
+func (t *retryTransport) retryDelay(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	resendable := req.Body == nil || req.GetBody != nil
+	if err != nil {
+		return t.policy.backoff(attempt), resendable && isIdempotent(req)
+	}
+
+	wait, asked := serverDelay(resp)
+	if !asked {
+		wait = t.policy.backoff(attempt)
+	}
+	// A 403 asking to wait is GitHub's rate limit, which rejects the request
+	// before it is carried out
+	limited := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden && asked
+	return wait, resendable && (limited || isIdempotent(req) && transientStatus(resp.StatusCode))
+}
+
+// serverDelay returns how long the response asks to wait before the next
+// request, from its Retry-After header or, when the GitHub rate limit is used
+// up, the X-RateLimit-Reset time. It returns false if the response does not
+// ask to wait.
+func serverDelay(resp *http.Response) (time.Duration, bool) {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+			return time.Duration(s) * time.Second, true
+		}
+		if at, err := http.ParseTime(v); err == nil {
+			return nonNegative(time.Until(at)), true
+		}
+	}
+	if resp.StatusCode/100 == 2 || resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+	s, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return nonNegative(time.Until(time.Unix(s+1, 0))), true // The reset time is rounded down to seconds
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// rewind returns a copy of the request with its body reset for sending it again
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Body = body
+	return req, nil
+}
+
+// cancelBody cancels the context of the attempt when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
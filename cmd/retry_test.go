@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nolint: funlen
+func TestRetryTransport(t *testing.T) {
+	reset := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	tt := map[string]struct {
+		method     string
+		idempotent bool
+		status     int
+		headers    map[string]string
+		requests   int
+	}{
+		"gateway get":          {method: http.MethodGet, status: http.StatusBadGateway, requests: 2},
+		"gateway post":         {method: http.MethodPost, status: http.StatusBadGateway, requests: 1},
+		"gateway update":       {method: http.MethodPost, idempotent: true, status: http.StatusBadGateway, requests: 2},
+		"too many requests":    {method: http.MethodPost, status: http.StatusTooManyRequests, requests: 2},
+		"not found":            {method: http.MethodGet, status: http.StatusNotFound, requests: 1},
+		"forbidden":            {method: http.MethodGet, status: http.StatusForbidden, requests: 1},
+		"unavailable too long": {method: http.MethodGet, status: http.StatusServiceUnavailable, requests: 3},
+		"secondary rate limit": {
+			method:   http.MethodPost,
+			status:   http.StatusForbidden,
+			headers:  map[string]string{"Retry-After": "0"},
+			requests: 2,
+		},
+		"rate limit": {
+			method:   http.MethodPost,
+			status:   http.StatusForbidden,
+			headers:  map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset},
+			requests: 2,
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				b, _ := ioutil.ReadAll(r.Body)
+				if r.Method == http.MethodPost && string(b) != "body" {
+					t.Errorf("RoundTrip() failed: unexpected body of attempt %d: %q", requests, b)
+				}
+				if requests > 1 && tc.status != http.StatusServiceUnavailable {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				for k, v := range tc.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer ts.Close()
+
+			ctx := context.Background()
+			if tc.idempotent {
+				ctx = idempotent(ctx)
+			}
+			var body io.Reader
+			if tc.method == http.MethodPost {
+				body = strings.NewReader("body")
+			}
+			req, err := http.NewRequestWithContext(ctx, tc.method, ts.URL, body)
+			if err != nil {
+				t.Fatalf("NewRequest() failed: unexpected error: %v", err)
+			}
+
+			resp, err := newHTTPClient(time.Second, retryPolicy{attempts: 3}).Do(req)
+			if err != nil {
+				t.Fatalf("Do() failed: unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if requests != tc.requests {
+				t.Errorf("RoundTrip() failed: expected %d requests, got: %d", tc.requests, requests)
+			}
+		})
+	}
+}
+
+func TestRetryTransportDeadline(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() failed: unexpected error: %v", err)
+	}
+	resp, err := newHTTPClient(time.Second, retryPolicy{attempts: 3}).Do(req)
+	if err != nil {
+		t.Fatalf("Do() failed: unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if requests != 1 || resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("RoundTrip() failed: expected to give up on a wait past the deadline, got: %d requests, %s", requests, resp.Status)
+	}
+}
+
+func TestServerDelay(t *testing.T) {
+	at := time.Now().Add(time.Hour)
+	tt := map[string]struct {
+		status  int
+		headers map[string]string
+		min     time.Duration
+		asked   bool
+	}{
+		"seconds": {status: 429, headers: map[string]string{"Retry-After": "120"}, min: 2 * time.Minute, asked: true},
+		"date": {
+			status:  503,
+			headers: map[string]string{"Retry-After": at.UTC().Format(http.TimeFormat)},
+			min:     59 * time.Minute,
+			asked:   true,
+		},
+		"invalid": {status: 429, headers: map[string]string{"Retry-After": "soon"}},
+		"none":    {status: 403},
+		"rate limit": {
+			status:  403,
+			headers: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": fmt.Sprint(at.Unix())},
+			min:     59 * time.Minute,
+			asked:   true,
+		},
+		"limit unused": {status: 403, headers: map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": fmt.Sprint(at.Unix())}},
+	}
+	for name, tc := range tt {
+		resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+		for k, v := range tc.headers {
+			resp.Header.Set(k, v)
+		}
+		d, asked := serverDelay(resp)
+		if asked != tc.asked || d < tc.min || d > tc.min+2*time.Minute {
+			t.Errorf("serverDelay() failed: %s: expected at least %s asked %t, got: %s %t", name, tc.min, tc.asked, d, asked)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{attempts: 10, minDelay: time.Second, maxDelay: time.Minute}
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 7: time.Minute, 40: time.Minute} {
+		for i := 0; i < 10; i++ {
+			if d := p.backoff(attempt); d < max/2 || d > max {
+				t.Errorf("backoff() failed: attempt %d: expected between %s and %s, got: %s", attempt, max/2, max, d)
+			}
+		}
+	}
+}
+
+func TestTransientError(t *testing.T) {
+	tt := map[string]struct {
+		err       error
+		transient bool
+	}{
+		"bad gateway":   {err: &statusError{status: http.StatusBadGateway}, transient: true},
+		"wrapped":       {err: fmt.Errorf("creating: %w", &statusError{status: http.StatusServiceUnavailable}), transient: true},
+		"conflict":      {err: &statusError{status: http.StatusConflict}},
+		"timeout":       {err: context.DeadlineExceeded, transient: true},
+		"other":         {err: errors.New("invalid")},
+		"rate limited":  {err: &statusError{status: http.StatusTooManyRequests}, transient: true},
+		"network":       {err: fmt.Errorf("post: %w", &timeoutError{}), transient: true},
+		"not transient": {err: &statusError{status: http.StatusUnprocessableEntity}},
+	}
+	for name, tc := range tt {
+		if got := transientError(tc.err); got != tc.transient {
+			t.Errorf("transientError() failed: %s: expected %t, got: %t", name, tc.transient, got)
+		}
+	}
+}
+
+// timeoutError is a network error
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// nolint: funlen
+func TestRetryCall(t *testing.T) {
+	unavailable := &statusError{status: http.StatusServiceUnavailable, msg: "unavailable"}
+	tt := map[string]struct {
+		errs  []error // returned by the calls in turn
+		done  bool    // whether the failed attempts took effect
+		calls int
+		err   string
+	}{
+		"succeeded":      {calls: 1},
+		"retried":        {errs: []error{unavailable}, calls: 2},
+		"took effect":    {errs: []error{unavailable}, done: true, calls: 1},
+		"not transient":  {errs: []error{errors.New("invalid")}, calls: 1, err: "invalid"},
+		"attempts spent": {errs: []error{unavailable, unavailable, unavailable}, calls: 3, err: "unavailable"},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			err := retryPolicy{attempts: 3}.call(context.Background(), func() error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			}, func() (bool, error) {
+				return tc.done, nil
+			})
+			if calls != tc.calls {
+				t.Errorf("call() failed: expected %d calls, got: %d", tc.calls, calls)
+			}
+			if fmt.Sprint(err) != tc.err && !(err == nil && tc.err == "") {
+				t.Errorf("call() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
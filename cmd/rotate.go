@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// rotation replaces the master password of an RDS instance provisioned by a
+// RITM
+type rotation struct {
+	*req
+	identifier string
+}
+
+// rotatePassword runs the rotate-password subcommand. The result is recorded
+// on the RITM once, whether or not the rotation succeeds.
+func rotatePassword(ctx context.Context, progName string, args []string) error {
+	r, err := newRotation(ctx, progName, args)
+	if err != nil {
+		return err
+	}
+
+	err = r.rotate()
+	r.record(err)
+	return err
+}
+
+func newRotation(ctx context.Context, progName string, args []string) (*rotation, error) {
+	r := &rotation{req: &req{ctx: ctx, format: tfConst}}
+	flags := flag.NewFlagSet(progName, flag.ContinueOnError)
+	var buf bytes.Buffer
+	flags.SetOutput(&buf)
+	flags.StringVar(&r.identifier, "identifier", "", "RDS identifier of the instance, such as test-rds-prod")
+	r.addChangeFlags(flags, "provisioning")
+	flags.StringVar(&r.catalogFile, "catalog", "", "Engine catalog file (YAML or JSON), defaults to the built-in catalog")
+	r.secretOptions.addFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		fmt.Println(buf.String())
+		return nil, err
+	}
+
+	err = r.check()
+	if err != nil {
+		flags.PrintDefaults()
+		return nil, err
+	}
+
+	r.catalog, err = loadCatalog(r.catalogFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.newClients()
+	if err != nil {
+		return nil, err
+	}
+	err = r.parseRITM()
+	if err != nil {
+		return nil, err
+	}
+
+	r.secrets, err = r.newSecretStore()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotation) check() error {
+	err := r.checkChange()
+	if err != nil {
+		return err
+	}
+	if r.identifier == "" {
+		return fmt.Errorf("identifier must be set")
+	}
+	return r.secretOptions.check(false)
+}
+
+// checkIdentifier returns an error unless the identifier is the primary of one
+// of the RITM's environments. Replicas share the primary's password.
+func (r *rotation) checkIdentifier() error {
+	var ids []string
+	for _, env := range r.ritm.environments() {
+		ids = append(ids, env.identifier(r.ritm))
+	}
+	if !contains(ids, r.identifier) {
+		return fmt.Errorf("identifier %q is not provisioned by %s, expected one of: %s",
+			r.identifier, r.ritm.Number, strings.Join(ids, ", "))
+	}
+	return nil
+}
+
+// rotate stores a new password for the identifier and re-applies Terraform
+// on the base branch so the instance and its SSM parameter are updated. If the apply
+// fails the stored password is ahead of the instance until the next apply.
+func (r *rotation) rotate() error {
+	err := r.ritm.validate(r.catalog)
+	if err != nil {
+		return err
+	}
+	err = r.checkIdentifier()
+	if err != nil {
+		return err
+	}
+
+	spec, err := r.catalog.engine(r.ritm.Engine)
+	if err != nil {
+		return err
+	}
+	rules, _ := engineNamingRules(spec.Engine) // Checked when the catalog is loaded
+	password, err := generatePassword(spec.passwordPolicy(), rules.passwordForbidden)
+	if err != nil {
+		return err
+	}
+
+	sha, err := r.branchHead(r.config.BaseBranch)
+	if err != nil {
+		return fmt.Errorf("reading %s branch failed: %w", r.config.BaseBranch, err)
+	}
+
+	err = r.runStage(stagePassword, func() error {
+		return r.secrets.put(r.context(), r.identifier, password)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = r.runStage(stageApply, func() error {
+		start, err := r.triggerApply(r.config.BaseBranch, sha)
+		if err != nil {
+			return fmt.Errorf("triggering apply failed: %w", err)
+		}
+		return r.waitForApply(r.config.BaseBranch, sha, start)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rotated master password for %s\n", r.identifier)
+	return nil
+}
+
+// record comments the result of the rotation on the RITM, if it was read
+func (r *rotation) record(e error) {
+	if r.ritm == nil || r.snowClient == nil {
+		return
+	}
+
+	update := map[string]interface{}{
+		"comments": fmt.Sprintf("Master password for %s rotated via GRACE-PaaS CI/CD Pipeline", r.identifier),
+	}
+	if e != nil {
+		update = errorUpdate("rotating master password for "+r.identifier, "the request will be reviewed", e)
+	}
+	fmt.Printf("Commenting on %s (%s)\n", r.ritm.Number, r.ritm.SysID)
+	err := tableUpdate(context.Background(), r.snowClient, "sc_req_item", r.ritm.SysID, update)
+	if err != nil {
+		fmt.Printf("Unable to update RITM: %v\n", err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// testStore records the stored passwords, failing with err if it is set
+type testStore struct {
+	passwords map[string]string
+	err       error
+}
+
+func (s *testStore) put(ctx context.Context, id, password string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.passwords[id] = password
+	return nil
+}
+
+func (s *testStore) remove(ctx context.Context, id string) error {
+	delete(s.passwords, id)
+	return s.err
+}
+
+func (s *testStore) location(id string) string { return "test store " + id }
+
+func (s *testStore) reference(tf *terraform, id string) string { return tf.passwordVariable(id) }
+
+// fakeGitHub serves the master branch of the GSA/test-repo repository
+func fakeGitHub(t *testing.T) (*httptest.Server, codeHost) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/GSA/test-repo/branches/master" {
+			t.Errorf("unexpected GitHub request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "master", "commit": {"sha": "abc123"}}`))
+	}))
+
+	host, err := newGitHub(&config{Owner: "GSA", GitHubURL: ts.URL, GitHubAPIURL: ts.URL + "/"}, "test")
+	if err != nil {
+		t.Fatalf("newGitHub() failed: unexpected error: %v", err)
+	}
+	return ts, host
+}
+
+func TestNewRotation(t *testing.T) {
+	oldArgs, oldEnv := captureEnv()
+	defer resetEnv(oldArgs, oldEnv)
+	env := map[string]string{
+		"CIRCLE_TOKEN": "test",
+		"GITHUB_TOKEN": "test",
+		"SN_INSTANCE":  "test",
+		"SN_PASSWORD":  "test",
+		"SN_USER":      "test",
+	}
+	request := filepath.Join("testdata", "test.json")
+
+	tt := map[string]struct {
+		args []string
+		env  map[string]string
+		err  string
+	}{
+		"happy":         {args: []string{"-request", request, "-identifier", "test-dev", "-repo", "test-repo"}, env: env},
+		"no identifier": {args: []string{"-request", request, "-repo", "test-repo"}, env: env, err: "identifier must be set"},
+		"no repo":       {args: []string{"-request", request, "-identifier", "test-dev"}, env: env, err: "reponame must be set"},
+		"no ritm":       {args: []string{"-identifier", "test-dev"}, env: env, err: "request, ritm or sys-id must be set"},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			resetEnv(oldArgs, tc.env)
+			r, err := newRotation(context.Background(), "rotate-password", tc.args)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("newRotation() failed: unexpected error: %v", err)
+				}
+				if r.identifier != "test-dev" || r.ritm.Number != "RITM0001001" || r.secrets == nil {
+					t.Errorf("newRotation() failed: unexpected rotation: %+v", r)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("newRotation() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+// nolint: funlen
+func TestRotate(t *testing.T) {
+	snow := fakeSnow(t)
+	defer snow.Close()
+	gh, host := fakeGitHub(t)
+	defer gh.Close()
+
+	tt := map[string]struct {
+		identifier string
+		storeErr   error
+		err        string
+	}{
+		"replica identifier": {
+			identifier: "test-prod-replica-1",
+			err:        `identifier "test-prod-replica-1" is not provisioned by RITM0001001, expected one of: test-dev, test-test, test-prod`,
+		},
+		"store failure": {
+			identifier: "test-prod",
+			storeErr:   fmt.Errorf("access denied"),
+			err:        "password stage failed: access denied",
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			snow.updates = nil
+			store := &testStore{passwords: map[string]string{}, err: tc.storeErr}
+			r := &rotation{
+				req: &req{
+					catalog:    testCatalog(t),
+					config:     defaultConfig(),
+					host:       host,
+					inFile:     filepath.Join("testdata", "test.json"),
+					repoName:   "test-repo",
+					secrets:    store,
+					snowClient: snow.client(),
+				},
+				identifier: tc.identifier,
+			}
+			err := r.parseRITM()
+			if err != nil {
+				t.Fatalf("rotate() failed. Unable to parse test data: %v", err)
+			}
+			r.ritm.SysID = testSysID
+
+			err = r.rotate()
+			r.record(err)
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("rotate() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+			if len(store.passwords) != 0 {
+				t.Errorf("rotate() failed: unexpected stored passwords: %v", store.passwords)
+			}
+
+			if len(snow.updates) != 1 {
+				t.Fatalf("record() failed: expected 1 RITM update, got: %v", snow.updates)
+			}
+			u := snow.updates[0]
+			comment := fmt.Sprintf("Error rotating master password for %s, the request will be reviewed", tc.identifier)
+			note := fmt.Sprintf("Error rotating master password for %s: %s", tc.identifier, tc.err)
+			if u["comments"] != comment || u["work_notes"] != note || u["state"] != nil {
+				t.Errorf("record() failed: unexpected RITM update: %v", u)
+			}
+		})
+	}
+}
+
+func TestBranchHead(t *testing.T) {
+	ts, host := fakeGitHub(t)
+	defer ts.Close()
+
+	r := &req{config: defaultConfig(), host: host, repoName: "test-repo"}
+	sha, err := r.branchHead("master")
+	if err != nil {
+		t.Fatalf("branchHead() failed: unexpected error: %v", err)
+	}
+	if sha != "abc123" {
+		t.Errorf("branchHead() failed: expected abc123, got: %s", sha)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Secret store backends for the generated master passwords
+const (
+	storeCI             = "ci"
+	storeCircleCI       = "circleci" // name of the ci store before other CI runners were supported
+	storeSecretsManager = "secretsmanager"
+	storeSSM            = "ssm"
+	storeVault          = "vault"
+	storeFile           = "file"
+)
+
+// secretStoreNames returns the supported secret store backends
+func secretStoreNames() []string {
+	return []string{storeCI, storeSecretsManager, storeSSM, storeVault, storeFile}
+}
+
+// secretStore stores the generated master passwords where Terraform can read
+// them
+type secretStore interface {
+	// put stores the master password for the RDS identifier
+	put(ctx context.Context, id, password string) error
+	// remove deletes the master password for the RDS identifier, if any
+	remove(ctx context.Context, id string) error
+	// location describes where the password for the identifier is stored
+	location(id string) string
+	// reference returns the Terraform expression for the password, adding
+	// the variable or data source it refers to
+	reference(tf *terraform, id string) string
+}
+
+// secretOptions select and configure the secret store
+type secretOptions struct {
+	secretStore string
+	secretsFile string
+	vaultMount  string
+}
+
+func (o *secretOptions) addFlags(flags *flag.FlagSet) {
+	flags.StringVar(&o.secretStore, "secret-store", storeCI,
+		"Where to store the master passwords: "+strings.Join(secretStoreNames(), ", "))
+	flags.StringVar(&o.secretsFile, "secrets-file", "", "Encrypted password file for the file secret store")
+	flags.StringVar(&o.vaultMount, "vault-mount", "secret", "Vault KV version 2 mount for the vault secret store")
+}
+
+// check validates the secret store options, and its environment variables
+// unless it will not be contacted
+func (o *secretOptions) check(dryRun bool) error {
+	if o.secretStore == storeCircleCI {
+		o.secretStore = storeCI
+	}
+	if !contains(secretStoreNames(), o.secretStore) {
+		return fmt.Errorf("secret-store must be one of %s", strings.Join(secretStoreNames(), ", "))
+	}
+
+	var vars []string
+	switch o.secretStore {
+	case storeVault:
+		vars = []string{"VAULT_ADDR", "VAULT_TOKEN"}
+	case storeFile:
+		if o.secretsFile == "" {
+			return fmt.Errorf("secrets-file must be set if secret-store is 'file'")
+		}
+		vars = []string{"SECRETS_FILE_KEY"}
+	}
+	if dryRun {
+		return nil
+	}
+
+	for _, name := range vars {
+		if os.Getenv(name) == "" {
+			return fmt.Errorf("environment variable %s must be set if secret-store is '%s'", name, o.secretStore)
+		}
+	}
+	return nil
+}
+
+// newSecretStore returns the selected secret store. No backend is contacted
+// until a password is stored.
+func (r *req) newSecretStore() (secretStore, error) {
+	switch r.secretStore {
+	case storeSecretsManager:
+		return newSecretsManagerStore()
+	case storeSSM:
+		return newSSMStore()
+	case storeVault:
+		return &vaultStore{addr: os.Getenv("VAULT_ADDR"), token: os.Getenv("VAULT_TOKEN"), mount: r.vaultMount}, nil
+	case storeFile:
+		return &fileStore{path: r.secretsFile, key: os.Getenv("SECRETS_FILE_KEY")}, nil
+	}
+	if r.config.CIRunner == localRunner {
+		return nil, fmt.Errorf("secret-store %s cannot be used with the %s CI runner", storeCI, localRunner)
+	}
+	return &ciStore{ci: r.ci, runner: r.config.CIRunner, repo: r.repoName}, nil
+}
+
+// addPasswords generates and stores a master password for each environment
+func (r *req) addPasswords() error {
+	spec, err := r.catalog.engine(r.ritm.Engine)
+	if err != nil {
+		return err
+	}
+	rules, _ := engineNamingRules(spec.Engine) // Checked when the catalog is loaded
+
+	for _, env := range r.ritm.environments() {
+		password, err := generatePassword(spec.passwordPolicy(), rules.passwordForbidden)
+		if err != nil {
+			return err
+		}
+		err = r.secrets.put(r.context(), env.identifier(r.ritm), password)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secretName is the name of the identifier's master password secret
+func secretName(id string) string {
+	return "grace-paas-rds/" + id + "/master-password"
+}
+
+// ciStore stores the passwords as secrets of the CI runner, which Terraform
+// reads as input variables when the CI runner applies it
+type ciStore struct {
+	ci     ciRunner
+	runner string // CI runner name
+	repo   string
+}
+
+func (s *ciStore) envVar(id string) string {
+	return "TF_VAR_" + resourceName(id) + "_db_password"
+}
+
+func (s *ciStore) put(ctx context.Context, id, password string) error {
+	fmt.Printf("Creating %s\n", s.location(id))
+	return s.ci.setSecret(ctx, s.envVar(id), password)
+}
+
+func (s *ciStore) remove(ctx context.Context, id string) error {
+	fmt.Printf("Deleting %s\n", s.location(id))
+	return s.ci.deleteSecret(ctx, s.envVar(id))
+}
+
+func (s *ciStore) location(id string) string {
+	if s.runner == actionsRunner {
+		return fmt.Sprintf("GitHub Actions secret %s in %s repository", s.envVar(id), s.repo)
+	}
+	return fmt.Sprintf("CircleCI environment variable %s in %s project", s.envVar(id), s.repo)
+}
+
+func (s *ciStore) reference(tf *terraform, id string) string {
+	return tf.passwordVariable(id)
+}
+
+// fileStore stores the passwords in a local AES-256-GCM encrypted JSON file,
+// keyed by identifier. Terraform reads them as input variables, which must be
+// set from the file by whoever runs it.
+type fileStore struct {
+	path string
+	key  string // base64 encoded 32 byte key
+}
+
+// put stores the password in the file, which is local so ctx is not needed
+func (s *fileStore) put(ctx context.Context, id, password string) error {
+	fmt.Printf("Storing password for %s in %s\n", id, s.path)
+	passwords, err := s.read()
+	if err != nil {
+		return err
+	}
+	passwords[id] = password
+	return s.write(passwords)
+}
+
+func (s *fileStore) remove(ctx context.Context, id string) error {
+	fmt.Printf("Removing password for %s from %s\n", id, s.path)
+	passwords, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := passwords[id]; !ok {
+		return nil
+	}
+	delete(passwords, id)
+	return s.write(passwords)
+}
+
+// write encrypts the passwords, replacing the password file
+func (s *fileStore) write(passwords map[string]string) error {
+	b, err := json.Marshal(passwords)
+	if err != nil {
+		return err
+	}
+	gcm, err := s.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, gcm.Seal(nonce, nonce, b, nil), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// read decrypts the password file, returning no passwords if it does not exist
+func (s *fileStore) read() (map[string]string, error) {
+	passwords := map[string]string{}
+	b, err := ioutil.ReadFile(s.path) // #nosec G304
+	if os.IsNotExist(err) {
+		return passwords, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := s.cipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid secrets file %s", s.path)
+	}
+	b, err = gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt secrets file %s: %v", s.path, err)
+	}
+
+	err = json.Unmarshal(b, &passwords)
+	return passwords, err
+}
+
+func (s *fileStore) cipher() (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(s.key)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("SECRETS_FILE_KEY must be a base64 encoded 32 byte key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *fileStore) location(id string) string {
+	return fmt.Sprintf("%s entry in encrypted file %s", id, s.path)
+}
+
+func (s *fileStore) reference(tf *terraform, id string) string {
+	return tf.passwordVariable(id)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// secretsManagerStore stores the passwords as AWS Secrets Manager secrets
+type secretsManagerStore struct {
+	client secretsmanageriface.SecretsManagerAPI
+}
+
+func newSecretsManagerStore(cfgs ...*aws.Config) (secretStore, error) {
+	sess, err := session.NewSession(cfgs...)
+	if err != nil {
+		return nil, err
+	}
+	return &secretsManagerStore{client: secretsmanager.New(sess)}, nil
+}
+
+// put creates the secret, or adds a new version if it already exists
+func (s *secretsManagerStore) put(ctx context.Context, id, password string) error {
+	name := secretName(id)
+	fmt.Printf("Creating Secrets Manager secret %s\n", name)
+	_, err := s.client.CreateSecretWithContext(ctx, &secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		Description:  aws.String(id + " RDS Master Password"),
+		SecretString: aws.String(password),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceExistsException {
+		fmt.Printf("Updating existing Secrets Manager secret %s\n", name)
+		_, err = s.client.PutSecretValueWithContext(ctx, &secretsmanager.PutSecretValueInput{
+			SecretId:     aws.String(name),
+			SecretString: aws.String(password),
+		})
+	}
+	return err
+}
+
+// remove schedules the secret for deletion after the default recovery window,
+// so it can be restored if the database is
+func (s *secretsManagerStore) remove(ctx context.Context, id string) error {
+	name := secretName(id)
+	fmt.Printf("Deleting Secrets Manager secret %s\n", name)
+	_, err := s.client.DeleteSecretWithContext(ctx, &secretsmanager.DeleteSecretInput{SecretId: aws.String(name)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return nil
+	}
+	return err
+}
+
+func (s *secretsManagerStore) location(id string) string {
+	return "Secrets Manager secret " + secretName(id)
+}
+
+func (s *secretsManagerStore) reference(tf *terraform, id string) string {
+	name := resourceName(id) + "_db_password"
+	data := tf.data()
+	if data.SecretsManagerSecretVersion == nil {
+		data.SecretsManagerSecretVersion = map[string]*secretVersionData{}
+	}
+	data.SecretsManagerSecretVersion[name] = &secretVersionData{SecretID: secretName(id)}
+	return "${data.aws_secretsmanager_secret_version." + name + ".secret_string}"
+}
+
+// ssmStore stores the passwords as SSM Parameter Store SecureString parameters
+type ssmStore struct {
+	client ssmiface.SSMAPI
+}
+
+func newSSMStore(cfgs ...*aws.Config) (secretStore, error) {
+	sess, err := session.NewSession(cfgs...)
+	if err != nil {
+		return nil, err
+	}
+	return &ssmStore{client: ssm.New(sess)}, nil
+}
+
+// parameterName is the SSM parameter for the identifier's master password,
+// separate from the /database/password parameter the generated terraform
+// manages
+func (s *ssmStore) parameterName(id string) string {
+	return "/" + secretName(id)
+}
+
+func (s *ssmStore) put(ctx context.Context, id, password string) error {
+	name := s.parameterName(id)
+	fmt.Printf("Creating SSM parameter %s\n", name)
+	_, err := s.client.PutParameterWithContext(ctx, &ssm.PutParameterInput{
+		Name:        aws.String(name),
+		Description: aws.String(id + " RDS Master Password"),
+		Type:        aws.String(ssm.ParameterTypeSecureString),
+		Value:       aws.String(password),
+		Overwrite:   aws.Bool(true),
+	})
+	return err
+}
+
+func (s *ssmStore) remove(ctx context.Context, id string) error {
+	name := s.parameterName(id)
+	fmt.Printf("Deleting SSM parameter %s\n", name)
+	_, err := s.client.DeleteParameterWithContext(ctx, &ssm.DeleteParameterInput{Name: aws.String(name)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *ssmStore) location(id string) string {
+	return "SSM parameter " + s.parameterName(id)
+}
+
+func (s *ssmStore) reference(tf *terraform, id string) string {
+	name := resourceName(id) + "_db_password"
+	data := tf.data()
+	if data.SSMParameter == nil {
+		data.SSMParameter = map[string]*ssmParameterData{}
+	}
+	data.SSMParameter[name] = &ssmParameterData{Name: s.parameterName(id), WithDecryption: true}
+	return "${data.aws_ssm_parameter." + name + ".value}"
+}
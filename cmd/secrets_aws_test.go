@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// fakeAWS serves the AWS JSON protocol, recording the target and input of
+// each call. Secrets Manager secrets already in exists fail to be created, and
+// SSM parameters not in exists fail to be deleted.
+func fakeAWS(t *testing.T, calls *[]string, inputs *[]map[string]interface{}, exists map[string]bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		var in map[string]interface{}
+		err := json.NewDecoder(r.Body).Decode(&in)
+		if err != nil {
+			t.Errorf("failed to decode %s input: %v", target, err)
+		}
+		*calls = append(*calls, target)
+		*inputs = append(*inputs, in)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch target {
+		case "secretsmanager.CreateSecret":
+			if exists[in["Name"].(string)] {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"__type": "ResourceExistsException", "message": "the secret already exists"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"ARN": "arn", "Name": "name", "VersionId": "1"}`))
+		case "secretsmanager.PutSecretValue":
+			_, _ = w.Write([]byte(`{"ARN": "arn", "Name": "name", "VersionId": "2"}`))
+		case "secretsmanager.DeleteSecret":
+			_, _ = w.Write([]byte(`{"ARN": "arn", "Name": "name", "DeletionDate": 1}`))
+		case "AmazonSSM.PutParameter":
+			_, _ = w.Write([]byte(`{"Tier": "Standard", "Version": 1}`))
+		case "AmazonSSM.DeleteParameter":
+			if !exists[in["Name"].(string)] {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"__type": "ParameterNotFound", "message": "parameter not found"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected AWS call: %s", target)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func testAWSConfig(url string) *aws.Config {
+	return &aws.Config{
+		Endpoint:    aws.String(url),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}
+}
+
+func TestSecretsManagerStore(t *testing.T) {
+	var calls []string
+	var inputs []map[string]interface{}
+	ts := fakeAWS(t, &calls, &inputs, map[string]bool{"grace-paas-rds/test-prod/master-password": true})
+	defer ts.Close()
+
+	s, err := newSecretsManagerStore(testAWSConfig(ts.URL))
+	if err != nil {
+		t.Fatalf("newSecretsManagerStore() failed: unexpected error: %v", err)
+	}
+
+	err = s.put(context.Background(), "test-dev", "one")
+	if err != nil {
+		t.Fatalf("put() failed: unexpected error: %v", err)
+	}
+	err = s.put(context.Background(), "test-prod", "two")
+	if err != nil {
+		t.Fatalf("put() failed: unexpected error for existing secret: %v", err)
+	}
+
+	expected := []string{"secretsmanager.CreateSecret", "secretsmanager.CreateSecret", "secretsmanager.PutSecretValue"}
+	if len(calls) != len(expected) {
+		t.Fatalf("put() failed: expected calls: %v\nGot: %v", expected, calls)
+	}
+	for i, call := range expected {
+		if calls[i] != call {
+			t.Errorf("put() failed: expected calls: %v\nGot: %v", expected, calls)
+		}
+	}
+	if inputs[0]["Name"] != "grace-paas-rds/test-dev/master-password" || inputs[0]["SecretString"] != "one" {
+		t.Errorf("put() failed: unexpected CreateSecret input: %v", inputs[0])
+	}
+	if inputs[2]["SecretId"] != "grace-paas-rds/test-prod/master-password" || inputs[2]["SecretString"] != "two" {
+		t.Errorf("put() failed: unexpected PutSecretValue input: %v", inputs[2])
+	}
+
+	err = s.remove(context.Background(), "test-dev")
+	if err != nil {
+		t.Fatalf("remove() failed: unexpected error: %v", err)
+	}
+	if calls[3] != "secretsmanager.DeleteSecret" || inputs[3]["SecretId"] != "grace-paas-rds/test-dev/master-password" {
+		t.Errorf("remove() failed: unexpected call %s: %v", calls[3], inputs[3])
+	}
+}
+
+func TestSSMStore(t *testing.T) {
+	var calls []string
+	var inputs []map[string]interface{}
+	ts := fakeAWS(t, &calls, &inputs, map[string]bool{"/grace-paas-rds/test-dev/master-password": true})
+	defer ts.Close()
+
+	s, err := newSSMStore(testAWSConfig(ts.URL))
+	if err != nil {
+		t.Fatalf("newSSMStore() failed: unexpected error: %v", err)
+	}
+
+	err = s.put(context.Background(), "test-dev", "one")
+	if err != nil {
+		t.Fatalf("put() failed: unexpected error: %v", err)
+	}
+	if len(inputs) != 1 {
+		t.Fatalf("put() failed: expected one call, got: %v", calls)
+	}
+	in := inputs[0]
+	if in["Name"] != "/grace-paas-rds/test-dev/master-password" || in["Value"] != "one" ||
+		in["Type"] != "SecureString" || in["Overwrite"] != true {
+		t.Errorf("put() failed: unexpected PutParameter input: %v", in)
+	}
+
+	// Parameters that do not exist are already removed
+	for _, id := range []string{"test-dev", "test-prod"} {
+		err = s.remove(context.Background(), id)
+		if err != nil {
+			t.Fatalf("remove() failed: unexpected error for %s: %v", id, err)
+		}
+	}
+	if len(calls) != 3 || calls[1] != "AmazonSSM.DeleteParameter" || inputs[1]["Name"] != "/grace-paas-rds/test-dev/master-password" {
+		t.Errorf("remove() failed: unexpected calls: %v %v", calls, inputs)
+	}
+}
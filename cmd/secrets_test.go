@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretOptionsCheck(t *testing.T) {
+	oldArgs, oldEnv := captureEnv()
+	defer resetEnv(oldArgs, oldEnv)
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	tt := map[string]struct {
+		opts   secretOptions
+		dryRun bool
+		env    map[string]string
+		err    string
+	}{
+		"ci":            {opts: secretOptions{secretStore: storeCI}},
+		"circleci":      {opts: secretOptions{secretStore: storeCircleCI}},
+		"unknown":       {opts: secretOptions{secretStore: "keychain"}, err: "secret-store must be one of ci, secretsmanager, ssm, vault, file"},
+		"file no path":  {opts: secretOptions{secretStore: storeFile}, err: "secrets-file must be set if secret-store is 'file'"},
+		"vault dry run": {opts: secretOptions{secretStore: storeVault}, dryRun: true, env: map[string]string{"VAULT_ADDR": ""}},
+		"vault no token": {
+			opts: secretOptions{secretStore: storeVault},
+			env:  map[string]string{"VAULT_ADDR": "http://localhost:8200", "VAULT_TOKEN": ""},
+			err:  "environment variable VAULT_TOKEN must be set if secret-store is 'vault'",
+		},
+		"vault with env":  {opts: secretOptions{secretStore: storeVault}, env: map[string]string{"VAULT_ADDR": "http://localhost:8200", "VAULT_TOKEN": "test"}},
+		"secretsmanager":  {opts: secretOptions{secretStore: storeSecretsManager}},
+		"file with paths": {opts: secretOptions{secretStore: storeFile, secretsFile: "passwords.enc"}, dryRun: true},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			resetEnv(oldArgs, tc.env)
+			err := tc.opts.check(tc.dryRun)
+			if tc.err == "" && err != nil {
+				t.Errorf("check() failed: unexpected error: %v", err)
+			} else if tc.err != "" && (err == nil || err.Error() != tc.err) {
+				t.Errorf("check() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestCIStore(t *testing.T) {
+	var got map[string]string
+	var deleted []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const envvar = "/api/v2/project/gh/GSA/test-repo/envvar"
+		if r.Header.Get("Circle-Token") != "test" {
+			t.Errorf("unexpected Circle-Token header: %s", r.Header.Get("Circle-Token"))
+		}
+		if r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, envvar+"/") {
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, envvar+"/"))
+			_, _ = w.Write([]byte(`{"message": "ok"}`))
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != envvar {
+			t.Errorf("unexpected CircleCI request: %s %s", r.Method, r.URL)
+		}
+		err := json.NewDecoder(r.Body).Decode(&got)
+		if err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"name": "TF_VAR_test_dev_db_password", "value": "xxxx"}`))
+	}))
+	defer ts.Close()
+
+	c := defaultConfig()
+	c.CircleOrg, c.CircleCIURL = "GSA", ts.URL
+	os.Setenv("CIRCLE_TOKEN", "test")
+	defer os.Unsetenv("CIRCLE_TOKEN")
+	ci, err := newCIRunner(c, nil, "test-repo")
+	if err != nil {
+		t.Fatalf("newCIRunner() failed: unexpected error: %v", err)
+	}
+	s := &ciStore{ci: ci, runner: c.CIRunner, repo: "test-repo"}
+	if l := s.location("test-dev"); l != "CircleCI environment variable TF_VAR_test_dev_db_password in test-repo project" {
+		t.Errorf("location() failed: unexpected location: %s", l)
+	}
+	err = s.put(context.Background(), "test-dev", "secret")
+	if err != nil {
+		t.Fatalf("put() failed: unexpected error: %v", err)
+	}
+	if got["name"] != "TF_VAR_test_dev_db_password" || got["value"] != "secret" {
+		t.Errorf("put() failed: unexpected environment variable: %v", got)
+	}
+
+	err = s.remove(context.Background(), "test-dev")
+	if err != nil {
+		t.Fatalf("remove() failed: unexpected error: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "TF_VAR_test_dev_db_password" {
+		t.Errorf("remove() failed: unexpected deleted environment variables: %v", deleted)
+	}
+
+	var tf terraform
+	ref := s.reference(&tf, "test-dev")
+	if ref != "${var.test_dev_db_password}" || tf.Variable[0]["test_dev_db_password"] == nil || tf.Data != nil {
+		t.Errorf("reference() failed: unexpected reference %s or configuration %+v", ref, tf)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	s := &fileStore{path: filepath.Join(dir, "passwords.enc"), key: key}
+	for id, password := range map[string]string{"test-dev": "one", "test-prod": "two"} {
+		err = s.put(context.Background(), id, password)
+		if err != nil {
+			t.Fatalf("put() failed: unexpected error: %v", err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		t.Fatalf("put() failed: unable to read file: %v", err)
+	}
+	if strings.Contains(string(b), "test-dev") || strings.Contains(string(b), "one") {
+		t.Errorf("put() failed: file is not encrypted: %s", b)
+	}
+
+	passwords, err := s.read()
+	if err != nil {
+		t.Fatalf("read() failed: unexpected error: %v", err)
+	}
+	if passwords["test-dev"] != "one" || passwords["test-prod"] != "two" {
+		t.Errorf("read() failed: unexpected passwords: %v", passwords)
+	}
+
+	for _, id := range []string{"test-dev", "test-test"} {
+		err = s.remove(context.Background(), id)
+		if err != nil {
+			t.Fatalf("remove() failed: unexpected error: %v", err)
+		}
+	}
+	passwords, err = s.read()
+	if err != nil {
+		t.Fatalf("read() failed: unexpected error: %v", err)
+	}
+	if len(passwords) != 1 || passwords["test-prod"] != "two" {
+		t.Errorf("remove() failed: unexpected passwords: %v", passwords)
+	}
+
+	wrong := &fileStore{path: s.path, key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 32)))}
+	_, err = wrong.read()
+	if err == nil || !strings.HasPrefix(err.Error(), "unable to decrypt secrets file") {
+		t.Errorf("read() failed: expected decryption error, got: %v", err)
+	}
+
+	short := &fileStore{path: s.path, key: "c2hvcnQ="}
+	err = short.put(context.Background(), "test-dev", "one")
+	if err == nil || err.Error() != "SECRETS_FILE_KEY must be a base64 encoded 32 byte key" {
+		t.Errorf("put() failed: expected key error, got: %v", err)
+	}
+}
+
+// Tests that the data source backends generate equivalent JSON and HCL
+func TestSecretReferences(t *testing.T) {
+	var r req
+	r.inFile = filepath.Join("testdata", "test.json")
+	err := r.parseRITM()
+	if err != nil {
+		t.Fatalf("reference() failed. Unable to parse test data: %v", err)
+	}
+
+	tt := map[string]struct {
+		store    secretStore
+		password string
+		data     string
+	}{
+		"secretsmanager": {
+			store:    &secretsManagerStore{},
+			password: "${data.aws_secretsmanager_secret_version.test_dev_db_password.secret_string}",
+			data:     `"aws_secretsmanager_secret_version":{"test_dev_db_password":{"secret_id":"grace-paas-rds/test-dev/master-password"}`,
+		},
+		"ssm": {
+			store:    &ssmStore{},
+			password: "${data.aws_ssm_parameter.test_dev_db_password.value}",
+			data:     `"aws_ssm_parameter":{"test_dev_db_password":{"name":"/grace-paas-rds/test-dev/master-password","with_decryption":true}`,
+		},
+		"vault": {
+			store:    &vaultStore{mount: "kv"},
+			password: `${data.vault_generic_secret.test_dev_db_password.data["password"]}`,
+			data:     `"vault_generic_secret":{"test_dev_db_password":{"path":"kv/grace-paas-rds/test-dev"}`,
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tf, err := r.ritm.generateTerraform(testCatalog(t), tc.store)
+			if err != nil {
+				t.Fatalf("generateTerraform() failed: unexpected error: %v", err)
+			}
+			if got := tf.Module["test_dev"].Password; got != tc.password {
+				t.Errorf("reference() failed: expected password: %s\nGot: %s", tc.password, got)
+			}
+			if got := tf.Resource[0].SSMParameter["test_dev_password"].Value; got != tc.password {
+				t.Errorf("reference() failed: expected SSM parameter value: %s\nGot: %s", tc.password, got)
+			}
+			for _, vars := range tf.Variable {
+				if _, ok := vars["test_dev_db_password"]; ok {
+					t.Errorf("reference() failed: password variable generated for %s", name)
+				}
+			}
+
+			b, err := json.Marshal(tf.Data)
+			if err != nil {
+				t.Fatalf("unable to marshal data sources: %v", err)
+			}
+			if !strings.Contains(string(b), tc.data) {
+				t.Errorf("reference() failed: expected data source: %s\nGot: %s", tc.data, b)
+			}
+
+			hcl, err := tf.hcl()
+			if err != nil {
+				t.Fatalf("hcl() failed: unexpected error: %v", err)
+			}
+			expr := strings.TrimSuffix(strings.TrimPrefix(tc.password, "${"), "}")
+			if !strings.Contains(string(hcl), "password") || !strings.Contains(string(hcl), expr) {
+				t.Errorf("hcl() failed: expected password reference %s in:\n%s", expr, hcl)
+			}
+		})
+	}
+}
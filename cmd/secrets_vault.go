@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const vaultTimeout = 30 * time.Second // Vault HTTP API request timeout
+
+// vaultStore stores the passwords in a HashiCorp Vault KV version 2 secrets
+// engine, under the password key
+type vaultStore struct {
+	addr  string
+	token string
+	mount string
+}
+
+// path is the secret path, relative to the mount
+func (s *vaultStore) path(id string) string {
+	return "grace-paas-rds/" + id
+}
+
+// put writes a new version of the secret with the KV v2 HTTP API
+func (s *vaultStore) put(ctx context.Context, id, password string) error {
+	fmt.Printf("Writing Vault secret %s/%s\n", s.mount, s.path(id))
+	body, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{"password": password},
+	})
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, "write", http.MethodPost, "/data/", id, body)
+}
+
+// remove deletes every version of the secret and its metadata
+func (s *vaultStore) remove(ctx context.Context, id string) error {
+	fmt.Printf("Deleting Vault secret %s/%s\n", s.mount, s.path(id))
+	return s.do(ctx, "delete", http.MethodDelete, "/metadata/", id, nil)
+}
+
+// do sends a request for the identifier's secret to the KV v2 HTTP API, under
+// the data or metadata prefix
+func (s *vaultStore) do(ctx context.Context, op, method, prefix, id string, body []byte) error {
+	url := strings.TrimSuffix(s.addr, "/") + "/v1/" + s.mount + prefix + s.path(id)
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", s.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: vaultTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&vaultErr) // Best effort, the status is reported regardless
+		return fmt.Errorf("vault %s %s/%s failed: %s %s", op, s.mount, s.path(id), resp.Status, strings.Join(vaultErr.Errors, "; "))
+	}
+	return nil
+}
+
+func (s *vaultStore) location(id string) string {
+	return fmt.Sprintf("Vault secret %s/%s (key password)", s.mount, s.path(id))
+}
+
+func (s *vaultStore) reference(tf *terraform, id string) string {
+	name := resourceName(id) + "_db_password"
+	data := tf.data()
+	if data.VaultGenericSecret == nil {
+		data.VaultGenericSecret = map[string]*vaultSecretData{}
+	}
+	data.VaultGenericSecret[name] = &vaultSecretData{Path: s.mount + "/" + s.path(id)}
+	return "${data.vault_generic_secret." + name + ".data[\"password\"]}"
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeVault emulates the KV version 2 write and delete APIs of a Vault dev
+// server
+func fakeVault(t *testing.T, secrets map[string]map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		if r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/") {
+			delete(secrets, strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/v1/secret/data/") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors": []}`))
+			return
+		}
+
+		var body struct {
+			Data map[string]string `json:"data"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Errorf("failed to decode secret: %v", err)
+		}
+		secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")] = body.Data
+		_, _ = w.Write([]byte(`{"data": {"version": 1}}`))
+	}))
+}
+
+func TestVaultStore(t *testing.T) {
+	secrets := map[string]map[string]string{}
+	ts := fakeVault(t, secrets)
+	defer ts.Close()
+
+	s := &vaultStore{addr: ts.URL, token: "root", mount: "secret"}
+	err := s.put(context.Background(), "test-dev", "one")
+	if err != nil {
+		t.Fatalf("put() failed: unexpected error: %v", err)
+	}
+	if secrets["grace-paas-rds/test-dev"]["password"] != "one" {
+		t.Errorf("put() failed: unexpected secrets: %v", secrets)
+	}
+
+	err = s.remove(context.Background(), "test-dev")
+	if err != nil {
+		t.Fatalf("remove() failed: unexpected error: %v", err)
+	}
+	if _, ok := secrets["grace-paas-rds/test-dev"]; ok {
+		t.Errorf("remove() failed: secret not deleted: %v", secrets)
+	}
+
+	s.token = "wrong"
+	err = s.put(context.Background(), "test-dev", "two")
+	expected := "vault write secret/grace-paas-rds/test-dev failed: 403 Forbidden permission denied"
+	if err == nil || err.Error() != expected {
+		t.Errorf("put() failed: expected error: %s\nGot: %v", expected, err)
+	}
+	err = s.remove(context.Background(), "test-dev")
+	expected = "vault delete secret/grace-paas-rds/test-dev failed: 403 Forbidden permission denied"
+	if err == nil || err.Error() != expected {
+		t.Errorf("remove() failed: expected error: %s\nGot: %v", expected, err)
+	}
+
+	s = &vaultStore{addr: ts.URL, token: "root", mount: "kv"}
+	err = s.put(context.Background(), "test-dev", "one")
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found") {
+		t.Errorf("put() failed: expected not found error, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.put(ctx, "test-dev", "one")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("put() failed: expected canceled error, got: %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// server polls ServiceNow for open RDS RITMs and runs each one through the
+// terraform pipeline
+type server struct {
+	catalog     *catalog
+	catalogFile string
+	config      *config
+	configOptions
+	concurrency int
+	format      string
+	interval    time.Duration
+	once        bool
+	repoName    string
+	snowClient  *snowClient
+	stateDir    string
+	secretOptions
+	timeoutOptions
+	webhookAddr string // listen address of the webhook receiver, empty to only poll
+
+	ctx    context.Context // cancelled when the server is stopping, interrupting the running RITMs
+	merges *mergeEvents
+	wake   chan struct{} // polls ServiceNow before the interval has passed
+
+	process func(item openRITM) // runs the pipeline for a RITM
+	mu      sync.Mutex
+	running map[string]bool // sys_ids queued or being processed
+	sem     chan struct{}
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// serve runs the serve (or watch) subcommand until it is signalled to stop
+func serve(progName string, args []string) error {
+	s, err := newServer(progName, args)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(stop)
+
+	return s.run(stop)
+}
+
+func newServer(progName string, args []string) (*server, error) {
+	s := &server{running: map[string]bool{}, quit: make(chan struct{}), wake: make(chan struct{}, 1)}
+	flags := flag.NewFlagSet(progName, flag.ContinueOnError)
+	var buf bytes.Buffer
+	flags.SetOutput(&buf)
+	flags.StringVar(&s.repoName, "repo", "", "Repo name")
+	flags.StringVar(&s.format, "format", tfConst, "Output file format: terraform or hcl")
+	flags.StringVar(&s.catalogFile, "catalog", "", "Engine catalog file (YAML or JSON), defaults to the built-in catalog")
+	flags.StringVar(&s.stateDir, "state-dir", filepath.Join(os.TempDir(), "grace-paas-rds"),
+		"Directory for the terraform pipeline state files")
+	flags.IntVar(&s.concurrency, "concurrency", 2, "Maximum number of RITMs processed at the same time")
+	flags.DurationVar(&s.interval, "interval", 5*time.Minute, "How often to poll ServiceNow for open RITMs")
+	flags.BoolVar(&s.once, "once", false, "Poll once, process the open RITMs and exit")
+	flags.StringVar(&s.webhookAddr, "webhook-addr", "",
+		"Address to receive GitHub pull_request webhooks on, such as :8080, with the secret in "+envWebhookSecret)
+	s.secretOptions.addFlags(flags)
+	s.configOptions.addFlags(flags)
+	s.timeoutOptions.addFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		fmt.Println(buf.String())
+		return nil, err
+	}
+
+	s.config, err = s.loadConfig(s.repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.check()
+	if err != nil {
+		flags.PrintDefaults()
+		return nil, err
+	}
+
+	s.catalog, err = loadCatalog(s.catalogFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s.snowClient = newSnowClient(newRetryPolicy(s.config.MaxAttempts))
+	s.sem = make(chan struct{}, s.concurrency)
+	s.process = s.processRITM
+	return s, nil
+}
+
+func (s *server) check() error {
+	if s.format != tfConst && s.format != hclConst {
+		return fmt.Errorf("format must be terraform or hcl")
+	}
+	if s.repoName == "" {
+		return fmt.Errorf("reponame must be set")
+	}
+	if s.concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+	if s.interval <= 0 {
+		return fmt.Errorf("interval must be greater than 0")
+	}
+	if s.webhookAddr != "" && s.config.CodeHost != gitHubHost {
+		return fmt.Errorf("webhook-addr needs code_host %s", gitHubHost)
+	}
+	if s.webhookAddr != "" && os.Getenv(envWebhookSecret) == "" {
+		return fmt.Errorf("environment variable %s must be set if webhook-addr is set", envWebhookSecret)
+	}
+
+	for _, name := range append(s.config.tokenEnvs(), "SN_INSTANCE", "SN_PASSWORD", "SN_USER") {
+		if os.Getenv(name) == "" {
+			return fmt.Errorf("environment variable %s must be set", name)
+		}
+	}
+	err := s.timeoutOptions.check()
+	if err != nil {
+		return err
+	}
+	return s.secretOptions.check(false)
+}
+
+// run polls ServiceNow every interval until stop receives a signal, then
+// interrupts the running RITMs at their current stage and waits for them to
+// record it. A second signal stops waiting; the interrupted RITMs are resumed
+// from their state files on the next start.
+func (s *server) run(stop <-chan os.Signal) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.ctx = ctx
+
+	if s.webhookAddr != "" {
+		srv, err := s.listen()
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+	}
+
+	fmt.Printf("Polling ServiceNow every %s for open RITMs\n", s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+poll:
+	for {
+		err := s.poll()
+		if err != nil {
+			fmt.Printf("Polling ServiceNow failed: %v\n", err)
+		}
+		if s.once {
+			break
+		}
+
+		select {
+		case sig := <-stop:
+			fmt.Printf("Received %s, interrupting the running RITMs\n", sig)
+			close(s.quit) // Queued RITMs are left for the next start
+			cancel()
+			break poll
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		fmt.Println("Stopped")
+		return nil
+	case sig := <-stop:
+		return fmt.Errorf("received %s, stopped with RITMs still running", sig)
+	}
+}
+
+// listen starts the webhook receiver, which wakes the RITMs waiting for the
+// closed pull requests, or polls ServiceNow to resume them if none is waiting
+func (s *server) listen() (*http.Server, error) {
+	s.merges = newMergeEvents()
+	l, err := net.Listen("tcp", s.webhookAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{
+		Handler: &webhook{
+			secret: []byte(os.Getenv(envWebhookSecret)),
+			owner:  s.config.Owner,
+			repo:   s.repoName,
+			events: s.merges,
+			resume: func() {
+				select {
+				case s.wake <- struct{}{}:
+				default: // A poll is already due
+				}
+			},
+		},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("Receiving GitHub webhooks on %s\n", l.Addr())
+	go func() {
+		err := srv.Serve(l)
+		if err != http.ErrServerClosed {
+			fmt.Printf("Receiving GitHub webhooks failed: %v\n", err)
+		}
+	}()
+	return srv, nil
+}
+
+// poll queues the open RITMs that are not already queued or being processed
+func (s *server) poll() error {
+	items, err := openRITMs(s.ctx, s.snowClient)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if !s.claim(item.SysID) {
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.worker(item)
+	}
+	return nil
+}
+
+// worker processes the RITM once a concurrency slot is free, unless the
+// server is stopping
+func (s *server) worker(item openRITM) {
+	defer s.wg.Done()
+	defer s.release(item.SysID)
+
+	select {
+	case s.sem <- struct{}{}:
+	case <-s.quit:
+		return
+	}
+	defer func() { <-s.sem }()
+
+	select {
+	case <-s.quit:
+		return
+	default:
+	}
+
+	fmt.Printf("Processing %s\n", item.Number)
+	s.process(item)
+}
+
+// claim marks the RITM as queued, returning false if it already is
+func (s *server) claim(sysID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[sysID] {
+		return false
+	}
+	s.running[sysID] = true
+	return true
+}
+
+func (s *server) release(sysID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, sysID)
+}
+
+// processRITM runs the RITM through the terraform pipeline, resuming from
+// its state file, and posts any error to the RITM
+func (s *server) processRITM(item openRITM) {
+	r := &req{
+		catalog:        s.catalog,
+		config:         s.config,
+		ctx:            s.ctx,
+		format:         s.format,
+		merges:         s.merges,
+		timeoutOptions: s.timeoutOptions,
+		repoName:       s.repoName,
+		resume:         true,
+		secretOptions:  s.secretOptions,
+		stateDir:       s.stateDir,
+		sysID:          item.SysID,
+	}
+
+	err := r.init()
+	if err == nil && r.state.done(stageRITM) {
+		fmt.Printf("Skipping %s, it has already been provisioned\n", item.Number)
+		return
+	}
+	if err == nil {
+		err = r.runPipeline()
+	}
+	if err != nil && s.ctx.Err() != nil {
+		fmt.Printf("Processing interrupted: %s, it will be resumed on the next start: %v\n", item.Number, err)
+		r.commentInterrupted(err, "Provisioning")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Processing failed: %s: %v\n", item.Number, err)
+		r.reportErr(err)
+		return
+	}
+
+	fmt.Printf("Processing complete: %s\n", item.Number)
+}
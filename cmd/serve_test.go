@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testServer returns a server polling the fake ServiceNow that calls process
+// for each RITM instead of running the pipeline
+func testServer(url string, concurrency int, process func(openRITM)) *server {
+	return &server{
+		concurrency: concurrency,
+		ctx:         context.Background(),
+		interval:    time.Hour,
+		snowClient:  testSnowClient(url, "pass"),
+		process:     process,
+		running:     map[string]bool{},
+		sem:         make(chan struct{}, concurrency),
+		quit:        make(chan struct{}),
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	oldArgs, oldEnv := captureEnv()
+	defer resetEnv(oldArgs, oldEnv)
+	env := map[string]string{
+		"CIRCLE_TOKEN": "test",
+		"GITHUB_TOKEN": "test",
+		"SN_INSTANCE":  "test",
+		"SN_PASSWORD":  "test",
+		"SN_USER":      "test",
+	}
+
+	tt := map[string]struct {
+		args []string
+		env  map[string]string
+		err  string
+	}{
+		"happy":          {args: []string{"-repo", "test", "-concurrency", "4"}, env: env},
+		"no repo":        {args: []string{}, env: env, err: "reponame must be set"},
+		"json format":    {args: []string{"-repo", "test", "-format", "json"}, env: env, err: "format must be terraform or hcl"},
+		"no concurrency": {args: []string{"-repo", "test", "-concurrency", "0"}, env: env, err: "concurrency must be at least 1"},
+		"negative merge timeout": {
+			args: []string{"-repo", "test", "-merge-timeout", "-1h"},
+			env:  env,
+			err:  "merge-timeout must not be negative",
+		},
+		"webhook no secret": {
+			args: []string{"-repo", "test", "-webhook-addr", ":8080"},
+			env:  env,
+			err:  "environment variable GITHUB_WEBHOOK_SECRET must be set if webhook-addr is set",
+		},
+		"SN_USER not set": {
+			args: []string{"-repo", "test"},
+			env: map[string]string{
+				"CIRCLE_TOKEN": "test",
+				"GITHUB_TOKEN": "test",
+				"SN_INSTANCE":  "test",
+				"SN_PASSWORD":  "test",
+				"SN_USER":      "",
+			},
+			err: "environment variable SN_USER must be set",
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			resetEnv(oldArgs, tc.env)
+			s, err := newServer("serve", tc.args)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("newServer() failed: unexpected error: %v", err)
+				}
+				if cap(s.sem) != 4 || s.format != tfConst || s.catalog == nil {
+					t.Errorf("newServer() failed: unexpected server: %+v", s)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("newServer() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestServeOnce(t *testing.T) {
+	ts := fakeSnow(t)
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var processed []string
+	var active, maxActive int
+	s := testServer(ts.URL, 2, func(item openRITM) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		processed = append(processed, item.Number)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	})
+	s.once = true
+
+	err := s.run(make(chan os.Signal))
+	if err != nil {
+		t.Fatalf("run() failed: unexpected error: %v", err)
+	}
+
+	sort.Strings(processed)
+	expected := "RITM0001001 RITM0001002 RITM0001003"
+	if got := strings.Join(processed, " "); got != expected {
+		t.Errorf("run() failed: expected: %s\ngot: %s", expected, got)
+	}
+	if maxActive > 2 {
+		t.Errorf("run() failed: %d RITMs processed at once, limit is 2", maxActive)
+	}
+	if len(s.running) != 0 {
+		t.Errorf("run() failed: RITMs still marked as running: %v", s.running)
+	}
+}
+
+// Tests that a RITM still being processed is not queued again by the next poll
+// and that queued RITMs are not started once the server is stopping
+func TestServeStop(t *testing.T) {
+	ts := fakeSnow(t)
+	defer ts.Close()
+
+	started := make(chan string, 3)
+	finish := make(chan struct{})
+	s := testServer(ts.URL, 1, func(item openRITM) {
+		started <- item.Number
+		<-finish
+	})
+
+	err := s.poll()
+	if err != nil {
+		t.Fatalf("poll() failed: unexpected error: %v", err)
+	}
+	first := <-started
+
+	err = s.poll()
+	if err != nil {
+		t.Fatalf("poll() failed: unexpected error: %v", err)
+	}
+	if len(s.running) != 3 {
+		t.Errorf("poll() failed: expected 3 queued RITMs, got: %v", s.running)
+	}
+
+	stop := make(chan os.Signal, 1)
+	stop <- syscall.SIGTERM
+	s.interval = time.Millisecond
+	s.wg.Add(1) // Keeps run waiting until the first RITM is finished
+	go func() {
+		defer s.wg.Done()
+		<-s.quit
+		close(finish)
+	}()
+
+	err = s.run(stop)
+	if err != nil {
+		t.Fatalf("run() failed: unexpected error: %v", err)
+	}
+	close(started)
+	for n := range started {
+		t.Errorf("run() failed: %s started after %s while stopping", n, first)
+	}
+}
@@ -1,34 +1,297 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/andrewstuart/servicenow"
 )
 
-func newSnowClient() *servicenow.Client {
-	return &servicenow.Client{
-		Username: os.Getenv("SN_USER"),
-		Password: os.Getenv("SN_PASSWORD"),
-		Instance: os.Getenv("SN_INSTANCE"),
+const (
+	snowTimeout     = 30 * time.Second // ServiceNow REST API request timeout
+	catalogItemName = "GRACE-PaaS AWS RDS Provisioning Request"
+)
+
+// snowClient calls the REST APIs of a ServiceNow instance
+type snowClient struct {
+	servicenow.Client // instance and credentials
+	client            *http.Client
+}
+
+func newSnowClient(policy retryPolicy) *snowClient {
+	return &snowClient{
+		Client: servicenow.Client{
+			Username: os.Getenv("SN_USER"),
+			Password: os.Getenv("SN_PASSWORD"),
+			Instance: os.Getenv("SN_INSTANCE"),
+		},
+		client: newHTTPClient(snowTimeout, policy),
+	}
+}
+
+// instanceURL returns the URL of the ServiceNow instance, which may be given
+// as a host name
+func instanceURL(c *snowClient) string {
+	if !strings.HasPrefix(c.Instance, "http://") && !strings.HasPrefix(c.Instance, "https://") {
+		return "https://" + c.Instance
+	}
+	return c.Instance
+}
+
+// tableGet queries a table with the ServiceNow REST Table API and decodes the
+// result records into out
+func tableGet(ctx context.Context, c *snowClient, table string, params url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, instanceURL(c)+"/api/now/table/"+table+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ServiceNow %s query failed: %s", table, resp.Status)
+	}
+
+	result := struct {
+		Result interface{} `json:"result"`
+	}{Result: out}
+	return json.NewDecoder(resp.Body).Decode(&result)
+}
+
+// tableUpdate updates a record with the JSONv2 web service, as the
+// servicenow client does, but with the context. The update only sets fields,
+// so it is retried, although a retry after an update that took effect
+// repeats its comment.
+func tableUpdate(ctx context.Context, c *snowClient, table, sysID string, fields map[string]interface{}) error {
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
 	}
+	params := url.Values{"JSONv2": {""}, "sysparm_action": {"update"}, "sysparm_sys_id": {sysID}, "displayvalue": {"true"}}
+	u := instanceURL(c) + "/" + table + ".do?" + params.Encode()
+	req, err := http.NewRequestWithContext(idempotent(ctx), http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Error string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("ServiceNow %s update failed: %s", table, resp.Status)
+	case err != nil:
+		return err
+	case result.Error != "":
+		return fmt.Errorf("ServiceNow %s update failed: %s", table, result.Error)
+	}
+	return nil
+}
+
+// fetchRITM reads the sc_req_item record and its catalog variables from
+// ServiceNow, returning them as the JSON of a RITM export
+func (r *req) fetchRITM() ([]byte, error) {
+	query := "number=" + r.ritmNumber
+	if r.sysID != "" {
+		query = "sys_id=" + r.sysID
+	}
+	fmt.Printf("Fetching RITM from ServiceNow: %s\n", query)
+
+	var items []map[string]interface{}
+	err := tableGet(r.context(), r.snowClient, "sc_req_item", url.Values{
+		"sysparm_query":                  {query},
+		"sysparm_display_value":          {"true"},
+		"sysparm_exclude_reference_link": {"true"},
+		"sysparm_fields":                 {"number,sys_id,cat_item,opened_by,requested_for,comments"},
+		"sysparm_limit":                  {"1"},
+	}, &items)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("RITM not found in ServiceNow: %s", query)
+	}
+
+	item := items[0]
+	item["cat_item_name"] = item["cat_item"]
+	delete(item, "cat_item")
+
+	var options []map[string]string
+	err = tableGet(r.context(), r.snowClient, "sc_item_option_mtom", url.Values{
+		"sysparm_query":  {fmt.Sprintf("request_item=%v", item["sys_id"])},
+		"sysparm_fields": {"sc_item_option.item_option_new.name,sc_item_option.value"},
+	}, &options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Catalog variables are named the same as the fields of a RITM export
+	for _, o := range options {
+		if name := o["sc_item_option.item_option_new.name"]; name != "" {
+			item[name] = o["sc_item_option.value"]
+		}
+	}
+
+	return json.Marshal(item)
 }
 
-func (r *req) updateRITM(e error) error {
+// openRITM is an open RDS provisioning request returned by openRITMs
+type openRITM struct {
+	Number string `json:"number"`
+	SysID  string `json:"sys_id"`
+}
+
+// openRITMs returns the open RITMs for the RDS provisioning catalog item,
+// oldest first
+func openRITMs(ctx context.Context, c *snowClient) ([]openRITM, error) {
+	var items []openRITM
+	err := tableGet(ctx, c, "sc_req_item", url.Values{
+		"sysparm_query":  {"active=true^state=1^cat_item.name=" + catalogItemName + "^ORDERBYsys_created_on"},
+		"sysparm_fields": {"number,sys_id"},
+	}, &items)
+	return items, err
+}
+
+func (r *req) updateRITM(ctx context.Context, e error) error {
 	fmt.Printf("Updating %s (%s)\n", r.ritm.Number, r.ritm.SysID)
-	table := "sc_req_item"
-	var out map[string]interface{}
-	var state = 2 // Work in Progress
-	var comment = "RDS Provisioned via GRACE-PaaS CI/CD Pipeline"
-	if e != nil {
-		state = 8 // Reopened
-		comment = fmt.Sprintf("Error provisioning RDS: %v", e)
+	return tableUpdate(ctx, r.snowClient, "sc_req_item", r.ritm.SysID, r.ritmUpdate(e))
+}
+
+// noteRITM records the progress of the pipeline on the RITM, if it was read.
+// The comment tells the requester which milestone was reached, while the
+// internal work note has the details, such as links. Either can be empty. A
+// failed update is printed rather than failing the pipeline.
+func (r *req) noteRITM(comment, note string) {
+	if r.ritm == nil || r.snowClient == nil {
+		return
+	}
+	fields := map[string]interface{}{}
+	if comment != "" {
+		fields["comments"] = comment
+	}
+	if note != "" {
+		fields["work_notes"] = note
 	}
-	body := map[string]interface{}{
+	if len(fields) == 0 {
+		return
+	}
+	fmt.Printf("Adding progress to %s (%s): %s\n", r.ritm.Number, r.ritm.SysID, note)
+	err := tableUpdate(r.context(), r.snowClient, "sc_req_item", r.ritm.SysID, fields)
+	if err != nil {
+		fmt.Printf("Unable to update RITM: %v\n", err)
+	}
+}
+
+// commentRITM adds a comment to the RITM without changing its state
+func (r *req) commentRITM(ctx context.Context, comment string) error {
+	fmt.Printf("Commenting on %s (%s)\n", r.ritm.Number, r.ritm.SysID)
+	return tableUpdate(ctx, r.snowClient, "sc_req_item", r.ritm.SysID, map[string]interface{}{
+		"comments": comment,
+	})
+}
+
+// setRITMState changes the state of any RITM, commenting on it
+func setRITMState(ctx context.Context, c *snowClient, ritm *ritm, state int, comment string) error {
+	fmt.Printf("Setting %s (%s) to %s\n", ritm.Number, ritm.SysID, ritmStateName(state))
+	return tableUpdate(ctx, c, "sc_req_item", ritm.SysID, map[string]interface{}{
 		"state":    state,
 		"comments": comment,
+	})
+}
+
+// reopenRITM reopens any RITM for the error of the work, such as
+// "decommissioning RDS"
+func reopenRITM(ctx context.Context, c *snowClient, ritm *ritm, work string, e error) error {
+	fmt.Printf("Setting %s (%s) to %s\n", ritm.Number, ritm.SysID, ritmStateName(8))
+	return tableUpdate(ctx, c, "sc_req_item", ritm.SysID, reopenUpdate(work, e))
+}
+
+// reopenUpdate returns the Reopened state, comment and work note for the
+// error of the work
+func reopenUpdate(work string, e error) map[string]interface{} {
+	update := errorUpdate(work, "the request has been reopened for review", e)
+	update["state"] = 8 // Reopened
+	return update
+}
+
+// errorUpdate returns the comment and work note for the error of the work.
+// The error is only detailed in the work note, as it can name internal
+// repositories and APIs, unless the requester has to correct the RITM.
+// Otherwise the comment ends with the generic next step.
+func errorUpdate(work, next string, e error) map[string]interface{} {
+	comment := fmt.Sprintf("Error %s, %s", work, next)
+	var invalid validationError
+	if errors.As(e, &invalid) {
+		comment = fmt.Sprintf("Error %s: %v", work, invalid)
+	}
+	return map[string]interface{}{
+		"comments":   comment,
+		"work_notes": fmt.Sprintf("Error %s: %v", work, e),
+	}
+}
+
+// ritmUpdate returns the RITM state, comment and work note for the
+// provisioning result. A provisioned RITM is left in the configured state.
+func (r *req) ritmUpdate(e error) map[string]interface{} {
+	if e != nil {
+		return reopenUpdate("provisioning RDS", e)
 	}
 
-	return r.snowClient.PerformFor(table, "update", r.ritm.SysID, nil, body, &out)
+	state, _ := provisionedState(r.config.ProvisionedState) // Checked when the configuration is loaded
+	var ids []string
+	for _, env := range r.ritm.environments() {
+		ids = append(ids, fmt.Sprintf("%s (%s)", env.identifier(r.ritm), env.name))
+	}
+	return map[string]interface{}{
+		"state":      state,
+		"comments":   "RDS Provisioned via GRACE-PaaS CI/CD Pipeline",
+		"work_notes": "RDS instances provisioned: " + strings.Join(ids, ", "),
+	}
+}
+
+// provisionedState returns the RITM state of the provisioned_state setting
+func provisionedState(name string) (int, bool) {
+	switch name {
+	case "work_in_progress":
+		return 2, true
+	case "closed_complete":
+		return 3, true
+	}
+	return 0, false
+}
+
+// ritmStateName returns the display name of a RITM state
+func ritmStateName(state int) string {
+	switch state {
+	case 2:
+		return "Work in Progress"
+	case 3:
+		return "Closed Complete"
+	case 8:
+		return "Reopened"
+	}
+	return "Unknown"
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andrewstuart/servicenow"
+)
+
+const testSysID = "0123456789abcdef0123456789abcdef"
+
+// snowFake is a fake ServiceNow instance recording the RITM updates
+type snowFake struct {
+	*httptest.Server
+	mu      sync.Mutex
+	updates []map[string]interface{}
+}
+
+// fakeSnow serves the sc_req_item and sc_item_option_mtom tables
+func fakeSnow(t *testing.T) *snowFake {
+	f := &snowFake{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/sc_req_item.do" {
+			f.update(t, w, r)
+			return
+		}
+
+		var result interface{}
+		query := r.URL.Query().Get("sysparm_query")
+		switch {
+		case r.URL.Path == "/api/now/table/sc_req_item" && (query == "number=RITM0001001" || query == "sys_id="+testSysID):
+			result = []map[string]string{{
+				"number":        "RITM0001001",
+				"sys_id":        testSysID,
+				"cat_item":      "GRACE-PaaS AWS RDS Provisioning Request",
+				"opened_by":     "user",
+				"requested_for": "user",
+			}}
+		case r.URL.Path == "/api/now/table/sc_req_item" && strings.HasPrefix(query, "active=true^state=1^"):
+			result = []map[string]string{
+				{"number": "RITM0001001", "sys_id": testSysID},
+				{"number": "RITM0001002", "sys_id": "fedcba9876543210fedcba9876543210"},
+				{"number": "RITM0001003", "sys_id": "00000000000000000000000000000003"},
+			}
+		case r.URL.Path == "/api/now/table/sc_req_item":
+			result = []map[string]string{}
+		case r.URL.Path == "/api/now/table/sc_item_option_mtom" && query == "request_item="+testSysID:
+			result = []map[string]string{
+				{"sc_item_option.item_option_new.name": "identifier", "sc_item_option.value": "test"},
+				{"sc_item_option.item_option_new.name": "engine", "sc_item_option.value": "postgres12"},
+				{"sc_item_option.item_option_new.name": "development_count", "sc_item_option.value": "1"},
+				{"sc_item_option.item_option_new.name": "", "sc_item_option.value": "ignored"},
+			}
+		default:
+			t.Errorf("unexpected ServiceNow request: %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+		if err != nil {
+			t.Errorf("failed to encode response: %v", err)
+		}
+	}))
+	return f
+}
+
+// update records a legacy JSONv2 sc_req_item update
+func (f *snowFake) update(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.Method != http.MethodPost || q.Get("sysparm_action") != "update" || q.Get("sysparm_sys_id") == "" {
+		t.Errorf("unexpected ServiceNow update: %s %s", r.Method, r.URL)
+	}
+
+	var body map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		t.Errorf("failed to decode update: %v", err)
+	}
+	body["sys_id"] = q.Get("sysparm_sys_id")
+
+	f.mu.Lock()
+	f.updates = append(f.updates, body)
+	f.mu.Unlock()
+
+	_, err = w.Write([]byte(`{"records": []}`))
+	if err != nil {
+		t.Errorf("failed to write response: %v", err)
+	}
+}
+
+// client returns a ServiceNow client for the fake instance
+func (f *snowFake) client() *snowClient {
+	return testSnowClient(f.URL, "pass")
+}
+
+// testSnowClient returns a ServiceNow client for the instance that does not
+// retry
+func testSnowClient(instance, password string) *snowClient {
+	return &snowClient{
+		Client: servicenow.Client{Instance: instance, Username: "user", Password: password},
+		client: newHTTPClient(snowTimeout, retryPolicy{}),
+	}
+}
+
+func TestNoteRITM(t *testing.T) {
+	snow := fakeSnow(t)
+	defer snow.Close()
+
+	r := &req{ritm: &ritm{Number: "RITM0001001", SysID: testSysID}}
+	r.noteRITM("not sent", "without a client")
+	r.snowClient = snow.client()
+	r.noteRITM("The request has been approved", "Pull request merged")
+	if len(snow.updates) != 1 || snow.updates[0]["comments"] != "The request has been approved" ||
+		snow.updates[0]["work_notes"] != "Pull request merged" {
+		t.Errorf("noteRITM() failed: expected a comment and work note, got: %v", snow.updates)
+	}
+
+	snow.updates = nil
+	r.noteRITM("", "Terraform apply finished")
+	r.noteRITM("", "")
+	if len(snow.updates) != 1 || snow.updates[0]["comments"] != nil || snow.updates[0]["work_notes"] != "Terraform apply finished" {
+		t.Errorf("noteRITM() failed: expected a single work note, got: %v", snow.updates)
+	}
+}
+
+func TestRITMUpdate(t *testing.T) {
+	r := &req{config: &config{ProvisionedState: "closed_complete"}}
+	r.inFile = filepath.Join("testdata", "test.json")
+	err := r.parseRITM()
+	if err != nil {
+		t.Fatalf("parseRITM() failed: unexpected error: %v", err)
+	}
+
+	update := r.ritmUpdate(nil)
+	if update["state"] != 3 || update["comments"] != "RDS Provisioned via GRACE-PaaS CI/CD Pipeline" ||
+		update["work_notes"] != "RDS instances provisioned: test-dev (development), test-test (test), test-prod (production)" {
+		t.Errorf("ritmUpdate() failed: unexpected update: %v", update)
+	}
+
+	tt := map[string]struct {
+		err     error
+		comment string
+	}{
+		"internal": {
+			err:     fmt.Errorf("waiting for apply failed: %w", fmt.Errorf("CircleCI GET project/gh/GSA/infra/job/42 failed")),
+			comment: "Error provisioning RDS, the request has been reopened for review",
+		},
+		"invalid": {
+			err:     fmt.Errorf("parsing failed: %w", validationError{{Field: "name", Message: "is required"}}),
+			comment: "Error provisioning RDS: invalid RITM (1 errors): name: is required",
+		},
+	}
+	for name, tc := range tt {
+		update = r.ritmUpdate(tc.err)
+		if update["state"] != 8 || update["comments"] != tc.comment || update["work_notes"] != "Error provisioning RDS: "+tc.err.Error() {
+			t.Errorf("ritmUpdate() failed: %s: unexpected error update: %v", name, update)
+		}
+	}
+}
+
+func TestFetchRITM(t *testing.T) {
+	ts := fakeSnow(t)
+	defer ts.Close()
+
+	tt := map[string]struct {
+		number string
+		sysID  string
+		pass   string
+		err    string
+	}{
+		"by number": {number: "RITM0001001", pass: "pass"},
+		"by sys_id": {sysID: testSysID, pass: "pass"},
+		"not found": {number: "RITM0009999", pass: "pass", err: "RITM not found in ServiceNow: number=RITM0009999"},
+		"bad login": {number: "RITM0001001", pass: "wrong", err: "ServiceNow sc_req_item query failed: 401 Unauthorized"},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			r := req{
+				ritmNumber: tc.number,
+				sysID:      tc.sysID,
+				snowClient: testSnowClient(ts.URL, tc.pass),
+			}
+			err := r.parseRITM()
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("parseRITM() failed: expected error: %s\nGot: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRITM() failed: unexpected error: %v", err)
+			}
+
+			got := fmt.Sprintf("%s %s %s %s %s %s", r.ritm.Number, r.ritm.SysID, r.ritm.CatalogItemName,
+				r.ritm.Identifier, r.ritm.Engine, r.ritm.DevCount)
+			expected := "RITM0001001 " + testSysID + " GRACE-PaaS AWS RDS Provisioning Request test postgres12 1"
+			if got != expected {
+				t.Errorf("parseRITM() failed: expected: %s\ngot: %s", expected, got)
+			}
+			if r.reqMap["identifier"] != "test" {
+				t.Errorf("parseRITM() failed: expected identifier in reqMap, got: %v", r.reqMap)
+			}
+		})
+	}
+}
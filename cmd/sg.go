@@ -1,32 +1,80 @@
 package main
 
-func (tf *terraform) securityGroup(resourceID, id string, port int) map[string]interface{} {
-	return map[string]interface{}{
-		"name":        id + "-SG",
-		"description": "Allow RDS inboud traffic",
-		"vpc_id":      "${module.network.back_vpc_id}",
-		"ingress": [...]map[string]interface{}{
+import "encoding/json"
+
+// securityGroup is an aws_security_group resource
+type securityGroup struct {
+	Description string    `json:"description"`
+	Ingress     []ingress `json:"ingress"`
+	Name        string    `json:"name"`
+	VPCID       string    `json:"vpc_id"`
+}
+
+// ingress is an aws_security_group ingress rule
+type ingress struct {
+	CIDRBlocks     listExpr `json:"cidr_blocks"`
+	Description    string   `json:"description"`
+	FromPort       int      `json:"from_port"`
+	IPv6CIDRBlocks []string `json:"ipv6_cidr_blocks"`
+	PrefixListIDs  []string `json:"prefix_list_ids"`
+	Protocol       string   `json:"protocol"`
+	SecurityGroups []string `json:"security_groups"`
+	Self           bool     `json:"self"`
+	ToPort         int      `json:"to_port"`
+}
+
+// listExpr is a list attribute given either as literal values or as a
+// reference to a list, such as a variable
+type listExpr struct {
+	Values []string
+	Ref    string
+}
+
+func (l listExpr) MarshalJSON() ([]byte, error) {
+	if l.Ref != "" {
+		return json.Marshal(l.Ref)
+	}
+	if l.Values == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(l.Values)
+}
+
+func (l *listExpr) UnmarshalJSON(b []byte) error {
+	*l = listExpr{}
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &l.Ref)
+	}
+	return json.Unmarshal(b, &l.Values)
+}
+
+func (tf *terraform) securityGroup(resourceID, id string, port int) *securityGroup {
+	return &securityGroup{
+		Name:        id + "-SG",
+		Description: "Allow RDS inboud traffic",
+		VPCID:       "${module.network.back_vpc_id}",
+		Ingress: []ingress{
 			{
-				"description":      "Mid VPC",
-				"from_port":        port,
-				"to_port":          port,
-				"protocol":         "TCP",
-				"cidr_blocks":      [...]string{"${module.network.mid_vpc_cidr}"},
-				"ipv6_cidr_blocks": [...]string{},
-				"prefix_list_ids":  [...]string{},
-				"security_groups":  [...]string{},
-				"self":             false,
+				Description:    "Mid VPC",
+				FromPort:       port,
+				ToPort:         port,
+				Protocol:       "TCP",
+				CIDRBlocks:     listExpr{Values: []string{"${module.network.mid_vpc_cidr}"}},
+				IPv6CIDRBlocks: []string{},
+				PrefixListIDs:  []string{},
+				SecurityGroups: []string{},
+				Self:           false,
 			},
 			{
-				"description":      "DBMW Mgmt",
-				"from_port":        port,
-				"to_port":          port,
-				"protocol":         "TCP",
-				"cidr_blocks":      "${var." + resourceID + "_mgmt_cidr_blocks}",
-				"ipv6_cidr_blocks": [...]string{},
-				"prefix_list_ids":  [...]string{},
-				"security_groups":  [...]string{},
-				"self":             false,
+				Description:    "DBMW Mgmt",
+				FromPort:       port,
+				ToPort:         port,
+				Protocol:       "TCP",
+				CIDRBlocks:     listExpr{Ref: "${var." + resourceID + "_mgmt_cidr_blocks}"},
+				IPv6CIDRBlocks: []string{},
+				PrefixListIDs:  []string{},
+				SecurityGroups: []string{},
+				Self:           false,
 			},
 		},
 	}
@@ -8,15 +8,34 @@ func TestSecurityGroup(t *testing.T) {
 	eName := "test-rds-SG"
 	ePort := 5700
 	ecidrBlock := "${var.test_rds_mgmt_cidr_blocks}"
-	if sg["name"] != eName {
-		t.Errorf("*terraform.rdsEngineDefaults() failed: incorrect name. Expected: %s\n Got: %s\n", eName, sg["name"])
+	if sg.Name != eName {
+		t.Errorf("*terraform.securityGroup() failed: incorrect name. Expected: %s\n Got: %s\n", eName, sg.Name)
 	}
-	port := sg["ingress"].([2]map[string]interface{})[0]["from_port"]
+	port := sg.Ingress[0].FromPort
 	if port != ePort {
-		t.Errorf("*terraform.rdsEngineDefaults() failed: incorrect port. Expected: %d\n Got: %s\n", ePort, port)
+		t.Errorf("*terraform.securityGroup() failed: incorrect port. Expected: %d\n Got: %d\n", ePort, port)
 	}
-	cidrBlock := sg["ingress"].([2]map[string]interface{})[1]["cidr_blocks"]
+	cidrBlock := sg.Ingress[1].CIDRBlocks.Ref
 	if cidrBlock != ecidrBlock {
-		t.Errorf("*terraform.rdsEngineDefaults() failed: incorrect management cidr_blocks. Expected: %s\n Got: %s\n", ecidrBlock, cidrBlock)
+		t.Errorf("*terraform.securityGroup() failed: incorrect management cidr_blocks. Expected: %s\n Got: %s\n", ecidrBlock, cidrBlock)
+	}
+}
+
+func TestListExpr(t *testing.T) {
+	tt := map[string]listExpr{
+		`"${var.test}"`: {Ref: "${var.test}"},
+		`["a","b"]`:     {Values: []string{"a", "b"}},
+		`[]`:            {},
+	}
+	for expected, l := range tt {
+		b, err := l.MarshalJSON()
+		if err != nil || string(b) != expected {
+			t.Errorf("listExpr.MarshalJSON() failed: expected: %s got: %s %v", expected, b, err)
+		}
+		var got listExpr
+		err = got.UnmarshalJSON(b)
+		if err != nil || got.Ref != l.Ref || len(got.Values) != len(l.Values) {
+			t.Errorf("listExpr.UnmarshalJSON(%s) failed: expected: %+v got: %+v %v", b, l, got, err)
+		}
 	}
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Pipeline stages, in the order they are run
+const (
+	stageClone       = "clone"
+	stageBranch      = "branch"
+	stageWrite       = "write"
+	stagePassword    = "password"
+	stageCommit      = "commit"
+	stagePullRequest = "pull_request"
+	stageMerge       = "merge"
+	stageApply       = "apply"
+	stageRITM        = "ritm_update"
+)
+
+// stageNames returns the pipeline stages in order
+func stageNames() []string {
+	return []string{stageClone, stageBranch, stageWrite, stagePassword, stageCommit,
+		stagePullRequest, stageMerge, stageApply, stageRITM}
+}
+
+// pipelineState records the progress of a RITM through the terraform pipeline
+// so a failed run can be resumed
+type pipelineState struct {
+	Number      string               `json:"number"`
+	Branch      string               `json:"branch,omitempty"`
+	PullRequest int                  `json:"pull_request,omitempty"`
+	Changes     []attributeChange    `json:"changes,omitempty"` // for the pull request of a modification
+	Completed   map[string]time.Time `json:"completed"`
+	path        string
+}
+
+// loadState reads the state file for the RITM from dir, returning an empty
+// state if the file does not exist
+func loadState(dir, number string) (*pipelineState, error) {
+	if number == "" || filepath.Base(number) != number {
+		return nil, fmt.Errorf("invalid RITM number for state file: %q", number)
+	}
+
+	s := &pipelineState{
+		Number:    number,
+		Completed: map[string]time.Time{},
+		path:      filepath.Join(dir, number+".json"),
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", s.path, err)
+	}
+	if s.Completed == nil {
+		s.Completed = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+// done returns true if the stage has completed
+func (s *pipelineState) done(stage string) bool {
+	_, ok := s.Completed[stage]
+	return ok
+}
+
+// started returns true if any stage has completed
+func (s *pipelineState) started() bool {
+	return len(s.Completed) > 0
+}
+
+// last returns the last completed stage in pipeline order
+func (s *pipelineState) last() string {
+	var stage string
+	for _, name := range stageNames() {
+		if s.done(name) {
+			stage = name
+		}
+	}
+	return stage
+}
+
+// complete records the stage as completed and saves the state
+func (s *pipelineState) complete(stage string) error {
+	s.Completed[stage] = time.Now()
+	return s.save()
+}
+
+// save writes the state file, replacing any previous version
+func (s *pipelineState) save() error {
+	err := os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
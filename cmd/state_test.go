@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPipelineState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := loadState(dir, "RITM0001001")
+	if err != nil {
+		t.Fatalf("loadState() failed: unexpected error: %v", err)
+	}
+	if s.started() {
+		t.Errorf("loadState() failed: new state should not be started")
+	}
+
+	s.PullRequest = 42
+	for _, stage := range []string{stageClone, stageBranch, stageWrite, stagePassword} {
+		err = s.complete(stage)
+		if err != nil {
+			t.Fatalf("complete(%s) failed: unexpected error: %v", stage, err)
+		}
+	}
+
+	s, err = loadState(dir, "RITM0001001")
+	if err != nil {
+		t.Fatalf("loadState() failed: unexpected error: %v", err)
+	}
+	if !s.done(stagePassword) || s.done(stageCommit) {
+		t.Errorf("loadState() failed: unexpected completed stages: %v", s.Completed)
+	}
+	if s.last() != stagePassword {
+		t.Errorf("last() failed: expected: %s got: %s", stagePassword, s.last())
+	}
+	if s.PullRequest != 42 {
+		t.Errorf("loadState() failed: expected pull request 42, got: %d", s.PullRequest)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "RITM0001001.json")); err != nil {
+		t.Errorf("save() failed: state file not written: %v", err)
+	}
+
+	_, err = loadState(dir, "../RITM0001001")
+	if err == nil {
+		t.Errorf("loadState() failed: expected error for invalid RITM number")
+	}
+}
@@ -5,117 +5,292 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
-	"strings"
 	"time"
 )
 
+// terraform is the JSON Configuration Syntax for the generated resources
 type terraform struct {
-	Map map[string]interface{}
+	Data     []dataSources          `json:"data,omitempty"`
+	Module   map[string]*rdsModule  `json:"module"`
+	Resource []resources            `json:"resource"`
+	Variable []map[string]*variable `json:"variable"`
 }
 
-func (ritm *ritm) generateTerraform() terraform {
+// variable is a Terraform input variable
+type variable struct {
+	Default     *[]string `json:"default,omitempty"`
+	Description string    `json:"description"`
+	Type        string    `json:"type"`
+}
+
+// resources are the AWS resources supporting the RDS modules
+type resources struct {
+	KMSAlias      map[string]*kmsAlias      `json:"aws_kms_alias"`
+	KMSKey        map[string]*kmsKey        `json:"aws_kms_key"`
+	SecurityGroup map[string]*securityGroup `json:"aws_security_group"`
+	SSMParameter  map[string]*ssmParameter  `json:"aws_ssm_parameter"`
+}
+
+// dataSources are the data sources the master passwords are read from
+type dataSources struct {
+	SecretsManagerSecretVersion map[string]*secretVersionData `json:"aws_secretsmanager_secret_version,omitempty"`
+	SSMParameter                map[string]*ssmParameterData  `json:"aws_ssm_parameter,omitempty"`
+	VaultGenericSecret          map[string]*vaultSecretData   `json:"vault_generic_secret,omitempty"`
+}
+
+type secretVersionData struct {
+	SecretID string `json:"secret_id"`
+}
+
+type ssmParameterData struct {
+	Name           string `json:"name"`
+	WithDecryption bool   `json:"with_decryption"`
+}
+
+type vaultSecretData struct {
+	Path string `json:"path"`
+}
+
+type kmsAlias struct {
+	Name        string `json:"name"`
+	TargetKeyID string `json:"target_key_id"`
+}
+
+type kmsKey struct {
+	Description       string `json:"description"`
+	EnableKeyRotation bool   `json:"enable_key_rotation"`
+}
+
+type ssmParameter struct {
+	Description string `json:"description"`
+	KeyID       string `json:"key_id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Value       string `json:"value"`
+}
+
+// rdsModule is the terraform-aws-modules/rds/aws module block
+type rdsModule struct {
+	AllocatedStorage                   int           `json:"allocated_storage"`
+	BackupRetentionPeriod              int           `json:"backup_retention_period"` // days
+	BackupWindow                       string        `json:"backup_window"`
+	CreateDBOptionGroup                bool          `json:"create_db_option_group"`
+	CreateDBParameterGroup             bool          `json:"create_db_parameter_group"`
+	CreateDBSubnetGroup                *bool         `json:"create_db_subnet_group,omitempty"`
+	CreateMonitoringRole               bool          `json:"create_monitoring_role"`
+	DeletionProtection                 bool          `json:"deletion_protection"`
+	EnabledCloudwatchLogsExports       []string      `json:"enabled_cloudwatch_logs_exports"`
+	Engine                             string        `json:"engine"`
+	EngineVersion                      string        `json:"engine_version"`
+	Family                             string        `json:"family"` // parameter group family
+	FinalSnapshotIdentifier            string        `json:"final_snapshot_identifier"`
+	Identifier                         string        `json:"identifier"`
+	InstanceClass                      string        `json:"instance_class"`
+	KMSKeyID                           string        `json:"kms_key_id"`
+	MaintenanceWindow                  string        `json:"maintenance_window"`
+	MajorEngineVersion                 string        `json:"major_engine_version"`
+	MaxAllocatedStorage                int           `json:"max_allocated_storage"`
+	MonitoringInterval                 int           `json:"monitoring_interval"` // minutes
+	MonitoringRoleName                 string        `json:"monitoring_role_name"`
+	MultiAZ                            bool          `json:"multi_az,omitempty"`
+	Name                               string        `json:"name,omitempty"`
+	Options                            []dbOption    `json:"options,omitempty"`
+	Parameters                         []dbParameter `json:"parameters,omitempty"`
+	Password                           string        `json:"password"`
+	PerformanceInsightsEnabled         bool          `json:"performance_insights_enabled"`
+	PerformanceInsightsRetentionPeriod int           `json:"performance_insights_retention_period"` // days
+	Port                               int           `json:"port"`
+	PubliclyAccessible                 bool          `json:"publicly_accessible"`
+	ReplicateSourceDB                  string        `json:"replicate_source_db,omitempty"`
+	Source                             string        `json:"source"`
+	StorageEncrypted                   bool          `json:"storage_encrypted"`
+	SubnetIDs                          string        `json:"subnet_ids,omitempty"`
+	Username                           string        `json:"username"`
+	Version                            string        `json:"version"`
+	VPCSecurityGroupIDs                []string      `json:"vpc_security_group_ids"`
+}
+
+// generateTerraform generates the configuration for the RITM, reading the
+// master passwords from the secret store
+func (ritm *ritm) generateTerraform(c *catalog, secrets secretStore) (terraform, error) {
 	fmt.Println("Generating terraform")
-	var tf terraform
 	rand.Seed(time.Now().UnixNano())
-	module := tf.rdsModule(ritm)
-	resourceID := strings.ReplaceAll(ritm.Identifier, "-", "_") // Conforms to our naming standard
-
-	tf.Map = map[string]interface{}{
-		"variable": [...]map[string]interface{}{{
-			resourceID + "_db_password": map[string]interface{}{
-				"type":        "string",
-				"description": "(required) RDS user password",
+	res := resources{
+		KMSAlias:      map[string]*kmsAlias{},
+		KMSKey:        map[string]*kmsKey{},
+		SecurityGroup: map[string]*securityGroup{},
+		SSMParameter:  map[string]*ssmParameter{},
+	}
+	tf := terraform{
+		Module:   map[string]*rdsModule{},
+		Variable: []map[string]*variable{},
+	}
+
+	spec, err := c.engine(ritm.Engine)
+	if err != nil {
+		return tf, err
+	}
+
+	for _, env := range ritm.environments() {
+		id := env.identifier(ritm)
+		resourceID := resourceName(id)
+		module, err := tf.rdsModule(ritm, env, spec)
+		if err != nil {
+			return tf, err
+		}
+
+		module.Password = secrets.reference(&tf, id)
+		tf.Variable = append(tf.Variable, map[string]*variable{
+			resourceID + "_mgmt_cidr_blocks": {
+				Type:        "list(string)",
+				Description: "(optional) List of CIDR blocks from which to manage RDS",
+				Default:     &[]string{},
 			}},
-			{
-				resourceID + "_mgmt_cidr_blocks": map[string]interface{}{
-					"type":        "list(string)",
-					"description": "(optional) List of CIDR blocks from which to manage RDS",
-					"default":     [...]string{},
-				}},
-		},
-		"module": map[string]interface{}{
-			resourceID: module,
-		},
-		"resource": [...]map[string]interface{}{{
-			"aws_security_group": map[string]interface{}{
-				resourceID: tf.securityGroup(resourceID, ritm.Identifier, module["port"].(int)),
-			},
-			"aws_kms_key": map[string]interface{}{
-				resourceID: map[string]interface{}{
-					"description":         ritm.Identifier + " RDS KMS Key",
-					"enable_key_rotation": true,
-				},
-			},
-			"aws_kms_alias": map[string]interface{}{
-				resourceID: map[string]interface{}{
-					"name":          "alias/" + resourceID,
-					"target_key_id": "${aws_kms_key." + resourceID + ".key_id}",
-				},
-			},
-			"aws_ssm_parameter": map[string]interface{}{
-				resourceID + "_password": map[string]interface{}{
-					"name":        "/database/password/" + ritm.Identifier,
-					"description": ritm.Identifier + " RDS Master Password",
-					"type":        "SecureString",
-					"value":       "${var." + resourceID + "_db_password}",
-					"key_id":      "${aws_kms_key." + resourceID + ".arn}",
-				},
-			},
-		},
-		},
+		)
+		tf.Module[resourceID] = module
+		for n := 1; n < env.count; n++ {
+			replica, err := tf.rdsReplica(ritm, env, spec, n, module)
+			if err != nil {
+				return tf, err
+			}
+			tf.Module[resourceName(env.replicaIdentifier(ritm, n))] = replica
+		}
+		res.SecurityGroup[resourceID] = tf.securityGroup(resourceID, id, module.Port)
+		res.KMSKey[resourceID] = &kmsKey{
+			Description:       id + " RDS KMS Key",
+			EnableKeyRotation: true,
+		}
+		res.KMSAlias[resourceID] = &kmsAlias{
+			Name:        "alias/" + resourceID,
+			TargetKeyID: "${aws_kms_key." + resourceID + ".key_id}",
+		}
+		res.SSMParameter[resourceID+"_password"] = &ssmParameter{
+			Name:        "/database/password/" + id,
+			Description: id + " RDS Master Password",
+			Type:        "SecureString",
+			Value:       module.Password,
+			KeyID:       "${aws_kms_key." + resourceID + ".arn}",
+		}
 	}
+	tf.Resource = []resources{res}
 
-	return tf
+	return tf, nil
 }
 
-func (tf *terraform) rdsModule(ritm *ritm) map[string]interface{} {
-	defaults := tf.rdsModuleDefaults()
-	engines := tf.rdsEngineDefaults()
-	family := ritm.Engine
-	options := engines[family].(map[string]interface{})
-	engine := options["engine"]
-	backupStartTime := randStart()                              // Number of minutes after start of backupwindow start hour
-	resourceID := strings.ReplaceAll(ritm.Identifier, "-", "_") // Conforms to our naming standard
+func (tf *terraform) rdsModule(ritm *ritm, env environment, spec *engineSpec) (*rdsModule, error) {
+	module := tf.rdsModuleDefaults()
+	size, err := spec.size(env.size)
+	if err != nil {
+		return nil, err
+	}
+	backupStartTime := randStart() // Number of minutes after start of backupwindow start hour
+	id := env.identifier(ritm)
+	resourceID := resourceName(id)
 
 	// Override and add to defaults
-	defaults["identifier"] = ritm.Identifier
-	defaults["engine"] = engine
-	defaults["engine_version"] = options["engine_version"]
-	defaults["enabled_cloudwatch_logs_exports"] = options["enabled_cloudwatch_logs_exports"]
-	defaults["instance_class"] = options[ritm.DevSize].(map[string]interface{})["instance_class"]
-	defaults["kms_key_id"] = "${aws_kms_key." + resourceID + ".arn}"
-	defaults["allocated_storage"] = options[ritm.DevSize].(map[string]interface{})["allocated_storage"]
-	defaults["name"] = ritm.Name
-	defaults["username"] = ritm.Username
-	defaults["password"] = "${var." + resourceID + "_db_password}"
-	defaults["port"] = rand.Intn(maxPort-minPort) + minPort
-	defaults["backup_window"] = backupWindow(backupStartTime)
-	defaults["maintenance_window"] = maintenanceWindow(backupStartTime)
-	defaults["final_snapshot_identifier"] = ritm.Identifier + "-final-shapshot"
-	defaults["major_engine_version"] = options["major_engine_version"]
-	defaults["max_allocated_storage"] = 3 * defaults["allocated_storage"].(int)
-	defaults["monitoring_role_name"] = ritm.Identifier + "-monitoring-role"
-	/* Enable once custom property/option groups are defined
-	if engine == "mysql" {
-		defaults["option_group_name"] = "grace.paas." + engine + "-" + options["major_engine_version"]
+	module.Identifier = id
+	module.Engine = spec.Engine
+	module.EngineVersion = spec.EngineVersion
+	module.EnabledCloudwatchLogsExports = spec.EnabledCloudwatchLogsExports
+	module.InstanceClass = size.InstanceClass
+	module.KMSKeyID = "${aws_kms_key." + resourceID + ".arn}"
+	module.AllocatedStorage = size.AllocatedStorage
+	module.Name = ritm.Name
+	module.Username = ritm.Username
+	module.Port = rand.Intn(maxPort-minPort) + minPort
+	module.BackupWindow = backupWindow(backupStartTime)
+	module.MaintenanceWindow = maintenanceWindow(backupStartTime)
+	module.FinalSnapshotIdentifier = finalSnapshotIdentifier(id)
+	module.MajorEngineVersion = spec.MajorEngineVersion
+	module.MaxAllocatedStorage = 3 * size.AllocatedStorage
+	module.MonitoringRoleName = monitoringRoleName(id)
+	module.Family = spec.Family
+	overrides, err := ritm.parameterOverrides(spec)
+	if err != nil {
+		return nil, err
+	}
+	if params := spec.parameters(overrides); len(params) > 0 {
+		module.CreateDBParameterGroup = true
+		module.Parameters = params
 	}
-	if engine == "postgres" {
-		defaults["parameter_group_name"] = "grace.paas." + engine + "-" + options["major_engine_version"]
+	if len(spec.Options) > 0 {
+		module.CreateDBOptionGroup = true
+		module.Options = spec.Options
+	}
+	if env.multiAZ == yes {
+		module.MultiAZ = true
+		module.SubnetIDs = "${module.network.back_vpc_subnet_ids}"
+	}
+	module.VPCSecurityGroupIDs = []string{"${aws_security_group." + resourceID + ".id}"}
+
+	return module, nil
+}
+
+// rdsReplica generates a read replica module of the environment's primary
+func (tf *terraform) rdsReplica(ritm *ritm, env environment, spec *engineSpec, n int, primary *rdsModule) (*rdsModule, error) {
+	replica, err := tf.rdsModule(ritm, env, spec)
+	if err != nil {
+		return nil, err
 	}
-	defaults["use_parameter_group_name_prefix"] = false
-	*/
-	if ritm.DevMultiAZ == yes {
-		defaults["multi_az"] = true
-		defaults["subnet_ids"] = "${module.network.back_vpc_subnet_ids}"
+	id := env.replicaIdentifier(ritm, n)
+	createSubnetGroup := false // Not allowed for replicas in the same region
+
+	replica.Identifier = id
+	replica.ReplicateSourceDB = "${module." + resourceName(env.identifier(ritm)) + ".this_db_instance_id}"
+	replica.Port = primary.Port // Shares the primary's security group
+	replica.FinalSnapshotIdentifier = finalSnapshotIdentifier(id)
+	replica.MonitoringRoleName = monitoringRoleName(id)
+	replica.CreateDBSubnetGroup = &createSubnetGroup
+	replica.SubnetIDs = ""
+	replica.Name = ""
+
+	// Username and password are inherited from the source database
+	replica.Username = ""
+	replica.Password = ""
+
+	if spec.Engine == "postgres" {
+		replica.BackupRetentionPeriod = 0 // Automated backups are not supported for PostgreSQL replicas
 	}
-	defaults["vpc_security_group_ids"] = [...]string{"${aws_security_group." + resourceID + ".id}"}
 
-	return defaults
+	return replica, nil
+}
+
+// monitoringRoleName returns the name of the instance's enhanced monitoring
+// IAM role
+func monitoringRoleName(id string) string {
+	return id + "-monitoring-role"
+}
+
+// finalSnapshotIdentifier returns the name of the snapshot taken when the
+// instance is deleted
+func finalSnapshotIdentifier(id string) string {
+	return id + "-final-shapshot"
+}
+
+// passwordVariable adds the input variable for the identifier's master
+// password and returns its reference
+func (tf *terraform) passwordVariable(id string) string {
+	name := resourceName(id) + "_db_password"
+	tf.Variable = append(tf.Variable, map[string]*variable{
+		name: {
+			Type:        "string",
+			Description: "(required) RDS user password",
+		}},
+	)
+	return "${var." + name + "}"
+}
+
+// data returns the data sources block, adding it if needed
+func (tf *terraform) data() *dataSources {
+	if len(tf.Data) == 0 {
+		tf.Data = []dataSources{{}}
+	}
+	return &tf.Data[0]
 }
 
 func (tf *terraform) writeFile(outFile string) error {
 	fmt.Printf("Writing terraform to file: %s\n", outFile)
-	b, err := json.MarshalIndent(tf.Map, "", "  ")
+	b, err := json.MarshalIndent(tf, "", "  ")
 	if err != nil {
 		return err
 	}
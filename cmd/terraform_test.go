@@ -15,21 +15,144 @@ func TestGenerateTerraform(t *testing.T) {
 		t.Fatalf("generateTerraform() failed. Unable to parse test data: %v", err)
 	}
 
-	tf := r.ritm.generateTerraform()
+	tf, err := r.ritm.generateTerraform(testCatalog(t), &ciStore{})
+	if err != nil {
+		t.Fatalf("generateTerraform() failed: unexpected error: %v", err)
+	}
 
 	expected := "(required) RDS user password"
-	got := tf.Map["variable"].([2]map[string]interface{})[0]["test_db_password"].(map[string]interface{})["description"]
+	got := tf.Variable[0]["test_dev_db_password"].Description
 	if expected != got {
-		t.Errorf("generateTerraform() failed. Unable to parse test data. Expected: %s\nGot(%T): %v\n", expected, got, got)
+		t.Errorf("generateTerraform() failed. Unable to parse test data. Expected: %s\nGot: %s\n", expected, got)
 	}
 
 	expected = "(optional) List of CIDR blocks from which to manage RDS"
-	got = tf.Map["variable"].([2]map[string]interface{})[1]["test_mgmt_cidr_blocks"].(map[string]interface{})["description"]
+	got = tf.Variable[1]["test_dev_mgmt_cidr_blocks"].Description
 	if expected != got {
 		t.Errorf("generateTerraform() failed. Unable to parse test data. Expected: %s\nGot: %s\n", expected, got)
 	}
 }
 
+func TestGenerateTerraformErrors(t *testing.T) {
+	var r req
+	r.inFile = filepath.Join("testdata", "test.json")
+
+	err := r.parseRITM()
+	if err != nil {
+		t.Fatalf("generateTerraform() failed. Unable to parse test data: %v", err)
+	}
+
+	r.ritm.Engine = "oracle-ee"
+	_, err = r.ritm.generateTerraform(testCatalog(t), &ciStore{})
+	expected := `engine "oracle-ee" not found in catalog`
+	if err == nil || err.Error() != expected {
+		t.Errorf("generateTerraform() failed. Expected error: %s\nGot: %v\n", expected, err)
+	}
+
+	r.ritm.Engine = "postgres12"
+	r.ritm.TestSize = "huge"
+	_, err = r.ritm.generateTerraform(testCatalog(t), &ciStore{})
+	expected = `size "huge" not defined for engine postgres12`
+	if err == nil || err.Error() != expected {
+		t.Errorf("generateTerraform() failed. Expected error: %s\nGot: %v\n", expected, err)
+	}
+}
+
+func TestGenerateTerraformEnvironments(t *testing.T) {
+	var r req
+	r.inFile = filepath.Join("testdata", "test.json")
+
+	err := r.parseRITM()
+	if err != nil {
+		t.Fatalf("generateTerraform() failed. Unable to parse test data: %v", err)
+	}
+	r.ritm.TestCount = "0"
+
+	tf, err := r.ritm.generateTerraform(testCatalog(t), &ciStore{})
+	if err != nil {
+		t.Fatalf("generateTerraform() failed: unexpected error: %v", err)
+	}
+	tt := map[string]struct {
+		identifier    string
+		instanceClass string
+		multiAZ       bool
+	}{
+		"test_dev":  {identifier: "test-dev", instanceClass: "db.m5.large", multiAZ: false},
+		"test_prod": {identifier: "test-prod", instanceClass: "db.m5.2xlarge", multiAZ: true},
+	}
+	if len(tf.Module) != len(tt) {
+		t.Fatalf("generateTerraform() failed. Expected %d modules, got: %d", len(tt), len(tf.Module))
+	}
+	for name, tc := range tt {
+		module, ok := tf.Module[name]
+		if !ok {
+			t.Errorf("generateTerraform() failed. Missing module: %s", name)
+			continue
+		}
+		if module.Identifier != tc.identifier {
+			t.Errorf("generateTerraform() failed. Expected identifier: %s\nGot: %v\n", tc.identifier, module.Identifier)
+		}
+		if module.InstanceClass != tc.instanceClass {
+			t.Errorf("generateTerraform() failed. Expected instance_class: %s\nGot: %v\n", tc.instanceClass, module.InstanceClass)
+		}
+		if module.MultiAZ != tc.multiAZ {
+			t.Errorf("generateTerraform() failed. Expected multi_az: %v\nGot: %v\n", tc.multiAZ, module.MultiAZ)
+		}
+	}
+
+	if _, ok := tf.Resource[0].SecurityGroup["test_test"]; ok {
+		t.Errorf("generateTerraform() failed. Security group generated for environment with zero count")
+	}
+}
+
+func TestGenerateTerraformReplicas(t *testing.T) {
+	var r req
+	r.inFile = filepath.Join("testdata", "test.json")
+
+	err := r.parseRITM()
+	if err != nil {
+		t.Fatalf("generateTerraform() failed. Unable to parse test data: %v", err)
+	}
+	r.ritm.ProdCount = "3"
+
+	tf, err := r.ritm.generateTerraform(testCatalog(t), &ciStore{})
+	if err != nil {
+		t.Fatalf("generateTerraform() failed: unexpected error: %v", err)
+	}
+	if len(tf.Module) != 5 {
+		t.Fatalf("generateTerraform() failed. Expected 5 modules, got: %d", len(tf.Module))
+	}
+
+	primary := tf.Module["test_prod"]
+	replica, ok := tf.Module["test_prod_replica_2"]
+	if !ok {
+		t.Fatalf("generateTerraform() failed. Missing module: test_prod_replica_2")
+	}
+	tt := map[string]struct {
+		got      string
+		expected string
+	}{
+		"identifier":                {got: replica.Identifier, expected: "test-prod-replica-2"},
+		"replicate_source_db":       {got: replica.ReplicateSourceDB, expected: "${module.test_prod.this_db_instance_id}"},
+		"monitoring_role_name":      {got: replica.MonitoringRoleName, expected: "test-prod-replica-2-monitoring-role"},
+		"final_snapshot_identifier": {got: replica.FinalSnapshotIdentifier, expected: "test-prod-replica-2-final-shapshot"},
+	}
+	for name, tc := range tt {
+		if tc.got != tc.expected {
+			t.Errorf("generateTerraform() failed. Expected replica %s: %s\nGot: %s\n", name, tc.expected, tc.got)
+		}
+	}
+	if replica.Password != "" || replica.Port != primary.Port {
+		t.Errorf("generateTerraform() failed. Unexpected replica password or port: %+v", replica)
+	}
+
+	for _, v := range tf.Variable {
+		if _, ok := v["test_prod_replica_1_db_password"]; ok {
+			t.Errorf("generateTerraform() failed. Password variable generated for replica")
+		}
+	}
+}
+
 func TestWriteFile(t *testing.T) {
 	var r req
 	r.inFile = filepath.Join("testdata", "test.json")
@@ -39,7 +162,10 @@ func TestWriteFile(t *testing.T) {
 		t.Fatalf("*terraform.writeFile() failed. Unable to parse test data: %v", err)
 	}
 
-	tf := r.ritm.generateTerraform()
+	tf, err := r.ritm.generateTerraform(testCatalog(t), &ciStore{})
+	if err != nil {
+		t.Fatalf("*terraform.writeFile() failed. Unable to generate terraform: %v", err)
+	}
 	fileName := filepath.Join(os.TempDir(), "tf.json")
 
 	err = tf.writeFile(fileName)
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// tfFile is a generated configuration read back from the infrastructure
+// repository. Module attributes are edited in place, so any attributes added
+// to the file by hand are kept.
+type tfFile struct {
+	path string
+	json map[string]interface{} // .tf.json files
+	hcl  *hclwrite.File         // .tf files
+}
+
+// readTFFile reads a JSON (.tf.json) or HCL (.tf) configuration file
+func readTFFile(path string) (*tfFile, error) {
+	b, err := ioutil.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+
+	f := &tfFile{path: path}
+	if strings.HasSuffix(path, ".tf.json") {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		err = dec.Decode(&f.json)
+		if err != nil {
+			return nil, fmt.Errorf("invalid terraform file %s: %v", path, err)
+		}
+		return f, nil
+	}
+
+	var diags hcl.Diagnostics
+	f.hcl, diags = hclwrite.ParseConfig(b, path, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("invalid terraform file %s: %v", path, diags)
+	}
+	return f, nil
+}
+
+// moduleNames returns the names of the module blocks, sorted
+func (f *tfFile) moduleNames() []string {
+	var names []string
+	if f.hcl != nil {
+		for _, block := range f.hcl.Body().Blocks() {
+			if block.Type() == "module" && len(block.Labels()) == 1 {
+				names = append(names, block.Labels()[0])
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	modules, _ := f.json["module"].(map[string]interface{})
+	return sortedKeys(modules)
+}
+
+// attribute returns the value of a module attribute as it would be decoded
+// from JSON. HCL expressions that are not literals are returned as a "${...}"
+// interpolation.
+func (f *tfFile) attribute(module, name string) (interface{}, bool) {
+	if f.hcl == nil {
+		attrs, ok := f.jsonModule(module)
+		if !ok {
+			return nil, false
+		}
+		v, ok := attrs[name]
+		return v, ok
+	}
+
+	body, ok := f.hclModule(module)
+	if !ok {
+		return nil, false
+	}
+	attr := body.GetAttribute(name)
+	if attr == nil {
+		return nil, false
+	}
+	src := bytes.TrimSpace(attr.Expr().BuildTokens(nil).Bytes())
+	expr, diags := hclsyntax.ParseExpression(src, f.path, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "${" + string(src) + "}", true
+	}
+	return exprToJSON(expr, src), true
+}
+
+// exprToJSON converts an HCL expression to the value decoded from the same
+// JSON, keeping references as interpolations
+func exprToJSON(expr hclsyntax.Expression, src []byte) interface{} {
+	v, diags := expr.Value(nil)
+	if !diags.HasErrors() {
+		return ctyToJSON(v)
+	}
+	if tuple, ok := expr.(*hclsyntax.TupleConsExpr); ok {
+		list := []interface{}{}
+		for _, e := range tuple.Exprs {
+			list = append(list, exprToJSON(e, src))
+		}
+		return list
+	}
+	return "${" + string(expr.Range().SliceBytes(src)) + "}"
+}
+
+// stringAttribute returns a module attribute as a string, or "" if it is
+// not set
+func (f *tfFile) stringAttribute(module, name string) string {
+	v, ok := f.attribute(module, name)
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// setAttribute sets a module attribute, adding it if needed. The value is
+// converted to the value decoded from its JSON encoding.
+func (f *tfFile) setAttribute(module, name string, v interface{}) error {
+	v, err := jsonValue(v)
+	if err != nil {
+		return err
+	}
+
+	if f.hcl == nil {
+		attrs, ok := f.jsonModule(module)
+		if !ok {
+			return fmt.Errorf("module %s not found in %s", module, f.path)
+		}
+		attrs[name] = v
+		return nil
+	}
+
+	body, ok := f.hclModule(module)
+	if !ok {
+		return fmt.Errorf("module %s not found in %s", module, f.path)
+	}
+	body.SetAttributeRaw(name, valueTokens(v))
+	return nil
+}
+
+// write saves the file in its original format
+func (f *tfFile) write() error {
+	fmt.Printf("Writing terraform to file: %s\n", f.path)
+	if f.hcl != nil {
+		return ioutil.WriteFile(f.path, hclwrite.Format(f.hcl.Bytes()), 0600)
+	}
+
+	b, err := json.MarshalIndent(f.json, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.path, b, 0600)
+}
+
+// findModule returns the path, relative to dir, of the configuration file
+// containing the RDS identifier and the name of its module
+func findModule(dir, id string) (string, string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, tfConst, "rds_*.tf*"))
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, path := range paths {
+		if !strings.HasSuffix(path, ".tf") && !strings.HasSuffix(path, ".tf.json") {
+			continue
+		}
+		f, err := readTFFile(path)
+		if err != nil {
+			return "", "", err
+		}
+		for _, name := range f.moduleNames() {
+			if f.stringAttribute(name, "identifier") == id {
+				rel, err := filepath.Rel(dir, path)
+				return rel, name, err
+			}
+		}
+	}
+	return "", "", fmt.Errorf("identifier %s not found in %s", id, filepath.Join(dir, tfConst))
+}
+
+// jsonValue returns the value decoded from the JSON encoding of v, so typed
+// values such as ints and slices can be written to either format
+func jsonValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err = dec.Decode(&v)
+	return v, err
+}
+
+func (f *tfFile) jsonModule(module string) (map[string]interface{}, bool) {
+	modules, _ := f.json["module"].(map[string]interface{})
+	attrs, ok := modules[module].(map[string]interface{})
+	return attrs, ok
+}
+
+func (f *tfFile) hclModule(module string) (*hclwrite.Body, bool) {
+	for _, block := range f.hcl.Body().Blocks() {
+		if block.Type() == "module" && len(block.Labels()) == 1 && block.Labels()[0] == module {
+			return block.Body(), true
+		}
+	}
+	return nil, false
+}
+
+// ctyToJSON converts a literal HCL value to the value decoded from the same
+// JSON
+func ctyToJSON(v cty.Value) interface{} {
+	if v.IsNull() || !v.IsKnown() {
+		return nil
+	}
+
+	switch t := v.Type(); {
+	case t == cty.String:
+		return v.AsString()
+	case t == cty.Number:
+		return json.Number(v.AsBigFloat().Text('f', -1))
+	case t == cty.Bool:
+		return v.True()
+	case t.IsListType() || t.IsTupleType() || t.IsSetType():
+		list := []interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			_, e := it.Element()
+			list = append(list, ctyToJSON(e))
+		}
+		return list
+	case t.IsMapType() || t.IsObjectType():
+		m := map[string]interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			k, e := it.Element()
+			m[k.AsString()] = ctyToJSON(e)
+		}
+		return m
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestTerraform writes the configuration for testdata/test.json to dir in
+// the format, returning its path
+func writeTestTerraform(t *testing.T, dir, format string) string {
+	return writeRITMTerraform(t, dir, format, testRITM(t))
+}
+
+// testRITM returns the RITM in testdata/test.json
+func testRITM(t *testing.T) *ritm {
+	var r req
+	r.inFile = filepath.Join("testdata", "test.json")
+	err := r.parseRITM()
+	if err != nil {
+		t.Fatalf("unable to parse test data: %v", err)
+	}
+	return r.ritm
+}
+
+// writeRITMTerraform writes the configuration of the RITM in the format to dir
+func writeRITMTerraform(t *testing.T, dir, format string, r *ritm) string {
+	tf, err := r.generateTerraform(testCatalog(t), &ciStore{})
+	if err != nil {
+		t.Fatalf("generateTerraform() failed: unexpected error: %v", err)
+	}
+
+	path := filepath.Join(dir, "rds_RITM0001001.tf.json")
+	if format == hclConst {
+		path = filepath.Join(dir, "rds_RITM0001001.tf")
+		err = tf.writeHCLFile(path)
+	} else {
+		err = tf.writeFile(path)
+	}
+	if err != nil {
+		t.Fatalf("unable to write test terraform: %v", err)
+	}
+	return path
+}
+
+// nolint: funlen
+func TestTFFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tffile")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, format := range []string{tfConst, hclConst} {
+		path := writeTestTerraform(t, dir, format)
+		before, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unable to read %s: %v", path, err)
+		}
+
+		f, err := readTFFile(path)
+		if err != nil {
+			t.Fatalf("readTFFile() failed: unexpected error: %v", err)
+		}
+		err = f.write()
+		if err != nil {
+			t.Fatalf("write() failed: unexpected error: %v", err)
+		}
+		after, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unable to read %s: %v", path, err)
+		}
+		if string(after) != string(before) {
+			t.Errorf("write() failed: unchanged %s file rewritten differently:\n%s\nExpected:\n%s", format, after, before)
+		}
+
+		names := f.moduleNames()
+		expected := []string{"test_dev", "test_prod", "test_test"}
+		if len(names) != len(expected) || names[0] != expected[0] || names[2] != expected[2] {
+			t.Errorf("moduleNames() failed: expected %v, got: %v", expected, names)
+		}
+		for attr, value := range map[string]interface{}{
+			"identifier":             "test-prod",
+			"allocated_storage":      json.Number("100"),
+			"multi_az":               true,
+			"password":               "${var.test_prod_db_password}",
+			"vpc_security_group_ids": []interface{}{"${aws_security_group.test_prod.id}"},
+		} {
+			v, ok := f.attribute("test_prod", attr)
+			got, _ := json.Marshal(v)
+			want, _ := json.Marshal(value)
+			if !ok || string(got) != string(want) {
+				t.Errorf("attribute() failed for %s %s: expected %s, got: %s", format, attr, want, got)
+			}
+		}
+
+		err = f.setAttribute("test_prod", "allocated_storage", 400)
+		if err != nil {
+			t.Fatalf("setAttribute() failed: unexpected error: %v", err)
+		}
+		err = f.setAttribute("test_prod", "apply_immediately", true)
+		if err != nil {
+			t.Fatalf("setAttribute() failed: unexpected error: %v", err)
+		}
+		err = f.setAttribute("test_missing", "multi_az", true)
+		if err == nil {
+			t.Errorf("setAttribute() failed: expected error for missing module")
+		}
+		err = f.write()
+		if err != nil {
+			t.Fatalf("write() failed: unexpected error: %v", err)
+		}
+
+		f, err = readTFFile(path)
+		if err != nil {
+			t.Fatalf("readTFFile() failed: unexpected error: %v", err)
+		}
+		if v, _ := f.attribute("test_prod", "allocated_storage"); v != json.Number("400") {
+			t.Errorf("setAttribute() failed for %s: expected allocated_storage 400, got: %v", format, v)
+		}
+		if v, _ := f.attribute("test_prod", "apply_immediately"); v != true {
+			t.Errorf("setAttribute() failed for %s: expected apply_immediately true, got: %v", format, v)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// timeoutOptions limit how long the pipeline stages that contact other
+// services may take. A timeout of 0 leaves the stage without a deadline.
+type timeoutOptions struct {
+	cloneTimeout       time.Duration
+	passwordTimeout    time.Duration // storing or removing the master passwords
+	commitTimeout      time.Duration // committing and pushing the changes
+	pullRequestTimeout time.Duration
+	mergeTimeout       time.Duration // waiting for the pull request to be merged
+	applyTimeout       time.Duration // waiting for the apply workflow
+	jobTimeout         time.Duration // waiting for each job of the apply workflow, except the apply job
+	ritmTimeout        time.Duration // updating the RITM
+}
+
+func (o *timeoutOptions) addFlags(flags *flag.FlagSet) {
+	flags.DurationVar(&o.cloneTimeout, "clone-timeout", 10*time.Minute, "How long cloning the repository may take")
+	flags.DurationVar(&o.passwordTimeout, "password-timeout", 5*time.Minute,
+		"How long storing or removing the master passwords may take")
+	flags.DurationVar(&o.commitTimeout, "commit-timeout", 10*time.Minute, "How long committing and pushing the changes may take")
+	flags.DurationVar(&o.pullRequestTimeout, "pull-request-timeout", 5*time.Minute, "How long opening the pull request may take")
+	flags.DurationVar(&o.mergeTimeout, "merge-timeout", 7*24*time.Hour,
+		"How long to wait for the pull request to be merged, 0 waits without a deadline")
+	flags.DurationVar(&o.applyTimeout, "apply-timeout", time.Hour, "How long to wait for the apply workflow to finish")
+	flags.DurationVar(&o.jobTimeout, "job-timeout", 5*time.Minute, "How long to wait for each workflow job before the apply job")
+	flags.DurationVar(&o.ritmTimeout, "ritm-timeout", 5*time.Minute, "How long updating the RITM may take")
+}
+
+func (o *timeoutOptions) check() error {
+	for _, t := range []struct {
+		flag    string
+		timeout time.Duration
+	}{
+		{"clone-timeout", o.cloneTimeout},
+		{"password-timeout", o.passwordTimeout},
+		{"commit-timeout", o.commitTimeout},
+		{"pull-request-timeout", o.pullRequestTimeout},
+		{"merge-timeout", o.mergeTimeout},
+		{"apply-timeout", o.applyTimeout},
+		{"job-timeout", o.jobTimeout},
+		{"ritm-timeout", o.ritmTimeout},
+	} {
+		if t.timeout < 0 {
+			return fmt.Errorf("%s must not be negative", t.flag)
+		}
+	}
+	return nil
+}
+
+// stageTimeout returns the timeout of the pipeline stage, 0 if it has none
+func (o *timeoutOptions) stageTimeout(stage string) time.Duration {
+	switch stage {
+	case stageClone:
+		return o.cloneTimeout
+	case stagePassword:
+		return o.passwordTimeout
+	case stageCommit:
+		return o.commitTimeout
+	case stagePullRequest:
+		return o.pullRequestTimeout
+	case stageMerge:
+		return o.mergeTimeout
+	case stageApply:
+		return o.applyTimeout
+	case stageRITM:
+		return o.ritmTimeout
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestTimeoutOptions(t *testing.T) {
+	var o timeoutOptions
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.addFlags(flags)
+	err := flags.Parse([]string{"-merge-timeout", "0", "-apply-timeout", "2h"})
+	if err != nil {
+		t.Fatalf("Parse() failed: unexpected error: %v", err)
+	}
+	if err = o.check(); err != nil {
+		t.Errorf("check() failed: unexpected error: %v", err)
+	}
+
+	tt := map[string]time.Duration{
+		stageClone:       10 * time.Minute,
+		stagePassword:    5 * time.Minute,
+		stageWrite:       0,
+		stageMerge:       0,
+		stageApply:       2 * time.Hour,
+		stagePullRequest: 5 * time.Minute,
+	}
+	for stage, expected := range tt {
+		if got := o.stageTimeout(stage); got != expected {
+			t.Errorf("stageTimeout() failed: %s: expected %s, got: %s", stage, expected, got)
+		}
+	}
+
+	o.jobTimeout = -time.Second
+	err = o.check()
+	if err == nil || err.Error() != "job-timeout must not be negative" {
+		t.Errorf("check() failed: expected job-timeout error, got: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// upgrade moves an existing database and its read replicas to a newer major
+// engine version, as requested by an upgrade RITM naming the target engine
+// family
+type upgrade struct {
+	*modification
+}
+
+// upgradeRITM runs the upgrade subcommand. Errors are posted to the upgrade
+// RITM once it has been read.
+func upgradeRITM(ctx context.Context, progName string, args []string) error {
+	u, err := newUpgrade(ctx, progName, args)
+	if err == nil {
+		err = u.run()
+	}
+	u.record(err)
+	return err
+}
+
+func newUpgrade(ctx context.Context, progName string, args []string) (*upgrade, error) {
+	u := &upgrade{modification: &modification{req: &req{ctx: ctx, format: tfConst}}}
+	flags := flag.NewFlagSet(progName, flag.ContinueOnError)
+	var buf bytes.Buffer
+	flags.SetOutput(&buf)
+	u.addChangeFlags(flags, "upgrade")
+	flags.StringVar(&u.catalogFile, "catalog", "", "Engine catalog file (YAML or JSON), defaults to the built-in catalog")
+	flags.StringVar(&u.stateDir, "state-dir", filepath.Join(os.TempDir(), "grace-paas-rds"),
+		"Directory for the pipeline state files, used to resume an interrupted upgrade")
+	err := flags.Parse(args)
+	if err != nil {
+		fmt.Println(buf.String())
+		return u, err
+	}
+
+	err = u.checkChange()
+	if err != nil {
+		flags.PrintDefaults()
+		return u, err
+	}
+
+	u.catalog, err = loadCatalog(u.catalogFile)
+	if err != nil {
+		return u, err
+	}
+
+	err = u.newClients()
+	if err != nil {
+		return u, err
+	}
+	err = u.parseRITM()
+	if err != nil {
+		return u, err
+	}
+	err = u.ritm.validateUpgrade(u.catalog)
+	if err != nil {
+		return u, err
+	}
+	return u, u.useBranch(u.ritm.Number)
+}
+
+// run upgrades the instance's module blocks in a pull request, closing the
+// RITM once it has been applied
+func (u *upgrade) run() error {
+	defer u.removeClone()
+
+	return u.runStages([]stage{
+		{name: stageClone, local: true, run: u.cloneModule},
+		{name: stageBranch, local: true, run: u.newBranch},
+		{name: stageWrite, local: true, run: u.upgradeStage},
+		{name: stageCommit, run: u.commitStage},
+		{name: stagePullRequest, run: func() error {
+			return u.openPullRequest(u.ritm.Number+" upgrade "+u.ritm.Identifier+" to "+u.ritm.Engine, u.prBody())
+		}},
+		{name: stageMerge, run: u.mergeStage},
+		{name: stageApply, run: u.applyStage},
+		{name: stageRITM, run: func() error {
+			return setRITMState(u.context(), u.snowClient, u.ritm, 3, u.summary()+" via GRACE-PaaS CI/CD Pipeline")
+		}},
+	})
+}
+
+func (u *upgrade) upgradeStage() error {
+	f, err := readTFFile(u.fullPath)
+	if err != nil {
+		return err
+	}
+
+	u.state.Changes, err = u.ritm.upgrade(f, u.module, u.catalog) // Saved when the stage completes
+	if err != nil {
+		return err
+	}
+	return f.write()
+}
+
+// upgrade rewrites the engine of the instance's module and of its read
+// replicas, which must be upgraded with it, returning the changes
+func (ritm *ritm) upgrade(f *tfFile, module string, c *catalog) ([]attributeChange, error) {
+	e := &moduleEditor{file: f, module: module}
+	if e.get("replicate_source_db") != "" {
+		return nil, fmt.Errorf("%s is a read replica, upgrade its source instance instead", ritm.Identifier)
+	}
+
+	current, err := c.engineFor(e.get("engine"), e.get("major_engine_version"))
+	if err != nil {
+		return nil, err
+	}
+	target, err := c.engine(ritm.Engine)
+	if err != nil {
+		return nil, err
+	}
+	if current == target {
+		return nil, fmt.Errorf("%s is already a %s instance", ritm.Identifier, target.Family)
+	}
+	policy, ok := current.Upgrades[target.Family]
+	if !ok {
+		targets := strings.Join(current.upgradeTargets(), ", ")
+		if targets == "" {
+			targets = "none"
+		}
+		return nil, fmt.Errorf("%s cannot be upgraded to %s, supported upgrades: %s", current.Family, target.Family, targets)
+	}
+
+	editors := []*moduleEditor{e}
+	source := "${module." + module + ".this_db_instance_id}"
+	for _, name := range f.moduleNames() {
+		if f.stringAttribute(name, "replicate_source_db") == source {
+			editors = append(editors, &moduleEditor{file: f, module: name})
+		}
+	}
+
+	var changes []attributeChange
+	for _, e := range editors {
+		e.upgradeEngine(current, target, policy)
+		if e.err != nil {
+			return nil, e.err
+		}
+		changes = append(changes, e.changes...)
+	}
+	return changes, nil
+}
+
+// upgradeEngine sets the module's engine version, parameter group family and
+// option group major version to the target's, allowing the major version
+// upgrade as the policy says
+func (e *moduleEditor) upgradeEngine(current, target *engineSpec, policy upgradePolicy) {
+	e.set("engine_version", target.EngineVersion)
+	e.set("major_engine_version", target.MajorEngineVersion)
+	e.set("family", target.Family)
+	if v, _ := e.file.attribute(e.module, "enabled_cloudwatch_logs_exports"); jsonEqual(v, current.EnabledCloudwatchLogsExports) {
+		e.set("enabled_cloudwatch_logs_exports", target.EnabledCloudwatchLogsExports) // Unless changed by hand
+	}
+	if v, _ := e.file.attribute(e.module, "create_db_parameter_group"); v == true {
+		e.set("parameters", target.parameters(e.overrides(target)))
+	}
+	if v, _ := e.file.attribute(e.module, "create_db_option_group"); v == true {
+		e.set("create_db_option_group", len(target.Options) > 0)
+		if len(target.Options) > 0 {
+			e.set("options", target.Options)
+		}
+	}
+	e.set("allow_major_version_upgrade", true)
+	e.set("apply_immediately", policy.ApplyImmediately)
+}
+
+// overrides returns the module's parameters that the target engine allows
+// as overrides, so they are kept by the upgrade
+func (e *moduleEditor) overrides(target *engineSpec) []dbParameter {
+	var params []dbParameter
+	list, _ := e.file.attribute(e.module, "parameters")
+	items, _ := list.([]interface{})
+	for _, item := range items {
+		m, _ := item.(map[string]interface{})
+		name, value := fmt.Sprint(m["name"]), fmt.Sprint(m["value"])
+		if p, err := target.override(name, value); err == nil {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// policy returns the upgrade policy of the instance's current engine family,
+// found from the saved changes so it is known when the pipeline is resumed
+func (u *upgrade) policy() upgradePolicy {
+	target, err := u.catalog.engine(u.ritm.Engine)
+	if err != nil {
+		return upgradePolicy{}
+	}
+	for _, c := range u.state.Changes {
+		if c.Name != "major_engine_version" {
+			continue
+		}
+		current, err := u.catalog.engineFor(target.Engine, fmt.Sprint(c.Before))
+		if err != nil {
+			break
+		}
+		return current.Upgrades[target.Family]
+	}
+	return upgradePolicy{}
+}
+
+// summary describes the upgrade and when it takes effect
+func (u *upgrade) summary() string {
+	version := u.ritm.Engine
+	if target, err := u.catalog.engine(u.ritm.Engine); err == nil {
+		version = fmt.Sprintf("%s %s", target.Description, target.EngineVersion)
+	}
+	if u.policy().ApplyImmediately {
+		return fmt.Sprintf("RDS upgraded to %s", version)
+	}
+	return fmt.Sprintf("RDS upgrade to %s scheduled for the next maintenance window", version)
+}
+
+// prBody links the pull request to the RITM, shows the changes and lists the
+// checks to make before the upgrade is merged
+func (u *upgrade) prBody() string {
+	id := u.ritm.Identifier
+	snapshot := id + "-pre-upgrade-" + strings.ToLower(u.ritm.Number)
+	when := "The upgrade takes place in the next maintenance window after this is applied."
+	if u.policy().ApplyImmediately {
+		when = "The upgrade is applied immediately when this is merged, and the database is unavailable while it runs."
+	}
+
+	checklist := []string{
+		fmt.Sprintf("Take a manual snapshot: `aws rds create-db-snapshot --db-instance-identifier %s --db-snapshot-identifier %s`",
+			id, snapshot),
+		fmt.Sprintf("Wait for the snapshot to be available: `aws rds wait db-snapshot-available --db-snapshot-identifier %s`", snapshot),
+		fmt.Sprintf("Confirm the applications using %s support %s", id, u.ritm.Engine),
+		"Agree the upgrade downtime with the database's users",
+	}
+	return fmt.Sprintf("%s\n\nUpgrades %s and any read replicas to %s:\n\n%s\n\n%s Before merging:\n\n- [ ] %s",
+		ritmLink(u.ritm), id, u.ritm.Engine, changeDiff(u.state.Changes), when, strings.Join(checklist, "\n- [ ] "))
+}
+
+// record posts the error to the upgrade RITM, if it has been read
+func (u *upgrade) record(e error) {
+	if e == nil || u.ritm == nil || u.snowClient == nil || u.commentInterrupted(e, "Upgrade") {
+		return
+	}
+
+	err := reopenRITM(context.Background(), u.snowClient, u.ritm, "upgrading RDS", e)
+	if err != nil {
+		fmt.Printf("Unable to update RITM: %v\n", err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewUpgrade(t *testing.T) {
+	oldArgs, oldEnv := captureEnv()
+	defer resetEnv(oldArgs, oldEnv)
+	env := map[string]string{
+		"CIRCLE_TOKEN": "test",
+		"GITHUB_TOKEN": "test",
+		"SN_INSTANCE":  "test",
+		"SN_PASSWORD":  "test",
+		"SN_USER":      "test",
+	}
+	request := filepath.Join("testdata", "upgrade.json")
+
+	tt := map[string]struct {
+		args []string
+		err  string
+	}{
+		"happy":   {args: []string{"-request", request, "-repo", "test-repo", "-state-dir", os.TempDir()}},
+		"no repo": {args: []string{"-request", request}, err: "reponame must be set"},
+		"modification request": {
+			args: []string{"-request", filepath.Join("testdata", "modify.json"), "-repo", "test-repo"},
+			err:  `invalid RITM (1 errors): engine: unsupported engine "", expected one of: mysql5.7, mysql8.0, postgres11, postgres12`,
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			resetEnv(oldArgs, env)
+			u, err := newUpgrade(context.Background(), "upgrade", tc.args)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("newUpgrade() failed: unexpected error: %v", err)
+				}
+				if u.ritm.Identifier != "test-prod" || u.ritm.Engine != "postgres12" || u.branch != "RITM0004001" {
+					t.Errorf("newUpgrade() failed: unexpected upgrade: %+v", u.ritm)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("newUpgrade() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+// writeUpgradeTerraform writes the test RITM's configuration for an engine
+// family, with a production read replica
+func writeUpgradeTerraform(t *testing.T, dir, format, engine string) string {
+	r := testRITM(t)
+	r.Engine = engine
+	r.ProdCount = "2"
+	r.ParameterOverrides = "max_connections=500"
+	return writeRITMTerraform(t, dir, format, r)
+}
+
+// nolint: funlen
+func TestUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upgrade")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tt := map[string]struct {
+		engine string // engine family of the configuration
+		ritm   ritm
+		diff   string // changes of the replica, which match the primary's
+		err    string
+	}{
+		"postgres": {
+			engine: "postgres11",
+			ritm:   ritm{Identifier: "test-prod", Engine: "postgres12"},
+			diff: `  module "test_prod_replica_1"
+- engine_version = "11.8"
++ engine_version = "12.3"
+- major_engine_version = "11"
++ major_engine_version = "12"
+- family = "postgres11"
++ family = "postgres12"
+- parameters = [{"name":"rds.force_ssl","value":"1"},{"name":"log_connections","value":"1"},` +
+				`{"name":"log_disconnections","value":"1"},{"apply_method":"pending-reboot","name":"max_connections","value":"500"}]
++ parameters = [{"name":"rds.force_ssl","value":"1"},{"name":"ssl_min_protocol_version","value":"TLSv1.2"},` +
+				`{"name":"log_connections","value":"1"},{"name":"log_disconnections","value":"1"},` +
+				`{"apply_method":"pending-reboot","name":"max_connections","value":"500"}]
++ allow_major_version_upgrade = true
++ apply_immediately = false
+`,
+		},
+		"mysql": {
+			engine: "mysql5.7",
+			ritm:   ritm{Identifier: "test-prod", Engine: "mysql8.0"},
+			diff: `  module "test_prod_replica_1"
+- engine_version = "5.7.30"
++ engine_version = "8.0.28"
+- major_engine_version = "5.7"
++ major_engine_version = "8.0"
+- family = "mysql5.7"
++ family = "mysql8.0"
+- parameters = [{"name":"require_secure_transport","value":"1"},{"name":"log_output","value":"FILE"},` +
+				`{"name":"max_connections","value":"500"}]
++ parameters = [{"name":"require_secure_transport","value":"1"},` +
+				`{"apply_method":"pending-reboot","name":"tls_version","value":"TLSv1.2"},{"name":"log_output","value":"FILE"},` +
+				`{"name":"max_connections","value":"500"}]
++ allow_major_version_upgrade = true
++ apply_immediately = false
+`,
+		},
+		"replica": {
+			engine: "postgres11",
+			ritm:   ritm{Identifier: "test-prod-replica-1", Engine: "postgres12"},
+			err:    "test-prod-replica-1 is a read replica, upgrade its source instance instead",
+		},
+		"same family": {
+			engine: "postgres12",
+			ritm:   ritm{Identifier: "test-prod", Engine: "postgres12"},
+			err:    "test-prod is already a postgres12 instance",
+		},
+		"no upgrade path": {
+			engine: "postgres11",
+			ritm:   ritm{Identifier: "test-prod", Engine: "mysql8.0"},
+			err:    "postgres11 cannot be upgraded to mysql8.0, supported upgrades: postgres12",
+		},
+		"latest": {
+			engine: "postgres12",
+			ritm:   ritm{Identifier: "test-prod", Engine: "postgres11"},
+			err:    "postgres12 cannot be upgraded to postgres11, supported upgrades: none",
+		},
+	}
+	for _, format := range []string{tfConst, hclConst} {
+		for name, tc := range tt {
+			tc := tc
+			t.Run(format+" "+name, func(t *testing.T) {
+				f, err := readTFFile(writeUpgradeTerraform(t, dir, format, tc.engine))
+				if err != nil {
+					t.Fatalf("readTFFile() failed: unexpected error: %v", err)
+				}
+
+				changes, err := tc.ritm.upgrade(f, resourceName(tc.ritm.Identifier), testCatalog(t))
+				if tc.err != "" {
+					if err == nil || err.Error() != tc.err {
+						t.Errorf("upgrade() failed: expected error: %s\nGot: %v", tc.err, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("upgrade() failed: unexpected error: %v", err)
+				}
+				for _, c := range changes {
+					if v, _ := f.attribute(c.Module, c.Name); !jsonEqual(v, c.After) {
+						t.Errorf("upgrade() failed: expected %s.%s %v, got: %v", c.Module, c.Name, c.After, v)
+					}
+				}
+				diff := changeDiff(changes)
+				primary := strings.Replace(tc.diff, "test_prod_replica_1", "test_prod", 1)
+				if diff != "```diff\n"+primary+tc.diff+"```" {
+					t.Errorf("upgrade() failed: unexpected changes:\n%s", diff)
+				}
+				if v := f.stringAttribute("test_dev", "engine_version"); v != testCatalog(t).Engines[tc.engine].EngineVersion {
+					t.Errorf("upgrade() failed: test_dev engine_version changed to %s", v)
+				}
+			})
+		}
+	}
+}
+
+func TestUpgradeComplete(t *testing.T) {
+	snow := fakeSnow(t)
+	defer snow.Close()
+
+	u := &upgrade{modification: &modification{req: &req{
+		catalog:    testCatalog(t),
+		ritm:       &ritm{Number: "RITM0004001", SysID: "00000000000000000000000000004001", Identifier: "test-prod", Engine: "postgres12"},
+		snowClient: snow.client(),
+		state: &pipelineState{Changes: []attributeChange{
+			{Module: "test_prod", Name: "major_engine_version", Before: "11", After: "12"},
+		}},
+	}}}
+
+	body := u.prBody()
+	for _, s := range []string{
+		"+ major_engine_version = \"12\"",
+		"The upgrade takes place in the next maintenance window after this is applied.",
+		"--db-instance-identifier test-prod --db-snapshot-identifier test-prod-pre-upgrade-ritm0004001`",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("prBody() failed: expected body to contain %q, got: %s", s, body)
+		}
+	}
+
+	u.catalog.Engines["postgres11"].Upgrades["postgres12"] = upgradePolicy{ApplyImmediately: true}
+	if s := u.summary(); s != "RDS upgraded to PostgreSQL 12.3" {
+		t.Errorf("summary() failed: unexpected summary: %s", s)
+	}
+
+	u.record(fmt.Errorf("apply failed"))
+	if len(snow.updates) != 1 || snow.updates[0]["comments"] != "Error upgrading RDS, the request has been reopened for review" ||
+		snow.updates[0]["work_notes"] != "Error upgrading RDS: apply failed" {
+		t.Errorf("record() failed: unexpected RITM updates: %v", snow.updates)
+	}
+}
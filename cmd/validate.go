@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxIdentifierLength = 63    // RDS DB instance identifier limit
+	maxRoleNameLength   = 64    // IAM role name limit, of the enhanced monitoring role
+	maxReplicas         = 5     // RDS read replica limit per source instance
+	minStorage          = 20    // GiB, RDS minimum for MySQL and PostgreSQL
+	maxStorage          = 65536 // GiB, RDS maximum for MySQL and PostgreSQL
+	no                  = "No"
+)
+
+// fieldError describes a single invalid RITM field
+type fieldError struct {
+	Field   string
+	Message string
+}
+
+// validationError aggregates every invalid field found in a RITM
+type validationError []fieldError
+
+func (e validationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fmt.Sprintf("%s: %s", fe.Field, fe.Message)
+	}
+	return fmt.Sprintf("invalid RITM (%d errors): %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e *validationError) add(field, format string, a ...interface{}) {
+	*e = append(*e, fieldError{Field: field, Message: fmt.Sprintf(format, a...)})
+}
+
+// namingRules are the RDS master username, password and database name
+// constraints for an engine
+type namingRules struct {
+	usernameMaxLength int
+	usernamePattern   *regexp.Regexp
+	usernameRule      string
+	reservedUsernames []string
+	nameMaxLength     int
+	namePattern       *regexp.Regexp
+	nameRule          string
+	reservedNames     []string
+	passwordMaxLength int
+	passwordForbidden string
+}
+
+// engineNamingRules returns the naming rules for the given engine
+func engineNamingRules(engine string) (namingRules, bool) {
+	switch engine {
+	case "mysql", "mariadb":
+		return namingRules{
+			usernameMaxLength: 16,
+			usernamePattern:   regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`),
+			usernameRule:      "must start with a letter and contain only letters and digits",
+			reservedUsernames: []string{"root", "rdsadmin", "rdsrepladmin", "mysql.sys", "mysql.session", "mysql.infoschema"},
+			nameMaxLength:     64,
+			namePattern:       regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`),
+			nameRule:          "must start with a letter and contain only letters and digits",
+			reservedNames:     []string{"mysql", "information_schema", "performance_schema", "sys"},
+			passwordMaxLength: 41,
+			passwordForbidden: "/\"@ ",
+		}, true
+	case "postgres":
+		return namingRules{
+			usernameMaxLength: 63,
+			usernamePattern:   regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`),
+			usernameRule:      "must start with a letter and contain only letters, digits and underscores",
+			reservedUsernames: []string{"rdsadmin", "rdsrepladmin", "rds_superuser", "rds_replication", "rds_password", "rdstopmgr"},
+			nameMaxLength:     63,
+			namePattern:       regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`),
+			nameRule:          "must start with a letter or underscore and contain only letters, digits and underscores",
+			reservedNames:     []string{"rdsadmin", "template0", "template1"},
+			passwordMaxLength: 128,
+			passwordForbidden: "/\"@ ",
+		}, true
+	}
+	return namingRules{}, false
+}
+
+// validate checks every RITM field against the engine catalog and RDS naming
+// rules, returning a validationError listing all invalid fields
+func (ritm *ritm) validate(c *catalog) error {
+	var errs validationError
+	ritm.validateRecord(&errs)
+
+	spec, err := c.engine(ritm.Engine)
+	if err != nil {
+		errs.add("engine", "unsupported engine %q, expected one of: %s", ritm.Engine, strings.Join(c.families(), ", "))
+	}
+
+	ritm.validateIdentifier(&errs)
+	ritm.validateEnvironments(&errs, spec)
+
+	if spec != nil {
+		rules, _ := engineNamingRules(spec.Engine)
+		ritm.validateUsername(&errs, rules)
+		ritm.validateName(&errs, rules)
+		if _, err := ritm.parameterOverrides(spec); err != nil {
+			errs.add("parameter_overrides", "%v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateDecommission checks a decommission RITM, which names the RITM
+// that provisioned the databases to remove
+func (ritm *ritm) validateDecommission() error {
+	var errs validationError
+	ritm.validateRecord(&errs)
+
+	switch p := ritm.Provisioning; {
+	case !regexp.MustCompile(`^RITM[0-9]+$`).MatchString(p):
+		errs.add("provisioning_ritm", "must be a RITM number, got %q", p)
+	case p == ritm.Number:
+		errs.add("provisioning_ritm", "must not be the decommission RITM")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateModification checks a modification RITM, which names the instance
+// to change and the changes to make
+func (ritm *ritm) validateModification() error {
+	var errs validationError
+	ritm.validateRecord(&errs)
+
+	if !regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`).MatchString(ritm.Identifier) {
+		errs.add("identifier", "must be the RDS identifier of the instance, got %q", ritm.Identifier)
+	}
+	if ritm.Size != "" && !contains(sizeNames(), ritm.Size) {
+		errs.add("size", "must be one of %s, got %q", strings.Join(sizeNames(), ", "), ritm.Size)
+	}
+	if ritm.Storage != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(ritm.Storage))
+		if err != nil || n < minStorage || n > maxStorage {
+			errs.add("allocated_storage", "must be a number of GiB from %d to %d, got %q", minStorage, maxStorage, ritm.Storage)
+		}
+	}
+	if ritm.MultiAZ != "" && ritm.MultiAZ != yes && ritm.MultiAZ != no {
+		errs.add("multi_az", "must be %q or %q, got %q", yes, no, ritm.MultiAZ)
+	}
+	if ritm.MinorUpgrade != "" && ritm.MinorUpgrade != yes && ritm.MinorUpgrade != no {
+		errs.add("minor_version_upgrade", "must be %q or %q, got %q", yes, no, ritm.MinorUpgrade)
+	}
+	if ritm.Size == "" && ritm.Storage == "" && ritm.MultiAZ == "" && ritm.MinorUpgrade != yes {
+		errs.add("changes", "at least one of size, allocated_storage, multi_az or minor_version_upgrade must be set")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateUpgrade checks an upgrade RITM, which names the instance to upgrade
+// and the catalog engine family to upgrade it to
+func (ritm *ritm) validateUpgrade(c *catalog) error {
+	var errs validationError
+	ritm.validateRecord(&errs)
+
+	if !regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`).MatchString(ritm.Identifier) {
+		errs.add("identifier", "must be the RDS identifier of the instance, got %q", ritm.Identifier)
+	}
+	if _, ok := c.Engines[ritm.Engine]; !ok {
+		errs.add("engine", "unsupported engine %q, expected one of: %s", ritm.Engine, strings.Join(c.families(), ", "))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (ritm *ritm) validateRecord(errs *validationError) {
+	if !regexp.MustCompile(`^RITM[0-9]+$`).MatchString(ritm.Number) {
+		errs.add("number", "must be a RITM number, got %q", ritm.Number)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(ritm.SysID) {
+		errs.add("sys_id", "must be a 32 character hexadecimal sys_id, got %q", ritm.SysID)
+	}
+}
+
+func (ritm *ritm) validateIdentifier(errs *validationError) {
+	id := ritm.Identifier
+	switch {
+	case id == "":
+		errs.add("identifier", "must be set")
+		return
+	case !regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`).MatchString(id):
+		errs.add("identifier", "must start with a letter and contain only letters, digits and hyphens, got %q", id)
+	case strings.HasSuffix(id, "-") || strings.Contains(id, "--"):
+		errs.add("identifier", "cannot end with a hyphen or contain two consecutive hyphens, got %q", id)
+	}
+
+	// The monitoring role name is derived from the longest identifier, so it
+	// is only checked if every identifier is valid
+	longest := ""
+	for _, env := range ritm.environments() {
+		for _, generated := range env.instanceIdentifiers(ritm) {
+			if len(generated) > maxIdentifierLength {
+				errs.add("identifier", "generated identifier %q exceeds %d characters", generated, maxIdentifierLength)
+				return
+			}
+			if len(generated) > len(longest) {
+				longest = generated
+			}
+		}
+	}
+	if role := monitoringRoleName(longest); len(role) > maxRoleNameLength {
+		errs.add("identifier", "generated monitoring role name %q exceeds %d characters", role, maxRoleNameLength)
+	}
+}
+
+func (ritm *ritm) validateEnvironments(errs *validationError, spec *engineSpec) {
+	requested := 0
+	for _, env := range ritm.allEnvironments() {
+		n, err := strconv.Atoi(strings.TrimSpace(env.rawCount))
+		if err != nil || n < 0 || n > maxReplicas+1 {
+			errs.add(env.name+"_count", "must be a number from 0 to %d, got %q", maxReplicas+1, env.rawCount)
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		requested++
+
+		if spec != nil {
+			if _, err := spec.size(env.size); err != nil {
+				errs.add(env.name+"_size", "must be one of %s, got %q", strings.Join(sizeNames(), ", "), env.size)
+			}
+		}
+		if env.multiAZ != yes && env.multiAZ != no {
+			errs.add(env.name+"_multi_az", "must be %q or %q, got %q", yes, no, env.multiAZ)
+		}
+	}
+
+	if requested == 0 {
+		errs.add("count", "at least one environment must have a count greater than 0")
+	}
+}
+
+func (ritm *ritm) validateUsername(errs *validationError, rules namingRules) {
+	u := ritm.Username
+	switch {
+	case u == "":
+		errs.add("username", "must be set")
+	case len(u) > rules.usernameMaxLength:
+		errs.add("username", "must be at most %d characters, got %d", rules.usernameMaxLength, len(u))
+	case !rules.usernamePattern.MatchString(u):
+		errs.add("username", "%s, got %q", rules.usernameRule, u)
+	case contains(rules.reservedUsernames, strings.ToLower(u)):
+		errs.add("username", "%q is reserved", u)
+	}
+}
+
+func (ritm *ritm) validateName(errs *validationError, rules namingRules) {
+	n := ritm.Name
+	switch {
+	case n == "": // Optional, no initial database is created
+	case len(n) > rules.nameMaxLength:
+		errs.add("name", "must be at most %d characters, got %d", rules.nameMaxLength, len(n))
+	case !rules.namePattern.MatchString(n):
+		errs.add("name", "%s, got %q", rules.nameRule, n)
+	case contains(rules.reservedNames, strings.ToLower(n)):
+		errs.add("name", "%q is reserved", n)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
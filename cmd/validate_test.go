@@ -0,0 +1,192 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func validRITM() *ritm {
+	return &ritm{
+		Engine:      "postgres12",
+		Identifier:  "test-rds",
+		DevCount:    "1",
+		DevMultiAZ:  "No",
+		DevSize:     "small",
+		TestCount:   "0",
+		ProdCount:   "2",
+		ProdMultiAZ: "Yes",
+		ProdSize:    "large",
+		Name:        "testdb",
+		Number:      "RITM0001001",
+		SysID:       "0123456789abcdef0123456789abcdef",
+		Username:    "testuser",
+	}
+}
+
+// nolint: funlen
+func TestValidate(t *testing.T) {
+	tt := map[string]struct {
+		modify func(*ritm)
+		fields []string
+	}{
+		"valid": {
+			modify: func(*ritm) {},
+		},
+		"unknown engine": {
+			modify: func(r *ritm) { r.Engine = "oracle-ee" },
+			fields: []string{"engine"},
+		},
+		"empty identifier": {
+			modify: func(r *ritm) { r.Identifier = "" },
+			fields: []string{"identifier"},
+		},
+		"bad identifier characters": {
+			modify: func(r *ritm) { r.Identifier = "1test_rds" },
+			fields: []string{"identifier"},
+		},
+		"identifier too long": {
+			modify: func(r *ritm) { r.Identifier = "a" + strings.Repeat("b", 50) },
+			fields: []string{"identifier"},
+		},
+		"monitoring role name too long": {
+			modify: func(r *ritm) { r.Identifier = "a" + strings.Repeat("b", 39) },
+			fields: []string{"identifier"},
+		},
+		"bad size and multi-az": {
+			modify: func(r *ritm) { r.DevSize = "huge"; r.ProdMultiAZ = "true" },
+			fields: []string{"development_size", "production_multi_az"},
+		},
+		"bad count": {
+			modify: func(r *ritm) { r.TestCount = "many"; r.DevCount = "7" },
+			fields: []string{"development_count", "test_count"},
+		},
+		"no environments": {
+			modify: func(r *ritm) { r.DevCount = "0"; r.ProdCount = "0" },
+			fields: []string{"count"},
+		},
+		"reserved username": {
+			modify: func(r *ritm) { r.Username = "rdsadmin" },
+			fields: []string{"username"},
+		},
+		"mysql username too long": {
+			modify: func(r *ritm) { r.Engine = "mysql8.0"; r.Username = "averyveryverylongname" },
+			fields: []string{"username"},
+		},
+		"mysql name underscore": {
+			modify: func(r *ritm) { r.Engine = "mysql8.0"; r.Name = "test_db" },
+			fields: []string{"name"},
+		},
+		"postgres name underscore": {
+			modify: func(r *ritm) { r.Name = "_test_db" },
+		},
+		"multiple errors": {
+			modify: func(r *ritm) { r.Number = ""; r.SysID = "x"; r.Engine = ""; r.Username = "" },
+			fields: []string{"number", "sys_id", "engine"},
+		},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			r := validRITM()
+			tc.modify(r)
+			err := r.validate(testCatalog(t))
+			if len(tc.fields) == 0 {
+				if err != nil {
+					t.Fatalf("validate() failed: unexpected error: %v", err)
+				}
+				return
+			}
+			verr, ok := err.(validationError)
+			if !ok {
+				t.Fatalf("validate() failed: expected validationError, got: %T %v", err, err)
+			}
+			if len(verr) != len(tc.fields) {
+				t.Errorf("validate() failed: expected %d errors, got: %v", len(tc.fields), verr)
+			}
+			for _, f := range tc.fields {
+				if !strings.Contains(verr.Error(), f+": ") {
+					t.Errorf("validate() failed: expected error for field %s, got: %v", f, verr)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateDecommission(t *testing.T) {
+	tt := map[string]struct {
+		provisioning string
+		err          string
+	}{
+		"valid":    {provisioning: "RITM0001001"},
+		"missing":  {err: `invalid RITM (1 errors): provisioning_ritm: must be a RITM number, got ""`},
+		"same":     {provisioning: "RITM0002001", err: "invalid RITM (1 errors): provisioning_ritm: must not be the decommission RITM"},
+		"not ritm": {provisioning: "REQ0001001", err: `invalid RITM (1 errors): provisioning_ritm: must be a RITM number, got "REQ0001001"`},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			r := &ritm{Number: "RITM0002001", SysID: "fedcba9876543210fedcba9876543210", Provisioning: tc.provisioning}
+			err := r.validateDecommission()
+			if tc.err == "" && err != nil {
+				t.Errorf("validateDecommission() failed: unexpected error: %v", err)
+			} else if tc.err != "" && (err == nil || err.Error() != tc.err) {
+				t.Errorf("validateDecommission() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateModification(t *testing.T) {
+	tt := map[string]struct {
+		ritm ritm
+		err  string
+	}{
+		"valid":      {ritm: ritm{Identifier: "test-prod", Size: "large", Storage: "500", MultiAZ: yes}},
+		"minor only": {ritm: ritm{Identifier: "test-prod", MinorUpgrade: yes}},
+		"no changes": {ritm: ritm{Identifier: "test-prod", MinorUpgrade: no}, err: "changes: at least one of"},
+		"bad size":   {ritm: ritm{Identifier: "test-prod", Size: "huge"}, err: `size: must be one of small, medium, large, got "huge"`},
+		"bad storage": {
+			ritm: ritm{Identifier: "test-prod", Storage: "10"},
+			err:  `allocated_storage: must be a number of GiB from 20 to 65536, got "10"`,
+		},
+		"bad multi-az":  {ritm: ritm{Identifier: "test-prod", MultiAZ: "maybe"}, err: `multi_az: must be "Yes" or "No", got "maybe"`},
+		"no identifier": {ritm: ritm{Size: "small"}, err: `identifier: must be the RDS identifier of the instance, got ""`},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tc.ritm.Number = "RITM0003001"
+			tc.ritm.SysID = "00000000000000000000000000003001"
+			err := tc.ritm.validateModification()
+			if tc.err == "" && err != nil {
+				t.Errorf("validateModification() failed: unexpected error: %v", err)
+			} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("validateModification() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateUpgrade(t *testing.T) {
+	tt := map[string]struct {
+		ritm ritm
+		err  string
+	}{
+		"valid":         {ritm: ritm{Identifier: "test-prod", Engine: "postgres12"}},
+		"no identifier": {ritm: ritm{Engine: "postgres12"}, err: `identifier: must be the RDS identifier of the instance, got ""`},
+		"bad engine":    {ritm: ritm{Identifier: "test-prod", Engine: "postgres99"}, err: `engine: unsupported engine "postgres99"`},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tc.ritm.Number = "RITM0004001"
+			tc.ritm.SysID = "00000000000000000000000000004001"
+			err := tc.ritm.validateUpgrade(testCatalog(t))
+			if tc.err == "" && err != nil {
+				t.Errorf("validateUpgrade() failed: unexpected error: %v", err)
+			} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("validateUpgrade() failed: expected error: %s\nGot: %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v28/github"
+)
+
+const (
+	envWebhookSecret = "GITHUB_WEBHOOK_SECRET"
+	maxWebhookSize   = 25 << 20 // GitHub caps webhook payloads at 25 MB
+)
+
+// mergeEvents passes the pull requests a webhook reports closed to the
+// pipelines waiting for them to be merged
+type mergeEvents struct {
+	mu      sync.Mutex
+	waiting map[string]chan *pullRequest // by repository and pull request number
+}
+
+func newMergeEvents() *mergeEvents {
+	return &mergeEvents{waiting: map[string]chan *pullRequest{}}
+}
+
+func mergeKey(repo string, number int) string {
+	return fmt.Sprintf("%s#%d", strings.ToLower(repo), number)
+}
+
+// wait returns a channel receiving the pull request when it is closed, and a
+// function to call when it is no longer needed
+func (m *mergeEvents) wait(repo string, number int) (<-chan *pullRequest, func()) {
+	key := mergeKey(repo, number)
+	ch := make(chan *pullRequest, 1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.waiting[key] = ch
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.waiting[key] == ch {
+			delete(m.waiting, key)
+		}
+	}
+}
+
+// notify passes the closed pull request to the pipeline waiting for it,
+// returning false if none is waiting. It replaces any earlier event the
+// pipeline has not received yet.
+func (m *mergeEvents) notify(repo string, pr *pullRequest) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch, ok := m.waiting[mergeKey(repo, pr.Number)]
+	if ok {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- pr // Only sent to with the lock held, so there is room
+	}
+	return ok
+}
+
+// webhook receives the GitHub pull_request events of the repository
+type webhook struct {
+	secret []byte
+	owner  string
+	repo   string
+	events *mergeEvents
+	resume func() // resumes the pipelines when none is waiting for the pull request
+}
+
+// pullRequestEvent is the part of a GitHub pull_request event that is used
+type pullRequestEvent struct {
+	Action      string             `json:"action"`
+	Number      int                `json:"number"`
+	PullRequest github.PullRequest `json:"pull_request"`
+	Repository  struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		http.Error(w, "unable to read payload", http.StatusBadRequest)
+		return
+	}
+	if !validSignature(h.secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("X-GitHub-Event") != "pull_request" {
+		w.WriteHeader(http.StatusNoContent) // ping and other events are ignored
+		return
+	}
+
+	var event pullRequestEvent
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		http.Error(w, "invalid pull_request event", http.StatusBadRequest)
+		return
+	}
+	if event.Action != "closed" || !strings.EqualFold(event.Repository.Owner.Login, h.owner) ||
+		!strings.EqualFold(event.Repository.Name, h.repo) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	pr := fromGitHub(&event.PullRequest)
+	pr.Number = event.Number
+	fmt.Printf("Pull request %s#%d closed, merged: %t\n", h.repo, pr.Number, pr.Merged)
+	if !h.events.notify(h.repo, pr) {
+		h.resume()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validSignature checks the X-Hub-Signature-256 header, the hex encoded
+// HMAC-SHA256 of the payload with the webhook secret
+func validSignature(secret, body []byte, header string) bool {
+	sig, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil || !strings.HasPrefix(header, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	body := `{"action":"closed"}`
+	tt := map[string]struct {
+		header   string
+		expected bool
+	}{
+		"valid":      {header: sign("secret", body), expected: true},
+		"wrong key":  {header: sign("other", body)},
+		"no prefix":  {header: strings.TrimPrefix(sign("secret", body), "sha256=")},
+		"sha1":       {header: "sha1=" + strings.TrimPrefix(sign("secret", body), "sha256=")},
+		"not hex":    {header: "sha256=zz"},
+		"no header":  {},
+		"other body": {header: sign("secret", `{"action":"opened"}`)},
+	}
+	for name, tc := range tt {
+		if got := validSignature([]byte("secret"), []byte(body), tc.header); got != tc.expected {
+			t.Errorf("validSignature() failed: %s: expected %t, got: %t", name, tc.expected, got)
+		}
+	}
+}
+
+// nolint: funlen
+func TestWebhook(t *testing.T) {
+	closed := `{"action":"closed","number":7,"pull_request":{"number":7,"state":"closed","merged":true,` +
+		`"merged_at":"2021-05-01T12:00:00Z","merge_commit_sha":"fed789","base":{"ref":"main"},"head":{"sha":"def456"}},` +
+		`"repository":{"name":"Test-Repo","owner":{"login":"gsa"}}}`
+	tt := map[string]struct {
+		method    string
+		event     string
+		body      string
+		signature string
+		status    int
+		notified  bool
+		resumed   bool
+	}{
+		"merged": {event: "pull_request", body: closed, status: http.StatusAccepted, notified: true},
+		"not waiting": {
+			event:   "pull_request",
+			body:    strings.Replace(closed, `"number":7`, `"number":8`, 1),
+			status:  http.StatusAccepted,
+			resumed: true,
+		},
+		"opened":     {event: "pull_request", body: strings.Replace(closed, "closed", "opened", 1), status: http.StatusNoContent},
+		"other repo": {event: "pull_request", body: strings.Replace(closed, "Test-Repo", "other", 1), status: http.StatusNoContent},
+		"ping":       {event: "ping", body: `{"zen":"test"}`, status: http.StatusNoContent},
+		"invalid":    {event: "pull_request", body: "{", status: http.StatusBadRequest},
+		"unsigned":   {event: "pull_request", body: closed, signature: "sha256=00", status: http.StatusUnauthorized},
+		"get":        {method: http.MethodGet, status: http.StatusMethodNotAllowed},
+	}
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			resumed := false
+			h := &webhook{
+				secret: []byte("secret"),
+				owner:  "GSA",
+				repo:   "test-repo",
+				events: newMergeEvents(),
+				resume: func() { resumed = true },
+			}
+			ch, done := h.events.wait("test-repo", 7)
+			defer done()
+
+			method := http.MethodPost
+			if tc.method != "" {
+				method = tc.method
+			}
+			req := httptest.NewRequest(method, "/", strings.NewReader(tc.body))
+			req.Header.Set("X-GitHub-Event", tc.event)
+			req.Header.Set("X-Hub-Signature-256", sign("secret", tc.body))
+			if tc.signature != "" {
+				req.Header.Set("X-Hub-Signature-256", tc.signature)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Errorf("ServeHTTP() failed: expected status %d, got: %d", tc.status, w.Code)
+			}
+			notified := false
+			select {
+			case pr := <-ch:
+				notified = true
+				if pr.Number != 7 || pr.State != "closed" || !pr.Merged || pr.MergedAt.IsZero() || pr.Base != "main" ||
+					pr.mergedSHA() != "fed789" {
+					t.Errorf("ServeHTTP() failed: unexpected pull request: %+v", pr)
+				}
+			default:
+			}
+			if notified != tc.notified || resumed != tc.resumed {
+				t.Errorf("ServeHTTP() failed: expected notified %t and resumed %t, got: %t and %t",
+					tc.notified, tc.resumed, notified, resumed)
+			}
+		})
+	}
+}
+
+func TestMergeEvents(t *testing.T) {
+	m := newMergeEvents()
+	closed, merged := &pullRequest{Number: 7, State: "closed"}, &pullRequest{Number: 7, State: "closed", Merged: true}
+	if m.notify("test-repo", closed) {
+		t.Errorf("notify() failed: notified without a waiting pipeline")
+	}
+
+	ch, done := m.wait("test-repo", 7)
+	if !m.notify("TEST-REPO", closed) || !m.notify("test-repo", merged) {
+		t.Errorf("notify() failed: waiting pipeline not notified")
+	}
+	if pr := <-ch; pr != merged {
+		t.Errorf("notify() failed: expected the latest event, got: %+v", pr)
+	}
+	select {
+	case <-ch:
+		t.Errorf("notify() failed: repeated events not merged")
+	default:
+	}
+
+	done()
+	if m.notify("test-repo", closed) {
+		t.Errorf("notify() failed: notified after the wait was done")
+	}
+}